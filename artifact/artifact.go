@@ -0,0 +1,138 @@
+// Package artifact stores named build outputs that pipeline steps publish
+// and restore. Every artifact carries a SHA-256 checksum computed on upload
+// and verified on demand.
+package artifact
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when an artifact does not exist.
+	ErrNotFound = errors.New("artifact: not found")
+
+	// ErrInvalidName is returned for empty, absolute or escaping names.
+	ErrInvalidName = errors.New("artifact: invalid name")
+
+	// ErrChecksum is returned when stored content no longer matches its
+	// checksum.
+	ErrChecksum = errors.New("artifact: checksum mismatch")
+
+	// ErrConflict is returned by Local when a name is both an artifact
+	// and the directory of another one, e.g. "a" and "a/b".
+	ErrConflict = errors.New("artifact: name conflicts with an existing artifact")
+)
+
+// Info describes a stored artifact.
+type Info struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	SHA256  string    `json:"sha256"`
+	Created time.Time `json:"created"`
+}
+
+// Store is implemented by every artifact backend.
+type Store interface {
+	// Put stores content under name, replacing any previous artifact.
+	Put(ctx context.Context, name string, content io.Reader) (Info, error)
+
+	// Open returns the content of an artifact.
+	Open(ctx context.Context, name string) (io.ReadSeekCloser, Info, error)
+
+	Stat(ctx context.Context, name string) (Info, error)
+
+	// List returns every artifact whose name starts with prefix.
+	// Entries that cannot be read are skipped.
+	List(ctx context.Context, prefix string) ([]Info, error)
+
+	Delete(ctx context.Context, name string) error
+}
+
+// CleanName validates an artifact name such as "pipeline/run/report.tar"
+// and returns its canonical form.
+func CleanName(name string) (string, error) {
+	if len(name) == 0 || strings.HasPrefix(name, "/") || strings.Contains(name, "\\") {
+		return "", ErrInvalidName
+	}
+
+	clean := path.Clean(name)
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", ErrInvalidName
+	}
+	return clean, nil
+}
+
+// Prune deletes artifacts under prefix created before now minus age and
+// returns the deleted entries. An artifact that cannot be deleted doesn't
+// stop the others from being pruned; the first such error is returned.
+func Prune(ctx context.Context, store Store, prefix string, age time.Duration) ([]Info, error) {
+	items, err := store.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(-age)
+	pruned := []Info{}
+
+	var failure error
+	for _, item := range items {
+		if err := ctx.Err(); err != nil {
+			return pruned, err
+		}
+
+		if !item.Created.Before(deadline) {
+			continue
+		}
+
+		err := store.Delete(ctx, item.Name)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			if failure == nil {
+				failure = fmt.Errorf("artifact: prune %s: %w", item.Name, err)
+			}
+			continue
+		}
+		pruned = append(pruned, item)
+	}
+	return pruned, failure
+}
+
+// Verify recomputes the checksum of an artifact and returns ErrChecksum
+// when the stored content was altered.
+func Verify(ctx context.Context, store Store, name string) error {
+	content, info, err := store.Open(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	digest := sha256.New()
+	if _, err := io.Copy(digest, &contextReader{ctx, content}); err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(digest.Sum(nil)) != info.SHA256 {
+		return ErrChecksum
+	}
+	return nil
+}
+
+// contextReader aborts long copies once the context is done.
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r *contextReader) Read(data []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(data)
+}
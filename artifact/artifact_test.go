@@ -0,0 +1,497 @@
+package artifact
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is a path-style S3 bucket checking request signatures.
+type fakeS3 struct {
+	t       *testing.T
+	signer  *signer
+	mu      sync.Mutex
+	objects map[string]fakeObject
+	version int
+
+	// ignoreRange answers ranged GETs with the whole object.
+	ignoreRange bool
+}
+
+type fakeObject struct {
+	content  []byte
+	metadata http.Header
+	etag     string
+}
+
+func newFakeS3(t *testing.T) (*S3, *fakeS3) {
+	fake := &fakeS3{
+		t:       t,
+		signer:  &signer{accessKey: "AK", secretKey: "SK", region: "us-east-1"},
+		objects: make(map[string]fakeObject),
+	}
+
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	store, err := NewS3(S3Options{
+		Endpoint:  server.URL,
+		Bucket:    "builds",
+		Prefix:    "ci/",
+		AccessKey: "AK",
+		SecretKey: "SK",
+		PathStyle: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, fake
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Recompute the signature from what arrived on the wire.
+	check := r.Clone(r.Context())
+	check.URL.Host = r.Host
+	date, _ := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	f.signer.sign(check, r.Header.Get("X-Amz-Content-Sha256"), date)
+
+	if got, want := r.Header.Get("Authorization"), check.Header.Get("Authorization"); got != want {
+		f.t.Errorf("bad signature for %s %s", r.Method, r.URL)
+		http.Error(w, "<Error><Code>SignatureDoesNotMatch</Code></Error>", http.StatusForbidden)
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, "/builds/") {
+		http.Error(w, "<Error><Code>NoSuchBucket</Code></Error>", http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/builds/")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(key) == 0 && r.URL.Query().Get("list-type") == "2" {
+		f.list(w, r)
+		return
+	}
+
+	object, ok := f.objects[key]
+
+	switch r.Method {
+	case http.MethodPut:
+		content, _ := io.ReadAll(r.Body)
+		f.version++
+		f.objects[key] = fakeObject{
+			content:  content,
+			metadata: r.Header.Clone(),
+			etag:     `"` + strconv.Itoa(f.version) + `"`,
+		}
+
+	case http.MethodHead, http.MethodGet:
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if match := r.Header.Get("If-Match"); len(match) > 0 && match != object.etag {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+
+		w.Header().Set("ETag", object.etag)
+		w.Header().Set("X-Amz-Meta-Sha256", object.metadata.Get("X-Amz-Meta-Sha256"))
+		w.Header().Set("X-Amz-Meta-Created", object.metadata.Get("X-Amz-Meta-Created"))
+		if f.ignoreRange {
+			r.Header.Del("Range")
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(object.content))
+
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// list answers ListObjectsV2 two keys per page.
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, r.URL.Query().Get("prefix")) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	start, _ := strconv.Atoi(r.URL.Query().Get("continuation-token"))
+	end := start + 2
+	if end > len(keys) {
+		end = len(keys)
+	}
+
+	type content struct {
+		Key string
+	}
+	page := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Contents              []content
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+	}{IsTruncated: end < len(keys)}
+
+	for _, key := range keys[start:end] {
+		page.Contents = append(page.Contents, content{key})
+	}
+	if page.IsTruncated {
+		page.NextContinuationToken = strconv.Itoa(end)
+	}
+
+	xml.NewEncoder(w).Encode(page)
+}
+
+func TestStores(t *testing.T) {
+	stores := []struct {
+		name string
+		open func(t *testing.T) Store
+	}{
+		{"local", func(t *testing.T) Store {
+			store, err := NewLocal(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store
+		}},
+		{"s3", func(t *testing.T) Store {
+			store, _ := newFakeS3(t)
+			return store
+		}},
+	}
+
+	scenarios := []struct {
+		name string
+		run  func(t *testing.T, store Store)
+	}{
+		{"put and open", testPutOpen},
+		{"seek", testSeek},
+		{"list and prune", testListPrune},
+		{"delete", testDelete},
+		{"invalid names", testInvalidNames},
+	}
+
+	for _, backend := range stores {
+		for _, scenario := range scenarios {
+			t.Run(backend.name+"/"+scenario.name, func(t *testing.T) {
+				scenario.run(t, backend.open(t))
+			})
+		}
+	}
+}
+
+func testPutOpen(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	info, err := store.Put(ctx, "pipeline/1/report.txt", strings.NewReader("all tests passed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, opened, err := store.Open(ctx, "pipeline/1/report.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer content.Close()
+
+	raw, _ := io.ReadAll(content)
+	if string(raw) != "all tests passed" || opened.SHA256 != info.SHA256 || opened.Size != 16 {
+		t.Errorf("opened %q, %+v; put %+v", raw, opened, info)
+	}
+
+	if err := Verify(ctx, store, "pipeline/1/report.txt"); err != nil {
+		t.Errorf("Verify = %v", err)
+	}
+	if _, err := store.Stat(ctx, "pipeline/1/absent"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat of a missing artifact = %v", err)
+	}
+}
+
+func testSeek(t *testing.T, store Store) {
+	ctx := context.Background()
+	store.Put(ctx, "data.bin", strings.NewReader("0123456789"))
+
+	content, _, err := store.Open(ctx, "data.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer content.Close()
+
+	tests := []struct {
+		offset int64
+		whence int
+		want   string
+	}{
+		{2, io.SeekStart, "234"},
+		{-3, io.SeekEnd, "789"},
+		{-6, io.SeekCurrent, "456"},
+	}
+
+	for _, test := range tests {
+		if _, err := content.Seek(test.offset, test.whence); err != nil {
+			t.Fatal(err)
+		}
+
+		chunk := make([]byte, 3)
+		if _, err := io.ReadFull(content, chunk); err != nil || string(chunk) != test.want {
+			t.Errorf("after Seek(%d, %d) read %q, %v; want %q", test.offset, test.whence, chunk, err, test.want)
+		}
+	}
+}
+
+func testListPrune(t *testing.T, store Store) {
+	ctx := context.Background()
+	for _, name := range []string{"a/1", "a/2", "a/3", "b/1", "b/.hidden"} {
+		if _, err := store.Put(ctx, name, strings.NewReader(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	items, err := store.List(ctx, "a/")
+	if err != nil || len(items) != 3 {
+		t.Fatalf("List = %d items, %v", len(items), err)
+	}
+
+	pruned, err := Prune(ctx, store, "b/", -time.Minute)
+	if err != nil || len(pruned) != 2 {
+		t.Fatalf("Prune = %d items, %v", len(pruned), err)
+	}
+
+	if items, _ := store.List(ctx, ""); len(items) != 3 {
+		t.Errorf("%d artifacts left; want 3", len(items))
+	}
+	if pruned, _ := Prune(ctx, store, "", time.Hour); len(pruned) != 0 {
+		t.Errorf("Prune removed %d recent artifacts", len(pruned))
+	}
+}
+
+func testDelete(t *testing.T, store Store) {
+	ctx := context.Background()
+	store.Put(ctx, "old.log", strings.NewReader("old"))
+
+	if err := store.Delete(ctx, "old.log"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, "old.log"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Delete = %v", err)
+	}
+}
+
+func testInvalidNames(t *testing.T, store Store) {
+	for _, name := range []string{"", "/etc/passwd", "../escape", "a/../../b", `a\b`} {
+		if _, err := store.Put(context.Background(), name, strings.NewReader("x")); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("Put(%q) = %v", name, err)
+		}
+	}
+}
+
+func TestLocalConflicts(t *testing.T) {
+	tests := []struct {
+		name   string
+		first  string
+		second string
+	}{
+		{"file then directory", "a", "a/b"},
+		{"directory then file", "a/b", "a"},
+		{"nested", "a/b/c", "a/b"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store, _ := NewLocal(t.TempDir())
+			ctx := context.Background()
+
+			if _, err := store.Put(ctx, test.first, strings.NewReader("x")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Put(ctx, test.second, strings.NewReader("y")); !errors.Is(err, ErrConflict) {
+				t.Errorf("Put(%q) = %v; want ErrConflict", test.second, err)
+			}
+			if _, err := store.Put(ctx, test.first, strings.NewReader("z")); err != nil {
+				t.Errorf("replacing %q = %v", test.first, err)
+			}
+		})
+	}
+}
+
+func TestLocalSkipsBrokenEntries(t *testing.T) {
+	root := t.TempDir()
+	store, _ := NewLocal(root)
+	ctx := context.Background()
+
+	store.Put(ctx, "good", strings.NewReader("ok"))
+	os.WriteFile(filepath.Join(root, "meta", "broken.json"), []byte("{"), 0o644)
+
+	items, err := store.List(ctx, "")
+	if err != nil || len(items) != 1 || items[0].Name != "good" {
+		t.Errorf("List = %+v, %v", items, err)
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(root, "meta"))
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			t.Errorf("temporary file %s left behind", entry.Name())
+		}
+	}
+}
+
+// failingStore refuses to delete one artifact.
+type failingStore struct {
+	Store
+	broken string
+}
+
+func (s failingStore) Delete(ctx context.Context, name string) error {
+	if name == s.broken {
+		return errors.New("permission denied")
+	}
+	return s.Store.Delete(ctx, name)
+}
+
+func TestPruneContinues(t *testing.T) {
+	local, _ := NewLocal(t.TempDir())
+	ctx := context.Background()
+
+	for _, name := range []string{"a", "b", "c"} {
+		local.Put(ctx, name, strings.NewReader(name))
+	}
+
+	pruned, err := Prune(ctx, failingStore{Store: local, broken: "b"}, "", -time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "prune b") {
+		t.Errorf("Prune error = %v", err)
+	}
+	if len(pruned) != 2 {
+		t.Errorf("pruned %d artifacts; want 2", len(pruned))
+	}
+}
+
+func TestHandlers(t *testing.T) {
+	local, _ := NewLocal(t.TempDir())
+	content := strings.Repeat("compile ok\n", 500)
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	io.WriteString(writer, content)
+	writer.Close()
+
+	upload := Upload(local, 1<<20)
+	tests := []struct {
+		name     string
+		body     io.Reader
+		encoding string
+		status   int
+	}{
+		{"plain", strings.NewReader(content), "", http.StatusCreated},
+		{"gzip", bytes.NewReader(compressed.Bytes()), "gzip", http.StatusCreated},
+		{"unsupported", strings.NewReader(content), "br", http.StatusUnsupportedMediaType},
+		{"corrupt", strings.NewReader("not gzip"), "gzip", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, "/logs/"+test.name+".txt", test.body)
+			r.Header.Set("Content-Encoding", test.encoding)
+
+			w := httptest.NewRecorder()
+			upload.ServeHTTP(w, r)
+
+			if w.Code != test.status {
+				t.Fatalf("status = %d: %s", w.Code, w.Body)
+			}
+			if w.Code != http.StatusCreated {
+				return
+			}
+
+			var info Info
+			json.NewDecoder(w.Body).Decode(&info)
+			if info.Size != int64(len(content)) {
+				t.Errorf("stored %d bytes", info.Size)
+			}
+		})
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/logs/gzip.txt", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	Handler(local).ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("download = %d, %q", w.Code, w.Header().Get("Content-Encoding"))
+	}
+
+	reader, _ := gzip.NewReader(w.Body)
+	if raw, _ := io.ReadAll(reader); string(raw) != content {
+		t.Errorf("downloaded %d bytes", len(raw))
+	}
+}
+
+func TestS3Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		opts S3Options
+	}{
+		{"no endpoint", S3Options{Bucket: "b"}},
+		{"no bucket", S3Options{Endpoint: "https://s3.example.com"}},
+	}
+
+	for _, test := range tests {
+		if _, err := NewS3(test.opts); err == nil {
+			t.Errorf("%s: NewS3 accepted %+v", test.name, test.opts)
+		}
+	}
+
+	store, fake := newFakeS3(t)
+	store.Put(context.Background(), "changing", strings.NewReader("first"))
+
+	content, _, err := store.Open(context.Background(), "changing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer content.Close()
+
+	fake.mu.Lock()
+	object := fake.objects["ci/changing"]
+	object.etag = `"replaced"`
+	fake.objects["ci/changing"] = object
+	fake.mu.Unlock()
+
+	if _, err := io.ReadAll(content); err == nil || !strings.Contains(err.Error(), fmt.Sprint(http.StatusPreconditionFailed)) {
+		t.Errorf("reading a replaced object = %v", err)
+	}
+
+	store.Put(context.Background(), "whole", strings.NewReader("0123456789"))
+	fake.mu.Lock()
+	fake.ignoreRange = true
+	fake.mu.Unlock()
+
+	content, _, err = store.Open(context.Background(), "whole")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer content.Close()
+
+	content.Seek(5, io.SeekStart)
+	if raw, err := io.ReadAll(content); err == nil {
+		t.Errorf("read %q from offset 5 of a server ignoring Range", raw)
+	}
+}
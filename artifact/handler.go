@@ -0,0 +1,111 @@
+package artifact
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+
+	"devops.io/cloud/compress"
+	"devops.io/cloud/download"
+)
+
+// Handler serves a store over HTTP. It must be mounted with the mount
+// prefix stripped, e.g. with http.StripPrefix:
+//
+//	GET  /{name}        downloads an artifact, honoring Range and ETag,
+//	                    gzip-compressed when the client accepts it
+//	GET  /?prefix=...   lists artifacts as JSON
+func Handler(store Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		if len(name) == 0 {
+			list(w, r, store)
+			return
+		}
+
+		content, info, err := store.Open(r.Context(), name)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			http.Error(w, "artifact not found", http.StatusNotFound)
+			return
+		case errors.Is(err, ErrInvalidName):
+			http.Error(w, "invalid artifact name", http.StatusBadRequest)
+			return
+		case err != nil:
+			http.Error(w, "cannot open artifact", http.StatusInternalServerError)
+			return
+		}
+		defer content.Close()
+
+		w.Header().Set("X-Checksum-Sha256", info.SHA256)
+		download.Serve(w, r, content, info.Created, download.Options{
+			Name:     path.Base(info.Name),
+			ETag:     info.SHA256,
+			Compress: &compress.Options{Kind: "artifact-download"},
+		})
+	})
+}
+
+// Upload stores the body of PUT /{name} requests, mounted like Handler.
+// Bodies may be sent with "Content-Encoding: gzip"; limit caps the
+// decompressed size and zero disables it. It answers the Info of the
+// stored artifact as JSON.
+func Upload(store Store, limit int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			w.Header().Set("Allow", "PUT")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if limit > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+
+		err := compress.Decode(r, limit, compress.Options{Kind: "artifact-upload"})
+		switch {
+		case errors.Is(err, compress.ErrUnsupported):
+			http.Error(w, "unsupported content encoding", http.StatusUnsupportedMediaType)
+			return
+		case err != nil:
+			http.Error(w, "malformed body", http.StatusBadRequest)
+			return
+		}
+
+		info, err := store.Put(r.Context(), strings.TrimPrefix(r.URL.Path, "/"), r.Body)
+		switch {
+		case errors.Is(err, ErrInvalidName):
+			http.Error(w, "invalid artifact name", http.StatusBadRequest)
+			return
+		case errors.Is(err, compress.ErrTooLarge), err != nil && err.Error() == "http: request body too large":
+			http.Error(w, "artifact too large", http.StatusRequestEntityTooLarge)
+			return
+		case err != nil:
+			http.Error(w, "cannot store artifact", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(info)
+	})
+}
+
+func list(w http.ResponseWriter, r *http.Request, store Store) {
+	items, err := store.List(r.Context(), r.URL.Query().Get("prefix"))
+	if err != nil {
+		http.Error(w, "cannot list artifacts", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
@@ -0,0 +1,226 @@
+package artifact
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Local stores artifacts in a directory. Content lives under data/ and
+// the metadata of each artifact in a JSON file under meta/.
+type Local struct {
+	Root string
+}
+
+// NewLocal creates the directory layout of a local store.
+func NewLocal(root string) (*Local, error) {
+	for _, dir := range []string{"data", "meta"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &Local{Root: root}, nil
+}
+
+func (l *Local) paths(name string) (string, string, error) {
+	clean, err := CleanName(name)
+	if err != nil {
+		return "", "", err
+	}
+
+	native := filepath.FromSlash(clean)
+	return filepath.Join(l.Root, "data", native),
+		filepath.Join(l.Root, "meta", native+".json"), nil
+}
+
+// Put implements Store. Content is written to a temporary file and moved
+// into place only once it was fully received; so is the metadata, so
+// readers never see a partial JSON file.
+func (l *Local) Put(ctx context.Context, name string, content io.Reader) (Info, error) {
+	data, meta, err := l.paths(name)
+	if err != nil {
+		return Info{}, err
+	}
+
+	if err := l.conflict(name, data); err != nil {
+		return Info{}, err
+	}
+
+	for _, dir := range []string{filepath.Dir(data), filepath.Dir(meta)} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return Info{}, err
+		}
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(data), ".artifact-*")
+	if err != nil {
+		return Info{}, err
+	}
+	defer os.Remove(temp.Name())
+
+	digest := sha256.New()
+	size, err := io.Copy(io.MultiWriter(temp, digest), &contextReader{ctx, content})
+	if err == nil {
+		err = temp.Close()
+	} else {
+		temp.Close()
+	}
+
+	if err != nil {
+		return Info{}, err
+	}
+
+	info := Info{
+		Name:    path.Clean(name),
+		Size:    size,
+		SHA256:  hex.EncodeToString(digest.Sum(nil)),
+		Created: time.Now().UTC(),
+	}
+
+	if err := os.Rename(temp.Name(), data); err != nil {
+		return Info{}, err
+	}
+
+	raw, _ := json.Marshal(info)
+	if err := writeFile(meta, raw); err != nil {
+		return Info{}, err
+	}
+	return info, nil
+}
+
+// conflict reports ErrConflict when name or one of its parent directories
+// is already used the other way round.
+func (l *Local) conflict(name, data string) error {
+	if stat, err := os.Stat(data); err == nil && stat.IsDir() {
+		return fmt.Errorf("%w: %s is a directory of artifacts", ErrConflict, name)
+	}
+
+	for parent := path.Dir(path.Clean(name)); parent != "."; parent = path.Dir(parent) {
+		_, meta, _ := l.paths(parent)
+		if _, err := os.Stat(meta); err == nil {
+			return fmt.Errorf("%w: %s is an artifact", ErrConflict, parent)
+		}
+	}
+	return nil
+}
+
+// writeFile replaces file atomically with content.
+func writeFile(file string, content []byte) error {
+	temp, err := os.CreateTemp(filepath.Dir(file), ".meta-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(content); err != nil {
+		temp.Close()
+		return err
+	}
+
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), file)
+}
+
+// Open implements Store.
+func (l *Local) Open(ctx context.Context, name string) (io.ReadSeekCloser, Info, error) {
+	info, err := l.Stat(ctx, name)
+	if err != nil {
+		return nil, Info{}, err
+	}
+
+	data, _, _ := l.paths(name)
+	file, err := os.Open(data)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, Info{}, ErrNotFound
+	} else if err != nil {
+		return nil, Info{}, err
+	}
+	return file, info, nil
+}
+
+// Stat implements Store.
+func (l *Local) Stat(ctx context.Context, name string) (Info, error) {
+	_, meta, err := l.paths(name)
+	if err != nil {
+		return Info{}, err
+	}
+
+	raw, err := os.ReadFile(meta)
+	if errors.Is(err, os.ErrNotExist) {
+		return Info{}, ErrNotFound
+	} else if err != nil {
+		return Info{}, err
+	}
+
+	var info Info
+	if err := json.Unmarshal(raw, &info); err != nil {
+		return Info{}, err
+	}
+	return info, nil
+}
+
+// List implements Store.
+func (l *Local) List(ctx context.Context, prefix string) ([]Info, error) {
+	root := filepath.Join(l.Root, "meta")
+	items := []Info{}
+
+	err := filepath.WalkDir(root, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// An unreadable directory is skipped, not the whole listing.
+			if file == root {
+				return err
+			} else if entry != nil && entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		} else if entry.IsDir() || !strings.HasSuffix(file, ".json") {
+			// Temporary ".meta-*" files never end in ".json"; dot names
+			// are otherwise valid artifacts and must be listed.
+			return nil
+		}
+
+		rel, _ := filepath.Rel(root, strings.TrimSuffix(file, ".json"))
+		name := filepath.ToSlash(rel)
+
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+
+		if info, err := l.Stat(ctx, name); err == nil {
+			items = append(items, info)
+		}
+		return ctx.Err()
+	})
+	return items, err
+}
+
+// Delete implements Store.
+func (l *Local) Delete(ctx context.Context, name string) error {
+	data, meta, err := l.paths(name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(meta); errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+
+	if err := os.Remove(data); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
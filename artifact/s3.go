@@ -0,0 +1,386 @@
+package artifact
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Options configures an S3 store.
+type S3Options struct {
+	// Endpoint is the base URL of the service, e.g.
+	// "https://s3.eu-west-1.amazonaws.com" or a MinIO address.
+	Endpoint string
+
+	// Region defaults to "us-east-1", which most S3-compatible services
+	// accept.
+	Region string
+	Bucket string
+
+	// Prefix is prepended to every object key, e.g. "artifacts/".
+	Prefix string
+
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+
+	// PathStyle addresses objects as {endpoint}/{bucket}/{key} instead
+	// of {bucket}.{endpoint host}/{key}; most self-hosted services need
+	// it.
+	PathStyle bool
+
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// S3 stores artifacts in an S3-compatible bucket. The checksum and
+// creation time of each artifact are kept in the object's user metadata.
+type S3 struct {
+	opts     S3Options
+	endpoint *url.URL
+	signer   *signer
+}
+
+// NewS3 creates an S3 store. It doesn't contact the service.
+func NewS3(opts S3Options) (*S3, error) {
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil || len(endpoint.Host) == 0 {
+		return nil, fmt.Errorf("artifact: invalid S3 endpoint %q", opts.Endpoint)
+	}
+	if len(opts.Bucket) == 0 {
+		return nil, errors.New("artifact: S3 bucket is required")
+	}
+	if len(opts.Region) == 0 {
+		opts.Region = "us-east-1"
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	return &S3{
+		opts:     opts,
+		endpoint: endpoint,
+		signer: &signer{
+			accessKey:    opts.AccessKey,
+			secretKey:    opts.SecretKey,
+			sessionToken: opts.SessionToken,
+			region:       opts.Region,
+		},
+	}, nil
+}
+
+// url returns the address of key, or of the bucket when key is empty.
+func (s *S3) url(key string, query url.Values) *url.URL {
+	target := *s.endpoint
+	target.RawQuery = query.Encode()
+
+	base := strings.TrimSuffix(target.Path, "/")
+	if s.opts.PathStyle {
+		base += "/" + s.opts.Bucket
+	} else {
+		target.Host = s.opts.Bucket + "." + target.Host
+	}
+
+	target.Path = base + "/" + key
+	target.RawPath = ""
+	return &target
+}
+
+func (s *S3) do(ctx context.Context, method string, target *url.URL, body io.Reader, payload string, header http.Header) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range header {
+		request.Header[name] = values
+	}
+
+	s.signer.sign(request, payload, time.Now())
+	return s.opts.Client.Do(request)
+}
+
+// failure turns an unexpected response into an error and closes its body.
+func failure(response *http.Response, key string) error {
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+
+	var reply struct {
+		Code    string
+		Message string
+	}
+	raw, _ := io.ReadAll(io.LimitReader(response.Body, 64<<10))
+	xml.Unmarshal(raw, &reply)
+
+	return fmt.Errorf("artifact: S3 %s %s: %s %s", response.Request.Method, key, response.Status, reply.Code)
+}
+
+// Put implements Store. The content is spooled to a temporary file first
+// because a signed upload needs its length and checksum up front.
+func (s *S3) Put(ctx context.Context, name string, content io.Reader) (Info, error) {
+	clean, err := CleanName(name)
+	if err != nil {
+		return Info{}, err
+	}
+
+	temp, err := os.CreateTemp("", "artifact-*")
+	if err != nil {
+		return Info{}, err
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	digest := sha256.New()
+	size, err := io.Copy(io.MultiWriter(temp, digest), &contextReader{ctx, content})
+	if err != nil {
+		return Info{}, err
+	}
+
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		return Info{}, err
+	}
+
+	info := Info{
+		Name:    clean,
+		Size:    size,
+		SHA256:  hex.EncodeToString(digest.Sum(nil)),
+		Created: time.Now().UTC(),
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+	header.Set("X-Amz-Meta-Sha256", info.SHA256)
+	header.Set("X-Amz-Meta-Created", info.Created.Format(time.RFC3339Nano))
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPut, s.url(s.opts.Prefix+clean, nil).String(), temp)
+	if err != nil {
+		return Info{}, err
+	}
+
+	request.ContentLength = size
+	request.Header = header
+	s.signer.sign(request, info.SHA256, time.Now())
+
+	response, err := s.opts.Client.Do(request)
+	if err != nil {
+		return Info{}, err
+	} else if response.StatusCode/100 != 2 {
+		return Info{}, failure(response, clean)
+	}
+	response.Body.Close()
+
+	return info, nil
+}
+
+// Open implements Store. The content is fetched lazily with range
+// requests, so seeking doesn't download what is skipped.
+func (s *S3) Open(ctx context.Context, name string) (io.ReadSeekCloser, Info, error) {
+	info, etag, err := s.stat(ctx, name)
+	if err != nil {
+		return nil, Info{}, err
+	}
+
+	return &s3Reader{s: s, ctx: ctx, key: s.opts.Prefix + info.Name, etag: etag, size: info.Size}, info, nil
+}
+
+// Stat implements Store.
+func (s *S3) Stat(ctx context.Context, name string) (Info, error) {
+	info, _, err := s.stat(ctx, name)
+	return info, err
+}
+
+func (s *S3) stat(ctx context.Context, name string) (Info, string, error) {
+	clean, err := CleanName(name)
+	if err != nil {
+		return Info{}, "", err
+	}
+
+	response, err := s.do(ctx, http.MethodHead, s.url(s.opts.Prefix+clean, nil), nil, emptyPayload, nil)
+	if err != nil {
+		return Info{}, "", err
+	} else if response.StatusCode != http.StatusOK {
+		return Info{}, "", failure(response, clean)
+	}
+	response.Body.Close()
+
+	info := Info{
+		Name:   clean,
+		Size:   response.ContentLength,
+		SHA256: response.Header.Get("X-Amz-Meta-Sha256"),
+	}
+
+	created, err := time.Parse(time.RFC3339Nano, response.Header.Get("X-Amz-Meta-Created"))
+	if err != nil {
+		created, _ = http.ParseTime(response.Header.Get("Last-Modified"))
+	}
+	info.Created = created.UTC()
+
+	return info, response.Header.Get("ETag"), nil
+}
+
+// List implements Store. Every object is looked up for its metadata;
+// objects that vanish or cannot be read meanwhile are skipped.
+func (s *S3) List(ctx context.Context, prefix string) ([]Info, error) {
+	items := []Info{}
+	token := ""
+
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", s.opts.Prefix+prefix)
+		if len(token) > 0 {
+			query.Set("continuation-token", token)
+		}
+
+		response, err := s.do(ctx, http.MethodGet, s.url("", query), nil, emptyPayload, nil)
+		if err != nil {
+			return nil, err
+		} else if response.StatusCode != http.StatusOK {
+			return nil, failure(response, prefix)
+		}
+
+		var page struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+
+		err = xml.NewDecoder(response.Body).Decode(&page)
+		response.Body.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range page.Contents {
+			name := strings.TrimPrefix(object.Key, s.opts.Prefix)
+			if info, err := s.Stat(ctx, name); err == nil {
+				items = append(items, info)
+			} else if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		if !page.IsTruncated || len(page.NextContinuationToken) == 0 {
+			return items, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// Delete implements Store.
+func (s *S3) Delete(ctx context.Context, name string) error {
+	// S3 answers 204 for missing keys too, so existence is checked first.
+	info, _, err := s.stat(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	response, err := s.do(ctx, http.MethodDelete, s.url(s.opts.Prefix+info.Name, nil), nil, emptyPayload, nil)
+	if err != nil {
+		return err
+	} else if response.StatusCode/100 != 2 {
+		return failure(response, info.Name)
+	}
+	response.Body.Close()
+
+	return nil
+}
+
+// s3Reader reads an object through range requests. If-Match pins the
+// version that was opened, so a concurrent Put cannot mix two contents.
+type s3Reader struct {
+	s      *S3
+	ctx    context.Context
+	key    string
+	etag   string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (r *s3Reader) Read(data []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	if r.body == nil {
+		header := http.Header{}
+		header.Set("Range", "bytes="+strconv.FormatInt(r.offset, 10)+"-")
+		if len(r.etag) > 0 {
+			header.Set("If-Match", r.etag)
+		}
+
+		response, err := r.s.do(r.ctx, http.MethodGet, r.s.url(r.key, nil), nil, emptyPayload, header)
+		if err != nil {
+			return 0, err
+		}
+
+		// A 200 carries the whole object, which only fits from the
+		// start; past it, its bytes would be read at the wrong offset.
+		switch {
+		case response.StatusCode == http.StatusPartialContent:
+		case response.StatusCode == http.StatusOK && r.offset == 0:
+		case response.StatusCode == http.StatusOK:
+			response.Body.Close()
+			return 0, fmt.Errorf("artifact: S3 GET %s: range ignored by the server", r.key)
+		default:
+			return 0, failure(response, r.key)
+		}
+		r.body = response.Body
+	}
+
+	n, err := r.body.Read(data)
+	r.offset += int64(n)
+
+	if err == io.EOF && r.offset < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *s3Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+
+	if offset < 0 {
+		return 0, errors.New("artifact: negative seek offset")
+	}
+
+	if offset != r.offset && r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *s3Reader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
@@ -0,0 +1,352 @@
+// Package capture records sampled requests for debugging and replays
+// them later against any environment. Credentials are masked before a
+// request is stored, and recordings are bounded in number and age.
+package capture
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	mrand "math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"devops.io/cloud/redact"
+)
+
+// ErrNotFound is returned for unknown recording IDs.
+var ErrNotFound = errors.New("capture: recording not found")
+
+// Recording is a captured request and the status it was answered with.
+type Recording struct {
+	ID        string      `json:"id"`
+	Time      time.Time   `json:"time"`
+	Method    string      `json:"method"`
+	URI       string      `json:"uri"`
+	Host      string      `json:"host"`
+	Header    http.Header `json:"header"`
+	Body      []byte      `json:"body,omitempty"`
+	Truncated bool        `json:"truncated,omitempty"`
+	Status    int         `json:"status"`
+}
+
+// Options configures a Recorder.
+type Options struct {
+	// Sample is the fraction of requests recorded, between 0 and 1.
+	Sample float64
+
+	// Filter, when set, records matching requests whatever the sample.
+	Filter func(*http.Request) bool
+
+	// MaxBody bounds the recorded body; it defaults to 64 KiB. Larger
+	// bodies are truncated and can't be replayed faithfully.
+	MaxBody int64
+
+	// Retention is how many recordings are kept; it defaults to 1000.
+	Retention int
+
+	// MaxAge drops older recordings; it defaults to 24 hours.
+	MaxAge time.Duration
+
+	// Mask lists headers whose values are replaced before storage; it
+	// defaults to Authorization, Cookie and X-Api-Key.
+	Mask []string
+
+	// MaskQuery lists query parameters whose values are replaced before
+	// storage, ignoring case; it defaults to access_token, api_key, key,
+	// password, secret, signature and token.
+	MaskQuery []string
+
+	// Redactor, when set, scrubs known secrets from recorded bodies and
+	// query values.
+	Redactor *redact.Redactor
+}
+
+// lookahead is how far past MaxBody a body is read before redaction, so
+// a secret straddling the limit is masked whole. Secrets longer than it
+// may be partially kept at the cut.
+const lookahead = 4 << 10
+
+// Recorder captures requests passing through its middleware.
+type Recorder struct {
+	opts       Options
+	mu         sync.Mutex
+	recordings []*Recording
+}
+
+// New creates a recorder.
+func New(opts Options) *Recorder {
+	if opts.MaxBody <= 0 {
+		opts.MaxBody = 64 << 10
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = 1000
+	}
+	if opts.MaxAge <= 0 {
+		opts.MaxAge = 24 * time.Hour
+	}
+	if opts.Mask == nil {
+		opts.Mask = []string{"Authorization", "Cookie", "X-Api-Key"}
+	}
+	if opts.MaskQuery == nil {
+		opts.MaskQuery = []string{"access_token", "api_key", "key", "password", "secret", "signature", "token"}
+	}
+
+	return &Recorder{opts: opts}
+}
+
+// Middleware records the selected requests. The handler still reads the
+// complete body.
+func (c *Recorder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !c.selected(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		recording := c.record(r)
+		status := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(status, r)
+
+		recording.Status = status.status
+		c.add(recording)
+	})
+}
+
+// List returns the recordings, newest first.
+func (c *Recorder) List() []Recording {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire()
+
+	list := make([]Recording, 0, len(c.recordings))
+	for i := len(c.recordings) - 1; i >= 0; i-- {
+		list = append(list, *c.recordings[i])
+	}
+	return list
+}
+
+// Get returns one recording.
+func (c *Recorder) Get(id string) (Recording, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire()
+
+	for _, recording := range c.recordings {
+		if recording.ID == id {
+			return *recording, nil
+		}
+	}
+	return Recording{}, ErrNotFound
+}
+
+// Target is an environment recordings may be replayed against.
+type Target struct {
+	// Base is the URL prefix, e.g. "https://staging.example.com".
+	Base string
+
+	// Header holds the credentials of the environment, e.g. its own
+	// Authorization value, which replace the masked ones.
+	Header http.Header
+}
+
+// Replay issues recording again against target. Masked headers and query
+// parameters are dropped rather than sent as placeholders, and
+// target.Header is added.
+func Replay(ctx context.Context, client *http.Client, recording Recording, target Target) (*http.Response, error) {
+	address, err := url.Parse(strings.TrimRight(target.Base, "/") + recording.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(address.RawQuery) > 0 {
+		query := address.Query()
+		for name, values := range query {
+			if len(values) == 1 && values[0] == redact.Mask {
+				query.Del(name)
+			}
+		}
+		address.RawQuery = query.Encode()
+	}
+
+	request, err := http.NewRequestWithContext(ctx, recording.Method, address.String(), bytes.NewReader(recording.Body))
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header = recording.Header.Clone()
+	for name, values := range request.Header {
+		if len(values) == 1 && values[0] == redact.Mask {
+			request.Header.Del(name)
+		}
+	}
+	for name, values := range target.Header {
+		request.Header[name] = append([]string(nil), values...)
+	}
+	request.Header.Set("X-Replayed-From", recording.ID)
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(request)
+}
+
+func (c *Recorder) selected(r *http.Request) bool {
+	if c.opts.Filter != nil && c.opts.Filter(r) {
+		return true
+	}
+	return c.opts.Sample > 0 && mrand.Float64() < c.opts.Sample
+}
+
+// record copies r, keeping its body readable by the handler.
+func (c *Recorder) record(r *http.Request) *Recording {
+	recording := &Recording{
+		ID:     identifier(),
+		Time:   time.Now(),
+		Method: r.Method,
+		URI:    c.uri(r.URL),
+		Host:   r.Host,
+		Header: r.Header.Clone(),
+	}
+
+	for _, name := range c.opts.Mask {
+		if len(recording.Header.Values(name)) > 0 {
+			recording.Header.Set(name, redact.Mask)
+		}
+	}
+
+	if r.Body != nil && r.Body != http.NoBody {
+		window := c.opts.MaxBody + lookahead
+		head, _ := io.ReadAll(io.LimitReader(r.Body, window))
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(head), r.Body), r.Body}
+
+		recording.Body, recording.Truncated = c.capture(head, int64(len(head)) == window)
+	}
+	return recording
+}
+
+// uri returns the request URI with masked query values replaced and the
+// others scrubbed by the Redactor.
+func (c *Recorder) uri(address *url.URL) string {
+	if len(address.RawQuery) == 0 {
+		return address.RequestURI()
+	}
+
+	query := address.Query()
+	for name, values := range query {
+		for i, value := range values {
+			if masked(c.opts.MaskQuery, name) {
+				values[i] = redact.Mask
+			} else if c.opts.Redactor != nil {
+				values[i] = c.opts.Redactor.String(value)
+			}
+		}
+	}
+
+	scrubbed := *address
+	scrubbed.RawQuery = query.Encode()
+	return scrubbed.RequestURI()
+}
+
+func masked(names []string, name string) bool {
+	for _, candidate := range names {
+		if strings.EqualFold(candidate, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// capture redacts the body read so far and cuts it to MaxBody. more
+// reports that the body continues past head, in which case a secret may
+// be cut at the end of head: it is left unmasked there, so the last
+// lookahead bytes are dropped as well.
+func (c *Recorder) capture(head []byte, more bool) ([]byte, bool) {
+	body := append([]byte(nil), head...)
+	if c.opts.Redactor != nil {
+		body = c.opts.Redactor.Redact(body)
+	}
+
+	keep := int64(len(body))
+	if more {
+		keep -= lookahead
+	}
+	if keep > c.opts.MaxBody {
+		keep = c.opts.MaxBody
+	}
+
+	if keep < int64(len(body)) {
+		return body[:keep], true
+	}
+	return body, false
+}
+
+func (c *Recorder) add(recording *Recording) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.recordings = append(c.recordings, recording)
+	if extra := len(c.recordings) - c.opts.Retention; extra > 0 {
+		c.recordings = append([]*Recording(nil), c.recordings[extra:]...)
+	}
+	c.expire()
+}
+
+// expire drops recordings older than MaxAge; they are in time order.
+func (c *Recorder) expire() {
+	cutoff := time.Now().Add(-c.opts.MaxAge)
+
+	index := 0
+	for index < len(c.recordings) && c.recordings[index].Time.Before(cutoff) {
+		index++
+	}
+	c.recordings = c.recordings[index:]
+}
+
+func identifier() string {
+	raw := make([]byte, 8)
+	rand.Read(raw)
+	return hex.EncodeToString(raw)
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	wrote  bool
+}
+
+func (s *statusWriter) WriteHeader(code int) {
+	if !s.wrote {
+		s.status, s.wrote = code, true
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusWriter) Write(data []byte) (int, error) {
+	s.wrote = true
+	return s.ResponseWriter.Write(data)
+}
+
+// Flush keeps streaming responses working on recorded requests.
+func (s *statusWriter) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (s *statusWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
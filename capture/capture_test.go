@@ -0,0 +1,253 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"devops.io/cloud/redact"
+)
+
+const secret = "s3cr3t-token-value"
+
+func TestRecordBody(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		maxBody   int64
+		truncated bool
+	}{
+		{"small", "token=" + secret, 1024, false},
+		{"exact", strings.Repeat("a", 64), 64, false},
+		{"large", strings.Repeat("a", 200), 64, true},
+		{"secret at the limit", strings.Repeat("a", 60) + secret + strings.Repeat("b", 100), 64, true},
+		{"secret past the window", strings.Repeat("a", 64+lookahead-5) + secret, 64, true},
+		{"secret at the window end", strings.Repeat("a", 64+lookahead-5) + secret + strings.Repeat("b", 100), 64, true},
+		{"shrinks under the limit", secret + strings.Repeat("a", 60), 70, false},
+	}
+
+	for _, test := range tests {
+		c := New(Options{Filter: func(*http.Request) bool { return true }, MaxBody: test.maxBody, Redactor: redact.New(secret)})
+
+		var seen []byte
+		handler := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen, _ = io.ReadAll(r.Body)
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/jobs", strings.NewReader(test.body)))
+
+		if string(seen) != test.body {
+			t.Errorf("%s: handler read %d bytes; want %d", test.name, len(seen), len(test.body))
+		}
+
+		recording := c.List()[0]
+		if int64(len(recording.Body)) > test.maxBody {
+			t.Errorf("%s: recorded %d bytes; limit %d", test.name, len(recording.Body), test.maxBody)
+		}
+		if recording.Truncated != test.truncated {
+			t.Errorf("%s: truncated = %v; want %v", test.name, recording.Truncated, test.truncated)
+		}
+
+		// No prefix of the secret long enough to be recognizable survives.
+		if bytes.Contains(recording.Body, []byte(secret[:5])) {
+			t.Errorf("%s: secret leaked in %q", test.name, recording.Body)
+		}
+	}
+}
+
+func TestMaskHeaders(t *testing.T) {
+	c := New(Options{Sample: 1})
+	handler := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	r := httptest.NewRequest("GET", "/jobs?x=1", nil)
+	r.Header.Set("Authorization", "Bearer "+secret)
+	r.Header.Set("X-Api-Key", secret)
+	r.Header.Set("Accept", "application/json")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	recording := c.List()[0]
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"Authorization", redact.Mask},
+		{"X-Api-Key", redact.Mask},
+		{"Cookie", ""},
+		{"Accept", "application/json"},
+	}
+
+	for _, test := range tests {
+		if got := recording.Header.Get(test.header); got != test.want {
+			t.Errorf("%s = %q; want %q", test.header, got, test.want)
+		}
+	}
+	if recording.Status != http.StatusCreated || recording.URI != "/jobs?x=1" {
+		t.Errorf("recording = %+v", recording)
+	}
+}
+
+func TestMaskQuery(t *testing.T) {
+	c := New(Options{Sample: 1, Redactor: redact.New(secret)})
+	r := httptest.NewRequest("GET", "/jobs?Access_Token=abc&q=has+"+secret+"&page=2", nil)
+	c.Middleware(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), r)
+
+	address, err := url.Parse(c.List()[0].URI)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := address.Query()
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Access_Token", redact.Mask},
+		{"q", "has " + redact.Mask},
+		{"page", "2"},
+	}
+
+	for _, test := range tests {
+		if got := query.Get(test.name); got != test.want {
+			t.Errorf("%s = %q; want %q", test.name, got, test.want)
+		}
+	}
+	if address.Path != "/jobs" {
+		t.Errorf("path = %q", address.Path)
+	}
+}
+
+func TestSelection(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   Options
+		record bool
+	}{
+		{"never", Options{}, false},
+		{"always", Options{Sample: 1}, true},
+		{"filtered", Options{Filter: func(r *http.Request) bool { return r.URL.Path == "/x" }}, true},
+		{"filtered out", Options{Filter: func(r *http.Request) bool { return false }}, false},
+	}
+
+	for _, test := range tests {
+		c := New(test.opts)
+		c.Middleware(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/x", nil))
+
+		if got := len(c.List()) == 1; got != test.record {
+			t.Errorf("%s: recorded = %v", test.name, got)
+		}
+	}
+}
+
+func TestRetention(t *testing.T) {
+	c := New(Options{Sample: 1, Retention: 2})
+	handler := c.Middleware(http.NotFoundHandler())
+
+	for _, path := range []string{"/a", "/b", "/c"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	list := c.List()
+	if len(list) != 2 || list[0].URI != "/c" || list[1].URI != "/b" {
+		t.Errorf("kept %+v", list)
+	}
+
+	if _, err := c.Get(list[0].ID); err != nil {
+		t.Errorf("Get = %v", err)
+	}
+	if _, err := c.Get("missing"); err != ErrNotFound {
+		t.Errorf("Get(missing) = %v", err)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	c := New(Options{Sample: 1})
+	handler := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("data: 1\n\n"))
+		flusher.Flush()
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/events", nil))
+
+	if w.Code != http.StatusOK || !w.Flushed {
+		t.Errorf("code = %d, flushed = %v", w.Code, w.Flushed)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	var got http.Header
+	var body string
+	staging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		raw, _ := io.ReadAll(r.Body)
+		body = r.Method + " " + r.URL.RequestURI() + " " + string(raw)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer staging.Close()
+
+	c := New(Options{Sample: 1})
+	r := httptest.NewRequest("POST", "/jobs?dry=1&token=abc", strings.NewReader("{}"))
+	r.Header.Set("Authorization", "Bearer "+secret)
+	r.Header.Set("X-Api-Key", secret)
+	c.Middleware(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), r)
+	id := c.List()[0].ID
+
+	targets := map[string]Target{
+		"staging": {Base: staging.URL + "/", Header: http.Header{"Authorization": {"Bearer staging"}}},
+	}
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/recordings/" + id + "/replay?env=staging", http.StatusOK},
+		{"/recordings/" + id + "/replay?env=prod", http.StatusBadRequest},
+		{"/recordings/missing/replay?env=staging", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		c.Handler(targets, nil).ServeHTTP(w, httptest.NewRequest("POST", test.path, nil))
+
+		if w.Code != test.code {
+			t.Errorf("POST %s = %d; want %d", test.path, w.Code, test.code)
+		}
+	}
+
+	if body != "POST /jobs?dry=1 {}" {
+		t.Errorf("replayed %q", body)
+	}
+	if got.Get("Authorization") != "Bearer staging" {
+		t.Errorf("Authorization = %q; want the target's", got.Get("Authorization"))
+	}
+	if _, ok := got["X-Api-Key"]; ok {
+		t.Errorf("masked X-Api-Key sent: %q", got.Get("X-Api-Key"))
+	}
+	if got.Get("X-Replayed-From") != id {
+		t.Errorf("X-Replayed-From = %q", got.Get("X-Replayed-From"))
+	}
+
+	w := httptest.NewRecorder()
+	c.Handler(targets, nil).ServeHTTP(w, httptest.NewRequest("GET", "/recordings", nil))
+
+	var list []Recording
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list) != 1 {
+		t.Errorf("GET /recordings = %s", w.Body.String())
+	}
+
+	if _, err := Replay(context.Background(), nil, Recording{URI: "/x", Method: "GET"}, Target{Base: "://bad"}); err == nil {
+		t.Error("Replay accepted a malformed base")
+	}
+}
@@ -0,0 +1,77 @@
+package capture
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Handler exposes the recordings. Replays only go to the named targets,
+// so the endpoint can't be used to reach arbitrary hosts, and carry each
+// target's own credentials. It must be mounted with its prefix stripped,
+// behind the admin authentication:
+//
+//	GET  /recordings                      recent recordings, newest first
+//	GET  /recordings/{id}                 one recording
+//	POST /recordings/{id}/replay?env=NAME issue it again against targets[NAME]
+func (c *Recorder) Handler(targets map[string]Target, client *http.Client) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if parts[0] != "recordings" {
+			http.NotFound(w, r)
+			return
+		}
+
+		switch {
+		case len(parts) == 1 && r.Method == http.MethodGet:
+			reply(w, http.StatusOK, c.List())
+
+		case len(parts) == 2 && r.Method == http.MethodGet:
+			recording, err := c.Get(parts[1])
+			if err != nil {
+				http.Error(w, "recording not found", http.StatusNotFound)
+				return
+			}
+			reply(w, http.StatusOK, recording)
+
+		case len(parts) == 3 && parts[2] == "replay" && r.Method == http.MethodPost:
+			env := r.URL.Query().Get("env")
+			target, ok := targets[env]
+			if !ok {
+				http.Error(w, "unknown environment", http.StatusBadRequest)
+				return
+			}
+
+			recording, err := c.Get(parts[1])
+			if err != nil {
+				http.Error(w, "recording not found", http.StatusNotFound)
+				return
+			}
+
+			response, err := Replay(r.Context(), client, recording, target)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			defer response.Body.Close()
+
+			body, _ := io.ReadAll(io.LimitReader(response.Body, 64<<10))
+			reply(w, http.StatusOK, map[string]interface{}{
+				"env":    env,
+				"status": response.StatusCode,
+				"header": response.Header,
+				"body":   string(body),
+			})
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func reply(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,380 @@
+// Package compress negotiates gzip compression for file transfers and
+// counts the bytes it saves. Responses are compressed only when the client
+// accepts gzip and the content is worth it: large enough and not already
+// compressed. Request bodies sent with "Content-Encoding: gzip" are
+// decompressed under a size limit.
+//
+// Only gzip is offered: zstd would need a third-party module, which the
+// WORKSPACE doesn't declare.
+package compress
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	Gzip     = "gzip"
+	Identity = "identity"
+)
+
+var (
+	// ErrUnsupported is returned by Decode for request encodings other
+	// than gzip and identity.
+	ErrUnsupported = errors.New("compress: unsupported content encoding")
+
+	// ErrTooLarge is returned when a decompressed body exceeds its limit.
+	ErrTooLarge = errors.New("compress: decompressed body too large")
+
+	// ErrCorrupt is returned by Decode when the body isn't gzip data.
+	ErrCorrupt = errors.New("compress: corrupt gzip body")
+)
+
+// Stats counts the traffic of one transfer kind.
+type Stats struct {
+	// Raw is the uncompressed size and Wire the size transferred, both
+	// summed over compressed transfers only.
+	Raw  int64 `json:"raw"`
+	Wire int64 `json:"wire"`
+
+	Compressed int64 `json:"compressed"`
+	Skipped    int64 `json:"skipped"`
+}
+
+// Saved returns how many bytes compression kept off the wire.
+func (s Stats) Saved() int64 {
+	return s.Raw - s.Wire
+}
+
+// Meter collects Stats per transfer kind, e.g. "download" or "upload".
+type Meter struct {
+	mu    sync.Mutex
+	stats map[string]*Stats
+}
+
+// Default is the meter used when Options.Meter is nil.
+var Default = &Meter{}
+
+func (m *Meter) record(kind string, update func(stats *Stats)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.stats == nil {
+		m.stats = make(map[string]*Stats)
+	}
+
+	stats, ok := m.stats[kind]
+	if !ok {
+		stats = &Stats{}
+		m.stats[kind] = stats
+	}
+	update(stats)
+}
+
+// Snapshot returns the counters of every transfer kind seen so far.
+func (m *Meter) Snapshot() map[string]Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := make(map[string]Stats, len(m.stats))
+	for kind, stats := range m.stats {
+		snapshot[kind] = *stats
+	}
+	return snapshot
+}
+
+// Options tunes the trade-off between CPU and bandwidth.
+type Options struct {
+	// Level is the gzip level; it defaults to gzip.DefaultCompression.
+	// gzip.BestSpeed suits fast links, gzip.BestCompression slow ones.
+	Level int
+
+	// MinSize is the smallest response worth compressing; it defaults to
+	// 1 KiB. Responses of unknown length are always compressed.
+	MinSize int64
+
+	// Kind labels the transfer in the meter; it defaults to "response".
+	Kind  string
+	Meter *Meter
+}
+
+func (o *Options) defaults() {
+	if o.Level == 0 {
+		o.Level = gzip.DefaultCompression
+	}
+	if o.MinSize <= 0 {
+		o.MinSize = 1 << 10
+	}
+	if len(o.Kind) == 0 {
+		o.Kind = "response"
+	}
+	if o.Meter == nil {
+		o.Meter = Default
+	}
+}
+
+// Accepts reports whether the client accepts gzip responses, honoring
+// q-values such as "gzip;q=0".
+func Accepts(r *http.Request) bool {
+	accepted := false
+
+	for _, item := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, quality := item, 1.0
+
+		if semicolon := strings.IndexByte(item, ';'); semicolon >= 0 {
+			coding = item[:semicolon]
+			param := strings.TrimSpace(item[semicolon+1:])
+
+			if value := strings.TrimPrefix(param, "q="); value != param {
+				quality, _ = strconv.ParseFloat(value, 64)
+			}
+		}
+
+		switch strings.ToLower(strings.TrimSpace(coding)) {
+		case Gzip, "x-gzip":
+			return quality > 0
+		case "*":
+			accepted = quality > 0
+		}
+	}
+	return accepted
+}
+
+// Compressible reports whether content of the given media type shrinks
+// under gzip. Images, audio, video and archives are already compressed.
+func Compressible(contentType string) bool {
+	mediatype, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	switch {
+	case strings.HasPrefix(mediatype, "text/"),
+		strings.HasSuffix(mediatype, "+json"),
+		strings.HasSuffix(mediatype, "+xml"),
+		mediatype == "image/svg+xml":
+		return true
+
+	case strings.HasPrefix(mediatype, "image/"),
+		strings.HasPrefix(mediatype, "audio/"),
+		strings.HasPrefix(mediatype, "video/"):
+		return false
+	}
+
+	switch mediatype {
+	case "application/json", "application/xml", "application/javascript",
+		"application/x-ndjson", "application/x-tar", "application/octet-stream",
+		"application/wasm", "application/x-sh", "application/yaml":
+		return true
+	}
+	return false
+}
+
+// ResponseWriter compresses what is written to it when the response turns
+// out to be worth compressing. Close must be called once the handler is
+// done.
+type ResponseWriter struct {
+	http.ResponseWriter
+	opts    Options
+	decided bool
+	gzip    *gzip.Writer
+	raw     int64
+	wire    counter
+}
+
+// NewResponseWriter wraps w. The caller must already have checked that
+// the client accepts gzip.
+func NewResponseWriter(w http.ResponseWriter, opts Options) *ResponseWriter {
+	opts.defaults()
+	return &ResponseWriter{ResponseWriter: w, opts: opts}
+}
+
+// WriteHeader decides whether to compress from the headers set so far.
+func (cw *ResponseWriter) WriteHeader(code int) {
+	if !cw.decided {
+		cw.decided = true
+		cw.decide(code)
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *ResponseWriter) decide(code int) {
+	header := cw.Header()
+
+	if code != http.StatusOK || len(header.Get("Content-Encoding")) > 0 ||
+		len(header.Get("Content-Range")) > 0 || !Compressible(header.Get("Content-Type")) {
+		cw.opts.Meter.record(cw.opts.Kind, func(stats *Stats) { stats.Skipped++ })
+		return
+	}
+
+	if size, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil && size < cw.opts.MinSize {
+		cw.opts.Meter.record(cw.opts.Kind, func(stats *Stats) { stats.Skipped++ })
+		return
+	}
+
+	header.Del("Content-Length")
+	header.Del("Accept-Ranges")
+	header.Set("Content-Encoding", Gzip)
+
+	// The gzip representation needs its own tag, or caches and If-Range
+	// would mix it up with the plain one.
+	if tag := header.Get("ETag"); len(tag) > 0 {
+		header.Set("ETag", strings.TrimSuffix(tag, `"`)+`-gzip"`)
+	}
+
+	cw.wire.out = cw.ResponseWriter
+	cw.gzip, _ = gzip.NewWriterLevel(&cw.wire, cw.opts.Level)
+}
+
+// Write implements io.Writer.
+func (cw *ResponseWriter) Write(data []byte) (int, error) {
+	if !cw.decided {
+		if len(cw.Header().Get("Content-Type")) == 0 {
+			cw.Header().Set("Content-Type", http.DetectContentType(data))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if cw.gzip == nil {
+		return cw.ResponseWriter.Write(data)
+	}
+
+	cw.raw += int64(len(data))
+	return cw.gzip.Write(data)
+}
+
+// Flush sends what was compressed so far to the client.
+func (cw *ResponseWriter) Flush() {
+	if cw.gzip != nil {
+		cw.gzip.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Close finishes the gzip stream and records the transfer.
+func (cw *ResponseWriter) Close() error {
+	if cw.gzip == nil {
+		return nil
+	}
+
+	err := cw.gzip.Close()
+	cw.gzip = nil
+
+	cw.opts.Meter.record(cw.opts.Kind, func(stats *Stats) {
+		stats.Compressed++
+		stats.Raw += cw.raw
+		stats.Wire += cw.wire.n
+	})
+	return err
+}
+
+type counter struct {
+	out io.Writer
+	n   int64
+}
+
+func (c *counter) Write(data []byte) (int, error) {
+	n, err := c.out.Write(data)
+	c.n += int64(n)
+	return n, err
+}
+
+// Decode replaces the body of a request sent with "Content-Encoding: gzip"
+// by its decompressed content, which fails with ErrTooLarge past limit
+// bytes; zero disables the limit. Other encodings yield ErrUnsupported.
+func Decode(r *http.Request, limit int64, opts Options) error {
+	if len(opts.Kind) == 0 {
+		opts.Kind = "request"
+	}
+	opts.defaults()
+
+	switch strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))) {
+	case "", Identity:
+		return nil
+	case Gzip, "x-gzip":
+	default:
+		return ErrUnsupported
+	}
+
+	wire := &countingReader{in: r.Body}
+	reader, err := gzip.NewReader(wire)
+	if err == gzip.ErrHeader || err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrCorrupt
+	} else if err != nil {
+		return err
+	}
+
+	r.Body = &decoder{
+		reader: reader,
+		body:   r.Body,
+		wire:   wire,
+		limit:  limit,
+		opts:   opts,
+	}
+	r.Header.Del("Content-Encoding")
+	r.Header.Del("Content-Length")
+	r.ContentLength = -1
+	return nil
+}
+
+type countingReader struct {
+	in io.Reader
+	n  int64
+}
+
+func (c *countingReader) Read(data []byte) (int, error) {
+	n, err := c.in.Read(data)
+	c.n += int64(n)
+	return n, err
+}
+
+type decoder struct {
+	reader *gzip.Reader
+	body   io.Closer
+	wire   *countingReader
+	raw    int64
+	limit  int64
+	opts   Options
+	once   sync.Once
+}
+
+func (d *decoder) Read(data []byte) (int, error) {
+	if d.limit > 0 && int64(len(data)) > d.limit-d.raw+1 {
+		data = data[:d.limit-d.raw+1]
+	}
+
+	n, err := d.reader.Read(data)
+	d.raw += int64(n)
+
+	if d.limit > 0 && d.raw > d.limit {
+		return n, ErrTooLarge
+	}
+	if err == io.EOF {
+		d.record()
+	}
+	return n, err
+}
+
+func (d *decoder) Close() error {
+	d.record()
+	d.reader.Close()
+	return d.body.Close()
+}
+
+func (d *decoder) record() {
+	d.once.Do(func() {
+		d.opts.Meter.record(d.opts.Kind, func(stats *Stats) {
+			stats.Compressed++
+			stats.Raw += d.raw
+			stats.Wire += d.wire.n
+		})
+	})
+}
@@ -0,0 +1,206 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAccepts(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip;q=0.5", true},
+		{"gzip;q=0", false},
+		{"br, *", true},
+		{"*;q=0", false},
+		{"*, gzip;q=0", false},
+		{"identity", false},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", test.header)
+
+		if got := Accepts(r); got != test.want {
+			t.Errorf("Accepts(%q) = %v; want %v", test.header, got, test.want)
+		}
+	}
+}
+
+func TestCompressible(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        bool
+	}{
+		{"text/plain; charset=utf-8", true},
+		{"application/json", true},
+		{"application/vnd.api+json", true},
+		{"image/svg+xml", true},
+		{"image/png", false},
+		{"application/gzip", false},
+		{"application/zip", false},
+		{"video/mp4", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		if got := Compressible(test.contentType); got != test.want {
+			t.Errorf("Compressible(%q) = %v; want %v", test.contentType, got, test.want)
+		}
+	}
+}
+
+func TestResponseWriter(t *testing.T) {
+	large := strings.Repeat("compressible text ", 200)
+
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		length      bool
+		body        string
+		compressed  bool
+	}{
+		{"large text", http.StatusOK, "text/plain", true, large, true},
+		{"unknown length", http.StatusOK, "text/plain", false, "short", true},
+		{"sniffed type", http.StatusOK, "", false, large, true},
+		{"too small", http.StatusOK, "text/plain", true, "short", false},
+		{"already compressed", http.StatusOK, "image/png", true, large, false},
+		{"not ok", http.StatusNotFound, "text/plain", true, large, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			meter := &Meter{}
+			recorder := httptest.NewRecorder()
+			cw := NewResponseWriter(recorder, Options{Meter: meter, Kind: "test"})
+			cw.Header().Set("ETag", `"v1"`)
+
+			if len(test.contentType) > 0 {
+				cw.Header().Set("Content-Type", test.contentType)
+			}
+			if test.length {
+				cw.Header().Set("Content-Length", strconv.Itoa(len(test.body)))
+			}
+			if test.status != http.StatusOK {
+				cw.WriteHeader(test.status)
+			}
+
+			io.WriteString(cw, test.body)
+			if err := cw.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			encoded := recorder.Header().Get("Content-Encoding") == Gzip
+			if encoded != test.compressed {
+				t.Fatalf("Content-Encoding = %q", recorder.Header().Get("Content-Encoding"))
+			}
+
+			want := `"v1"`
+			if encoded {
+				want = `"v1-gzip"`
+			}
+			if etag := recorder.Header().Get("ETag"); etag != want {
+				t.Errorf("ETag = %s; want %s", etag, want)
+			}
+
+			body := recorder.Body.Bytes()
+			if encoded {
+				reader, err := gzip.NewReader(bytes.NewReader(body))
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, _ = io.ReadAll(reader)
+
+				if len(recorder.Header().Get("Content-Length")) > 0 {
+					t.Error("Content-Length kept on a compressed response")
+				}
+			}
+
+			if string(body) != test.body {
+				t.Errorf("body = %q", body)
+			}
+
+			stats := meter.Snapshot()["test"]
+			if encoded && (stats.Compressed != 1 || stats.Raw != int64(len(test.body)) || stats.Wire != int64(recorder.Body.Len())) {
+				t.Errorf("stats = %+v", stats)
+			}
+			if !encoded && stats.Skipped != 1 {
+				t.Errorf("stats = %+v", stats)
+			}
+		})
+	}
+}
+
+func gzipped(t *testing.T, content string) []byte {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	io.WriteString(writer, content)
+	writer.Close()
+	return buffer.Bytes()
+}
+
+func TestDecode(t *testing.T) {
+	content := strings.Repeat("a", 4096)
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		limit    int64
+		err      error
+		readErr  error
+	}{
+		{name: "identity", body: []byte(content)},
+		{name: "gzip", encoding: "gzip", body: gzipped(t, content)},
+		{name: "gzip within limit", encoding: "gzip", body: gzipped(t, content), limit: 4096},
+		{name: "gzip bomb", encoding: "gzip", body: gzipped(t, content), limit: 1024, readErr: ErrTooLarge},
+		{name: "unsupported", encoding: "br", body: []byte(content), err: ErrUnsupported},
+		{name: "corrupt", encoding: "gzip", body: []byte("not gzip at all"), err: ErrCorrupt},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(test.body))
+			if len(test.encoding) > 0 {
+				r.Header.Set("Content-Encoding", test.encoding)
+			}
+
+			meter := &Meter{}
+			err := Decode(r, test.limit, Options{Meter: meter})
+			if !errors.Is(err, test.err) {
+				t.Fatalf("Decode = %v; want %v", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			r.Body.Close()
+
+			if !errors.Is(err, test.readErr) {
+				t.Fatalf("read = %v; want %v", err, test.readErr)
+			}
+			if err == nil && string(body) != content {
+				t.Errorf("body has %d bytes", len(body))
+			}
+
+			if test.encoding == "gzip" && err == nil {
+				stats := meter.Snapshot()["request"]
+				if stats.Raw != int64(len(content)) || stats.Wire != int64(len(test.body)) || stats.Saved() <= 0 {
+					t.Errorf("stats = %+v", stats)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,402 @@
+// Package config implements layered configuration shared by every
+// subsystem of the server. Values are resolved from, in increasing order
+// of precedence: registered defaults, a JSON configuration file,
+// environment variables and command-line flags.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Layer identifies where a configuration value came from.
+type Layer int
+
+const (
+	Default Layer = iota
+	File
+	Env
+	Flag
+)
+
+func (l Layer) String() string {
+	switch l {
+	case Default:
+		return "default"
+	case File:
+		return "file"
+	case Env:
+		return "env"
+	case Flag:
+		return "flag"
+	}
+	return "unknown"
+}
+
+// Config holds every layer of configuration. Keys are lower-case and
+// dot-separated, e.g. "server.listen" or "executor.max_output"; segments
+// may contain underscores and dashes.
+type Config struct {
+	prefix  string
+	mu      sync.RWMutex
+	layers  [Flag + 1]map[string]string
+	environ map[string]string
+	flags   map[string]string
+	rules   []Rule
+}
+
+// Rule validates the resolved configuration on startup.
+type Rule func(cfg *Config) error
+
+// New creates an empty configuration. Environment variables are only
+// considered when they start with prefix followed by an underscore, so
+// with prefix "AUTOMATION" the variable AUTOMATION_SERVER_LISTEN maps to
+// the key "server.listen", and AUTOMATION_EXECUTOR_MAX_OUTPUT to
+// "executor.max_output" or "executor.max-output".
+func New(prefix string) *Config {
+	cfg := &Config{
+		prefix:  strings.ToUpper(prefix),
+		environ: make(map[string]string),
+		flags:   make(map[string]string),
+	}
+
+	for i := range cfg.layers {
+		cfg.layers[i] = make(map[string]string)
+	}
+	return cfg
+}
+
+func normalize(key string) string {
+	return strings.ToLower(strings.TrimSpace(key))
+}
+
+// envName returns the variable naming key: dots, dashes and underscores
+// all become underscores.
+func (c *Config) envName(key string) string {
+	name := strings.NewReplacer(".", "_", "-", "_").Replace(key)
+	return c.prefix + "_" + strings.ToUpper(name)
+}
+
+// SetDefault registers the lowest precedence value of a key.
+func (c *Config) SetDefault(key string, value interface{}) {
+	c.set(Default, key, fmt.Sprint(value))
+}
+
+// Set overrides a key at the given layer.
+func (c *Config) Set(layer Layer, key, value string) {
+	c.set(layer, key, value)
+}
+
+func (c *Config) set(layer Layer, key, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.layers[layer][normalize(key)] = value
+}
+
+// LoadFile reads a JSON document into the file layer. Nested objects are
+// flattened into dot-separated keys.
+func (c *Config) LoadFile(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return fmt.Errorf("config: parse %s: %v", path, err)
+	}
+
+	flat := make(map[string]string)
+	flatten("", doc, flat)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, value := range flat {
+		c.layers[File][key] = value
+	}
+	return nil
+}
+
+func flatten(prefix string, node interface{}, out map[string]string) {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			if len(prefix) > 0 {
+				key = prefix + "." + key
+			}
+			flatten(normalize(key), child, out)
+		}
+
+	case []interface{}:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			items = append(items, fmt.Sprint(item))
+		}
+		out[prefix] = strings.Join(items, ",")
+
+	case nil:
+		out[prefix] = ""
+
+	default:
+		out[prefix] = fmt.Sprint(value)
+	}
+}
+
+// LoadEnv reads the environment layer from environ, usually os.Environ().
+// A variable applies to every key whose envName matches it, so keys with
+// underscores or dashes can be set; Keys lists it with underscores read
+// as dots.
+func (c *Config) LoadEnv(environ []string) {
+	head := c.prefix + "_"
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, item := range environ {
+		pair := strings.SplitN(item, "=", 2)
+		if len(pair) != 2 || !strings.HasPrefix(pair[0], head) {
+			continue
+		}
+
+		c.environ[pair[0]] = pair[1]
+
+		key := strings.TrimPrefix(pair[0], head)
+		key = strings.ReplaceAll(key, "_", ".")
+		c.layers[Env][normalize(key)] = pair[1]
+	}
+}
+
+// LoadFlags copies every flag explicitly set on the command line into the
+// flag layer. Flags left at their default value are ignored so they don't
+// shadow values coming from the file or the environment. Flag names may use
+// either dots or dashes as separators: -executor.max-output and
+// -executor-max-output both set "executor.max-output", and
+// -executor.max_output sets "executor.max_output".
+func (c *Config) LoadFlags(flags *flag.FlagSet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	flags.Visit(func(item *flag.Flag) {
+		c.flags[normalize(item.Name)] = item.Value.String()
+
+		key := strings.ReplaceAll(item.Name, "-", ".")
+		c.layers[Flag][normalize(key)] = item.Value.String()
+	})
+}
+
+// Lookup resolves a key and reports the layer that provided it.
+func (c *Config) Lookup(key string) (string, Layer, bool) {
+	key = normalize(key)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for layer := Flag; layer >= Default; layer-- {
+		if value, ok := c.value(layer, key); ok {
+			return value, layer, true
+		}
+	}
+	return "", Default, false
+}
+
+func (c *Config) value(layer Layer, key string) (string, bool) {
+	switch layer {
+	case Flag:
+		for _, name := range []string{key, strings.ReplaceAll(key, ".", "-")} {
+			if value, ok := c.flags[name]; ok {
+				return value, true
+			}
+		}
+
+	case Env:
+		if value, ok := c.environ[c.envName(key)]; ok {
+			return value, true
+		}
+	}
+
+	value, ok := c.layers[layer][key]
+	return value, ok
+}
+
+// Has reports whether any layer defines the key.
+func (c *Config) Has(key string) bool {
+	_, _, ok := c.Lookup(key)
+	return ok
+}
+
+// String returns the resolved value of a key or an empty string.
+func (c *Config) String(key string) string {
+	value, _, _ := c.Lookup(key)
+	return value
+}
+
+// Strings splits a comma-separated value into its trimmed items.
+func (c *Config) Strings(key string) []string {
+	value := c.String(key)
+	if len(value) == 0 {
+		return nil
+	}
+
+	items := strings.Split(value, ",")
+	for i, item := range items {
+		items[i] = strings.TrimSpace(item)
+	}
+	return items
+}
+
+// Int parses the resolved value of a key as an integer.
+func (c *Config) Int(key string) (int, error) {
+	value, layer, ok := c.Lookup(key)
+	if !ok {
+		return 0, missing(key)
+	}
+
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, malformed(key, layer, value, "integer")
+	}
+	return result, nil
+}
+
+// Int64 parses the resolved value of a key as a 64-bit integer, for sizes
+// that may not fit an int on 32-bit platforms.
+func (c *Config) Int64(key string) (int64, error) {
+	value, layer, ok := c.Lookup(key)
+	if !ok {
+		return 0, missing(key)
+	}
+
+	result, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, malformed(key, layer, value, "integer")
+	}
+	return result, nil
+}
+
+// Bool parses the resolved value of a key as a boolean.
+func (c *Config) Bool(key string) (bool, error) {
+	value, layer, ok := c.Lookup(key)
+	if !ok {
+		return false, missing(key)
+	}
+
+	result, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, malformed(key, layer, value, "boolean")
+	}
+	return result, nil
+}
+
+// Float parses the resolved value of a key as a float.
+func (c *Config) Float(key string) (float64, error) {
+	value, layer, ok := c.Lookup(key)
+	if !ok {
+		return 0, missing(key)
+	}
+
+	result, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, malformed(key, layer, value, "number")
+	}
+	return result, nil
+}
+
+// Duration parses the resolved value of a key using time.ParseDuration.
+func (c *Config) Duration(key string) (time.Duration, error) {
+	value, layer, ok := c.Lookup(key)
+	if !ok {
+		return 0, missing(key)
+	}
+
+	result, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, malformed(key, layer, value, "duration")
+	}
+	return result, nil
+}
+
+// Keys returns every key known to any layer, sorted.
+func (c *Config) Keys() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	for _, layer := range c.layers {
+		for key := range layer {
+			seen[key] = true
+		}
+	}
+
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// AddRule registers a validation rule executed by Validate.
+func (c *Config) AddRule(rules ...Rule) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.rules = append(c.rules, rules...)
+}
+
+// Validate runs every registered rule and reports all failures at once.
+func (c *Config) Validate() error {
+	c.mu.RLock()
+	rules := append([]Rule(nil), c.rules...)
+	c.mu.RUnlock()
+
+	var messages []string
+	for _, rule := range rules {
+		if err := rule(c); err != nil {
+			// Accessor errors carry the package prefix already.
+			messages = append(messages, strings.TrimPrefix(err.Error(), "config: "))
+		}
+	}
+
+	if len(messages) == 0 {
+		return nil
+	}
+	return errors.New("config: " + strings.Join(messages, "; "))
+}
+
+// Load is the usual startup sequence: read the file named by the key
+// "config" (if any), then the process environment, then the parsed flags,
+// and finally validate the result.
+func (c *Config) Load(flags *flag.FlagSet) error {
+	c.LoadEnv(os.Environ())
+
+	if flags != nil {
+		c.LoadFlags(flags)
+	}
+
+	if path := c.String("config"); len(path) > 0 {
+		if err := c.LoadFile(path); err != nil {
+			return err
+		}
+	}
+	return c.Validate()
+}
+
+func missing(key string) error {
+	return fmt.Errorf("config: %s is not set", key)
+}
+
+func malformed(key string, layer Layer, value, kind string) error {
+	return fmt.Errorf("config: %s=%q from %s is not a valid %s",
+		key, value, layer, kind)
+}
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLookupPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.json")
+	document := `{"server": {"listen": ":8081", "name": "file"}, "executor": {"max_output": 10}}`
+
+	if err := os.WriteFile(file, []byte(document), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := New("app")
+	cfg.SetDefault("server.listen", ":8080")
+	cfg.SetDefault("server.name", "default")
+	cfg.SetDefault("server.mode", "default")
+	cfg.SetDefault("executor.max_output", 0)
+	cfg.SetDefault("executor.max-lines", 0)
+
+	if err := cfg.LoadFile(file); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.LoadEnv([]string{
+		"APP_SERVER_NAME=env",
+		"APP_EXECUTOR_MAX_OUTPUT=20",
+		"APP_EXECUTOR_MAX_LINES=5",
+		"OTHER_SERVER_MODE=ignored",
+	})
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.String("server-listen", "", "")
+	flags.String("executor.max-lines", "", "")
+	flags.String("server.mode", "unset", "")
+
+	if err := flags.Parse([]string{"-server-listen=:9090", "-executor.max-lines=7"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg.LoadFlags(flags)
+
+	tests := []struct {
+		key   string
+		value string
+		layer Layer
+	}{
+		{"server.listen", ":9090", Flag},
+		{"server.name", "env", Env},
+		{"server.mode", "default", Default},
+		{"executor.max_output", "20", Env},
+		{"executor.max-lines", "7", Flag},
+		{"SERVER.NAME", "env", Env},
+	}
+
+	for _, test := range tests {
+		value, layer, ok := cfg.Lookup(test.key)
+		if !ok || value != test.value || layer != test.layer {
+			t.Errorf("Lookup(%q) = %q, %v, %v; want %q, %v", test.key, value, layer, ok, test.value, test.layer)
+		}
+	}
+}
+
+func TestAccessors(t *testing.T) {
+	cfg := New("app")
+	cfg.Set(File, "int", "42")
+	cfg.Set(File, "size", "1099511627776")
+	cfg.Set(File, "bad", "x")
+	cfg.Set(File, "bool", "true")
+	cfg.Set(File, "duration", "1m30s")
+	cfg.Set(File, "list", "a, b ,c")
+
+	if value, err := cfg.Int("int"); err != nil || value != 42 {
+		t.Errorf("Int = %d, %v", value, err)
+	}
+	if value, err := cfg.Int64("size"); err != nil || value != 1<<40 {
+		t.Errorf("Int64 = %d, %v", value, err)
+	}
+	if value, err := cfg.Bool("bool"); err != nil || !value {
+		t.Errorf("Bool = %v, %v", value, err)
+	}
+	if value, err := cfg.Duration("duration"); err != nil || value.Seconds() != 90 {
+		t.Errorf("Duration = %v, %v", value, err)
+	}
+	if value := cfg.Strings("list"); strings.Join(value, "|") != "a|b|c" {
+		t.Errorf("Strings = %q", value)
+	}
+	if _, err := cfg.Int("bad"); err == nil {
+		t.Error("Int accepted a malformed value")
+	}
+	if _, err := cfg.Int("absent"); err == nil {
+		t.Error("Int accepted a missing key")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		values map[string]string
+		rules  []Rule
+		want   string
+	}{
+		{
+			name:   "valid",
+			values: map[string]string{"workers": "4", "mode": "fast"},
+			rules:  []Rule{Required("workers"), IsInt("workers", 1, 8), OneOf("mode", "fast", "slow")},
+		},
+		{
+			name:   "all failures reported once prefixed",
+			values: map[string]string{"workers": "x", "timeout": "soon"},
+			rules:  []Rule{Required("listen"), IsInt("workers", 1, 8), IsDuration("timeout")},
+			want:   `config: missing listen; workers="x" from file is not a valid integer; timeout="soon" from file is not a valid duration`,
+		},
+		{
+			name:   "out of range",
+			values: map[string]string{"workers": "9"},
+			rules:  []Rule{IsInt("workers", 1, 8)},
+			want:   "config: workers=9 is outside [1, 8]",
+		},
+		{
+			name:   "64-bit range",
+			values: map[string]string{"max_output": "2199023255552"},
+			rules:  []Rule{IsInt64("max_output", 0, 1<<40)},
+			want:   "config: max_output=2199023255552 is outside [0, 1099511627776]",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := New("app")
+			for key, value := range test.values {
+				cfg.Set(File, key, value)
+			}
+			cfg.AddRule(test.rules...)
+
+			err := cfg.Validate()
+			if len(test.want) == 0 {
+				if err != nil {
+					t.Fatalf("Validate = %v", err)
+				}
+				return
+			}
+
+			if err == nil || err.Error() != test.want {
+				t.Fatalf("Validate = %v; want %s", err, test.want)
+			}
+		})
+	}
+}
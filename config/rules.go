@@ -0,0 +1,105 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Required fails when any of the keys is missing or empty.
+func Required(keys ...string) Rule {
+	return func(cfg *Config) error {
+		var absent []string
+
+		for _, key := range keys {
+			if len(cfg.String(key)) == 0 {
+				absent = append(absent, key)
+			}
+		}
+
+		if len(absent) > 0 {
+			return fmt.Errorf("missing %s", strings.Join(absent, ", "))
+		}
+		return nil
+	}
+}
+
+// IsInt fails when the key is set but is not an integer within [min, max].
+func IsInt(key string, min, max int) Rule {
+	return func(cfg *Config) error {
+		if !cfg.Has(key) {
+			return nil
+		}
+
+		value, err := cfg.Int(key)
+		if err != nil {
+			return err
+		}
+
+		if value < min || value > max {
+			return fmt.Errorf("%s=%d is outside [%d, %d]", key, value, min, max)
+		}
+		return nil
+	}
+}
+
+// IsInt64 is IsInt for 64-bit values such as byte sizes.
+func IsInt64(key string, min, max int64) Rule {
+	return func(cfg *Config) error {
+		if !cfg.Has(key) {
+			return nil
+		}
+
+		value, err := cfg.Int64(key)
+		if err != nil {
+			return err
+		}
+
+		if value < min || value > max {
+			return fmt.Errorf("%s=%d is outside [%d, %d]", key, value, min, max)
+		}
+		return nil
+	}
+}
+
+// IsDuration fails when the key is set but is not a valid duration.
+func IsDuration(key string) Rule {
+	return func(cfg *Config) error {
+		if !cfg.Has(key) {
+			return nil
+		}
+
+		_, err := cfg.Duration(key)
+		return err
+	}
+}
+
+// IsBool fails when the key is set but is not a valid boolean.
+func IsBool(key string) Rule {
+	return func(cfg *Config) error {
+		if !cfg.Has(key) {
+			return nil
+		}
+
+		_, err := cfg.Bool(key)
+		return err
+	}
+}
+
+// OneOf fails when the key is set to a value outside choices.
+func OneOf(key string, choices ...string) Rule {
+	return func(cfg *Config) error {
+		if !cfg.Has(key) {
+			return nil
+		}
+
+		value := cfg.String(key)
+		for _, choice := range choices {
+			if value == choice {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("%s=%q must be one of %s",
+			key, value, strings.Join(choices, ", "))
+	}
+}
@@ -0,0 +1,36 @@
+package dataloader
+
+import (
+	"context"
+	"net/http"
+)
+
+type contextKey struct{}
+
+// Factories builds the loaders of one request, keyed by name.
+type Factories map[string]func() *Loader
+
+// Attach returns a context carrying fresh loaders built by factories.
+func Attach(ctx context.Context, factories Factories) context.Context {
+	loaders := make(map[string]*Loader, len(factories))
+	for name, factory := range factories {
+		loaders[name] = factory()
+	}
+	return context.WithValue(ctx, contextKey{}, loaders)
+}
+
+// From returns the loader called name attached to ctx, or nil.
+func From(ctx context.Context, name string) *Loader {
+	loaders, _ := ctx.Value(contextKey{}).(map[string]*Loader)
+	return loaders[name]
+}
+
+// Middleware attaches fresh loaders to every request, so caches never
+// leak data between callers.
+func Middleware(factories Factories) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(Attach(r.Context(), factories)))
+		})
+	}
+}
@@ -0,0 +1,225 @@
+// Package dataloader collapses the N+1 lookups of nested GraphQL
+// resolvers into batched calls. Loads issued within a short window are
+// grouped into one batch function call, and results are cached for the
+// lifetime of the loader, which is normally a single request.
+package dataloader
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned for keys the batch function didn't resolve.
+var ErrNotFound = errors.New("dataloader: not found")
+
+// BatchFunc resolves many keys at once. Keys missing from the result map
+// are reported as ErrNotFound; a returned error fails the whole batch.
+type BatchFunc func(ctx context.Context, keys []string) (map[string]interface{}, error)
+
+// Options configures a Loader.
+type Options struct {
+	// Wait is how long the first load of a batch waits for others to
+	// join; it defaults to one millisecond.
+	Wait time.Duration
+
+	// MaxBatch dispatches a batch early once it holds this many keys;
+	// zero means unlimited.
+	MaxBatch int
+
+	// NoCache disables result caching, so every Load reaches a batch.
+	NoCache bool
+
+	// Timeout bounds every batch function call; it defaults to 30
+	// seconds, or less when the load that opened the batch has an
+	// earlier deadline.
+	Timeout time.Duration
+}
+
+type result struct {
+	value interface{}
+	err   error
+	done  chan struct{}
+}
+
+func (r *result) wait(ctx context.Context) (interface{}, error) {
+	select {
+	case <-r.done:
+		return r.value, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type batch struct {
+	keys    []string
+	results map[string]*result
+	timer   *time.Timer
+}
+
+// Loader batches and caches lookups of one kind of object.
+type Loader struct {
+	fetch BatchFunc
+	opts  Options
+	mu    sync.Mutex
+	cache map[string]*result
+	batch *batch
+}
+
+// New creates a loader around fetch.
+func New(fetch BatchFunc, opts Options) *Loader {
+	if opts.Wait <= 0 {
+		opts.Wait = time.Millisecond
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+
+	return &Loader{
+		fetch: fetch,
+		opts:  opts,
+		cache: make(map[string]*result),
+	}
+}
+
+// Load returns the value of key, waiting for the batch it joins.
+func (l *Loader) Load(ctx context.Context, key string) (interface{}, error) {
+	return l.schedule(ctx, key).wait(ctx)
+}
+
+// LoadMany returns the values of keys in order; errs[i] is set for keys
+// that failed.
+func (l *Loader) LoadMany(ctx context.Context, keys []string) ([]interface{}, []error) {
+	pending := make([]*result, len(keys))
+	for i, key := range keys {
+		pending[i] = l.schedule(ctx, key)
+	}
+
+	values := make([]interface{}, len(keys))
+	errs := make([]error, len(keys))
+
+	for i, item := range pending {
+		values[i], errs[i] = item.wait(ctx)
+	}
+	return values, errs
+}
+
+// Prime stores a value in the cache, e.g. after a mutation or when a list
+// query already returned the object.
+func (l *Loader) Prime(key string, value interface{}) {
+	done := make(chan struct{})
+	close(done)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.cache[key] = &result{value: value, done: done}
+}
+
+// Clear drops a key from the cache.
+func (l *Loader) Clear(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.cache, key)
+}
+
+func (l *Loader) schedule(ctx context.Context, key string) *result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if item, ok := l.cache[key]; ok && !l.opts.NoCache {
+		return item
+	}
+
+	if l.batch == nil {
+		current := &batch{results: make(map[string]*result)}
+		current.timer = time.AfterFunc(l.opts.Wait, func() {
+			l.mu.Lock()
+			expired := l.batch == current
+			if expired {
+				l.batch = nil
+			}
+			l.mu.Unlock()
+
+			if expired {
+				l.dispatch(ctx, current)
+			}
+		})
+		l.batch = current
+	}
+
+	if item, ok := l.batch.results[key]; ok {
+		return item
+	}
+
+	item := &result{done: make(chan struct{})}
+	l.batch.keys = append(l.batch.keys, key)
+	l.batch.results[key] = item
+
+	if !l.opts.NoCache {
+		l.cache[key] = item
+	}
+
+	if l.opts.MaxBatch > 0 && len(l.batch.keys) >= l.opts.MaxBatch {
+		current := l.batch
+		current.timer.Stop()
+		l.batch = nil
+		go l.dispatch(ctx, current)
+	}
+	return item
+}
+
+// dispatch runs a batch that has already been detached from the loader.
+func (l *Loader) dispatch(ctx context.Context, current *batch) {
+	// The batch outlives the load that opened it, so it must not be
+	// cancelled just because that particular caller gave up. It keeps a
+	// deadline though, or a stuck backend would hold the batch and every
+	// load waiting on its cached results forever.
+	timeout := l.opts.Timeout
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
+
+	fetchCtx, cancel := context.WithTimeout(detach(ctx), timeout)
+	defer cancel()
+
+	values, err := l.fetch(fetchCtx, current.keys)
+
+	for key, item := range current.results {
+		if err != nil {
+			item.err = err
+		} else if value, ok := values[key]; ok {
+			item.value = value
+		} else {
+			item.err = ErrNotFound
+		}
+		close(item.done)
+	}
+
+	if err != nil {
+		// Failed lookups must not stay cached.
+		l.mu.Lock()
+		for key, item := range current.results {
+			if l.cache[key] == item {
+				delete(l.cache, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// detached keeps the values of a context but drops its cancellation.
+type detached struct {
+	context.Context
+	parent context.Context
+}
+
+func detach(ctx context.Context) context.Context {
+	return detached{Context: context.Background(), parent: ctx}
+}
+
+func (d detached) Value(key interface{}) interface{} {
+	return d.parent.Value(key)
+}
@@ -0,0 +1,205 @@
+package dataloader
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is a batch function that remembers the batches it saw.
+type recorder struct {
+	mu      sync.Mutex
+	batches [][]string
+	err     error
+}
+
+func (r *recorder) fetch(ctx context.Context, keys []string) (map[string]interface{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sorted := append([]string(nil), keys...)
+	sort.Strings(sorted)
+	r.batches = append(r.batches, sorted)
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	values := make(map[string]interface{})
+	for _, key := range keys {
+		if !strings.HasPrefix(key, "missing") {
+			values[key] = strings.ToUpper(key)
+		}
+	}
+	return values, nil
+}
+
+func (r *recorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.batches)
+}
+
+func TestLoadMany(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		keys    []string
+		batches int
+		err     error
+	}{
+		{"one batch", Options{}, []string{"a", "b", "c"}, 1, nil},
+		{"duplicates", Options{}, []string{"a", "a", "b"}, 1, nil},
+		{"max batch", Options{MaxBatch: 2, Wait: time.Hour}, []string{"a", "b", "c", "d"}, 2, nil},
+		{"missing", Options{}, []string{"a", "missing"}, 1, ErrNotFound},
+	}
+
+	for _, test := range tests {
+		fetch := &recorder{}
+		loader := New(fetch.fetch, test.opts)
+
+		values, errs := loader.LoadMany(context.Background(), test.keys)
+
+		for i, key := range test.keys {
+			switch {
+			case strings.HasPrefix(key, "missing"):
+				if !errors.Is(errs[i], test.err) {
+					t.Errorf("%s: %s error = %v; want %v", test.name, key, errs[i], test.err)
+				}
+			case errs[i] != nil || values[i] != strings.ToUpper(key):
+				t.Errorf("%s: %s = %v, %v", test.name, key, values[i], errs[i])
+			}
+		}
+
+		if got := fetch.count(); got != test.batches {
+			t.Errorf("%s: %d batches; want %d", test.name, got, test.batches)
+		}
+	}
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		opts    Options
+		err     error
+		prime   bool
+		clear   bool
+		batches int
+	}{
+		{"cached", Options{}, nil, false, false, 1},
+		{"no cache", Options{NoCache: true}, nil, false, false, 2},
+		{"errors are not cached", Options{}, errors.New("down"), false, false, 2},
+		{"primed", Options{}, nil, true, false, 0},
+		{"cleared", Options{}, nil, false, true, 2},
+	}
+
+	for _, test := range tests {
+		fetch := &recorder{err: test.err}
+		loader := New(fetch.fetch, test.opts)
+
+		if test.prime {
+			loader.Prime("a", "A")
+		}
+
+		for i := 0; i < 2; i++ {
+			value, err := loader.Load(ctx, "a")
+			if err != test.err || (err == nil && value != "A") {
+				t.Errorf("%s: Load = %v, %v", test.name, value, err)
+			}
+			if test.clear {
+				loader.Clear("a")
+			}
+		}
+
+		if got := fetch.count(); got != test.batches {
+			t.Errorf("%s: %d batches; want %d", test.name, got, test.batches)
+		}
+	}
+}
+
+type key struct{}
+
+func TestBatchContext(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		opener  time.Duration
+		cancel  bool
+		within  time.Duration
+	}{
+		{"options timeout", 20 * time.Millisecond, 0, false, time.Second},
+		{"opener deadline", time.Hour, 20 * time.Millisecond, false, time.Second},
+		{"opener cancelled", 20 * time.Millisecond, 0, true, time.Second},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			type outcome struct {
+				value interface{}
+				err   error
+			}
+			seen := make(chan outcome, 1)
+
+			loader := New(func(ctx context.Context, keys []string) (map[string]interface{}, error) {
+				<-ctx.Done()
+				seen <- outcome{ctx.Value(key{}), ctx.Err()}
+				return nil, ctx.Err()
+			}, Options{Timeout: test.timeout})
+
+			ctx := context.WithValue(context.Background(), key{}, "request")
+			if test.opener > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, test.opener)
+				defer cancel()
+			}
+
+			opened, cancel := context.WithCancel(ctx)
+			loader.schedule(opened, "a")
+			if test.cancel {
+				// The opener giving up must not cancel the batch early.
+				cancel()
+			}
+			defer cancel()
+
+			select {
+			case got := <-seen:
+				if got.value != "request" {
+					t.Errorf("batch context value = %v", got.value)
+				}
+				if got.err != context.DeadlineExceeded {
+					t.Errorf("batch context error = %v; want deadline exceeded", got.err)
+				}
+			case <-time.After(test.within):
+				t.Fatal("batch never timed out")
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	factories := Factories{"jobs": func() *Loader { return New((&recorder{}).fetch, Options{}) }}
+
+	first := From(Attach(context.Background(), factories), "jobs")
+	second := From(Attach(context.Background(), factories), "jobs")
+
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"attached", first != nil},
+		{"per request", first != second},
+		{"unknown", From(Attach(context.Background(), factories), "users") == nil},
+		{"not attached", From(context.Background(), "jobs") == nil},
+	}
+
+	for _, test := range tests {
+		if !test.ok {
+			t.Errorf("%s failed", test.name)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package download
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"devops.io/cloud/compress"
+)
+
+func TestDisposition(t *testing.T) {
+	tests := []struct {
+		kind string
+		name string
+		want string
+	}{
+		{"attachment", "report.csv", `attachment; filename="report.csv"`},
+		{"inline", "a b.txt", `inline; filename="a b.txt"`},
+		{"attachment", "résumé.pdf", `attachment; filename="r_sum_.pdf"; filename*=UTF-8''r%C3%A9sum%C3%A9.pdf`},
+		{"attachment", "日本 (1);x=y.txt", `attachment; filename="__ (1);x=y.txt"; filename*=UTF-8''%E6%97%A5%E6%9C%AC%20%281%29%3Bx%3Dy.txt`},
+		{"attachment", `q"uote.txt`, `attachment; filename="q_uote.txt"; filename*=UTF-8''q%22uote.txt`},
+	}
+
+	for _, test := range tests {
+		if got := Disposition(test.kind, test.name); got != test.want {
+			t.Errorf("Disposition(%q, %q) = %s; want %s", test.kind, test.name, got, test.want)
+		}
+	}
+}
+
+func TestServeFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.txt")
+	if err := os.WriteFile(path, []byte("0123456789"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	first := httptest.NewRecorder()
+	ServeFile(first, httptest.NewRequest(http.MethodGet, "/", nil), path, Options{})
+	etag := first.Header().Get("ETag")
+
+	if strings.HasPrefix(etag, "W/") || len(etag) == 0 {
+		t.Fatalf("ETag = %q; want a strong tag", etag)
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		header map[string]string
+		status int
+		body   string
+	}{
+		{"full", path, nil, http.StatusOK, "0123456789"},
+		{"range", path, map[string]string{"Range": "bytes=2-4"}, http.StatusPartialContent, "234"},
+		{"if-range match", path, map[string]string{"Range": "bytes=5-", "If-Range": etag}, http.StatusPartialContent, "56789"},
+		{"if-range stale", path, map[string]string{"Range": "bytes=5-", "If-Range": `"stale"`}, http.StatusOK, "0123456789"},
+		{"not modified", path, map[string]string{"If-None-Match": etag}, http.StatusNotModified, ""},
+		{"missing", filepath.Join(dir, "absent"), nil, http.StatusNotFound, "not found\n"},
+		{"directory", dir, nil, http.StatusForbidden, "is a directory\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for key, value := range test.header {
+				r.Header.Set(key, value)
+			}
+
+			w := httptest.NewRecorder()
+			ServeFile(w, r, test.path, Options{})
+
+			if w.Code != test.status || w.Body.String() != test.body {
+				t.Errorf("got %d %q; want %d %q", w.Code, w.Body.String(), test.status, test.body)
+			}
+		})
+	}
+}
+
+func TestServeCompressed(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "build.log")
+	content := strings.Repeat("step succeeded\n", 500)
+
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		header     map[string]string
+		compressed bool
+		status     int
+	}{
+		{"accepted", map[string]string{"Accept-Encoding": "gzip"}, true, http.StatusOK},
+		{"not accepted", nil, false, http.StatusOK},
+		{"range stays plain", map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=0-9"}, false, http.StatusPartialContent},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for key, value := range test.header {
+				r.Header.Set(key, value)
+			}
+
+			w := httptest.NewRecorder()
+			ServeFile(w, r, path, Options{Compress: &compress.Options{}})
+
+			encoded := w.Header().Get("Content-Encoding") == "gzip"
+			if w.Code != test.status || encoded != test.compressed {
+				t.Fatalf("got %d, Content-Encoding %q", w.Code, w.Header().Get("Content-Encoding"))
+			}
+
+			etag := w.Header().Get("ETag")
+			if strings.HasSuffix(etag, `-gzip"`) != test.compressed {
+				t.Errorf("ETag = %s", etag)
+			}
+			if w.Header().Get("Vary") != "Accept-Encoding" {
+				t.Errorf("Vary = %q", w.Header().Get("Vary"))
+			}
+
+			if encoded {
+				reader, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if body, _ := io.ReadAll(reader); string(body) != content {
+					t.Errorf("decompressed %d bytes", len(body))
+				}
+			}
+		})
+	}
+}
+
+func TestServeIncompressible(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "photo.png")
+	content := strings.Repeat("\x89PNG", 500)
+
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	first := httptest.NewRecorder()
+	ServeFile(first, r, path, Options{Compress: &compress.Options{}})
+
+	etag := first.Header().Get("ETag")
+	if len(first.Header().Get("Content-Encoding")) > 0 || strings.HasSuffix(etag, `-gzip"`) {
+		t.Fatalf("plain response sent ETag %s, Content-Encoding %q", etag, first.Header().Get("Content-Encoding"))
+	}
+
+	// Resuming with the tag of the plain body must yield the rest of it.
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("Range", "bytes=10-")
+	r.Header.Set("If-Range", etag)
+
+	resumed := httptest.NewRecorder()
+	ServeFile(resumed, r, path, Options{Compress: &compress.Options{}})
+
+	if resumed.Code != http.StatusPartialContent || resumed.Body.String() != content[10:] {
+		t.Errorf("resume got %d with %d bytes", resumed.Code, resumed.Body.Len())
+	}
+}
@@ -0,0 +1,185 @@
+// Package drain puts a server in maintenance mode. While draining, new
+// requests get 503 with Retry-After except on exempt (admin) endpoints,
+// requests already in flight are left to finish, and shutdown waits for
+// them and then for background work such as running jobs.
+package drain
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Options configures a Drainer.
+type Options struct {
+	// RetryAfter is advertised to rejected clients; it defaults to 30
+	// seconds.
+	RetryAfter time.Duration
+
+	// Exempt selects requests served even while draining, typically the
+	// admin API used to toggle the mode.
+	Exempt func(*http.Request) bool
+}
+
+// Drainer tracks in-flight requests and the drain state.
+type Drainer struct {
+	opts     Options
+	mu       sync.Mutex
+	draining bool
+	inFlight int
+	idle     chan struct{}
+}
+
+// New creates a drainer serving normally.
+func New(opts Options) *Drainer {
+	if opts.RetryAfter <= 0 {
+		opts.RetryAfter = 30 * time.Second
+	}
+
+	idle := make(chan struct{})
+	close(idle)
+
+	return &Drainer{opts: opts, idle: idle}
+}
+
+// Drain stops accepting new requests.
+func (d *Drainer) Drain() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.draining = true
+}
+
+// Resume accepts requests again.
+func (d *Drainer) Resume() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.draining = false
+}
+
+// Draining reports whether new requests are rejected.
+func (d *Drainer) Draining() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.draining
+}
+
+// InFlight returns the number of requests being served.
+func (d *Drainer) InFlight() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.inFlight
+}
+
+// Wait blocks until no tracked request is in flight or ctx expires.
+func (d *Drainer) Wait(ctx context.Context) error {
+	d.mu.Lock()
+	idle := d.idle
+	d.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown drains, waits for in-flight requests, then runs each wait in
+// order, e.g. a workerpool's Drain so running jobs complete. It returns
+// the first error, usually ctx expiring.
+func (d *Drainer) Shutdown(ctx context.Context, waits ...func(context.Context) error) error {
+	d.Drain()
+	if err := d.Wait(ctx); err != nil {
+		return err
+	}
+
+	for _, wait := range waits {
+		if err := wait(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Middleware rejects new requests while draining and tracks the ones it
+// lets through.
+func (d *Drainer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Exempt requests aren't tracked either, so an admin request can
+		// trigger a shutdown without waiting for itself.
+		if d.opts.Exempt != nil && d.opts.Exempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !d.enter() {
+			w.Header().Set("Retry-After", strconv.Itoa(int(d.opts.RetryAfter/time.Second)))
+			w.Header().Set("Connection", "close")
+			http.Error(w, "server is draining", http.StatusServiceUnavailable)
+			return
+		}
+		defer d.leave()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Handler toggles the mode: GET reports it, POST starts draining and
+// DELETE resumes. It must be mounted behind the admin authentication
+// and exempted from draining.
+func (d *Drainer) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			d.Drain()
+		case http.MethodDelete:
+			d.Resume()
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		d.mu.Lock()
+		status := map[string]interface{}{
+			"draining":  d.draining,
+			"in_flight": d.inFlight,
+		}
+		d.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	})
+}
+
+func (d *Drainer) enter() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.draining {
+		return false
+	}
+
+	if d.inFlight == 0 {
+		d.idle = make(chan struct{})
+	}
+	d.inFlight++
+	return true
+}
+
+func (d *Drainer) leave() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.inFlight--
+	if d.inFlight == 0 {
+		close(d.idle)
+	}
+}
@@ -0,0 +1,159 @@
+package drain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	d := New(Options{
+		RetryAfter: 5 * time.Second,
+		Exempt:     func(r *http.Request) bool { return strings.HasPrefix(r.URL.Path, "/admin") },
+	})
+	handler := d.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name     string
+		draining bool
+		path     string
+		code     int
+		retry    string
+	}{
+		{"serving", false, "/jobs", 200, ""},
+		{"draining", true, "/jobs", 503, "5"},
+		{"draining admin", true, "/admin/drain", 200, ""},
+		{"resumed", false, "/jobs", 200, ""},
+	}
+
+	for _, test := range tests {
+		if test.draining {
+			d.Drain()
+		} else {
+			d.Resume()
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+
+		if w.Code != test.code || w.Header().Get("Retry-After") != test.retry {
+			t.Errorf("%s: %d Retry-After %q; want %d %q", test.name, w.Code, w.Header().Get("Retry-After"), test.code, test.retry)
+		}
+		if d.Draining() != test.draining {
+			t.Errorf("%s: Draining = %v", test.name, d.Draining())
+		}
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	tests := []struct {
+		name    string
+		hold    bool
+		waits   []func(context.Context) error
+		timeout time.Duration
+		err     error
+	}{
+		{"idle", false, nil, time.Second, nil},
+		{"waits for requests", true, nil, time.Second, nil},
+		{"request outlives ctx", true, nil, 0, context.DeadlineExceeded},
+		{"runs waits", false, []func(context.Context) error{
+			func(context.Context) error { return nil },
+		}, time.Second, nil},
+		{"wait error", false, []func(context.Context) error{
+			func(context.Context) error { return errors.New("jobs still running") },
+			func(context.Context) error { t.Error("wait after a failure ran"); return nil },
+		}, time.Second, errors.New("jobs still running")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := New(Options{})
+			release := make(chan struct{})
+			entered := make(chan struct{})
+			done := make(chan struct{})
+
+			handler := d.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(entered)
+				<-release
+			}))
+
+			if test.hold {
+				go func() {
+					handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+					close(done)
+				}()
+				<-entered
+
+				if d.InFlight() != 1 {
+					t.Errorf("InFlight = %d; want 1", d.InFlight())
+				}
+				if test.timeout > 0 {
+					time.AfterFunc(20*time.Millisecond, func() { close(release) })
+				}
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), test.timeout+10*time.Millisecond)
+			defer cancel()
+
+			err := d.Shutdown(ctx, test.waits...)
+			if (err == nil) != (test.err == nil) || (err != nil && err.Error() != test.err.Error()) {
+				t.Errorf("Shutdown = %v; want %v", err, test.err)
+			}
+			if !d.Draining() {
+				t.Error("Shutdown didn't drain")
+			}
+
+			if test.hold {
+				if test.timeout == 0 {
+					close(release)
+				}
+				<-done
+			}
+			if d.InFlight() != 0 {
+				t.Errorf("InFlight = %d after the request", d.InFlight())
+			}
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	d := New(Options{})
+
+	tests := []struct {
+		method   string
+		code     int
+		draining bool
+	}{
+		{"GET", 200, false},
+		{"POST", 200, true},
+		{"GET", 200, true},
+		{"DELETE", 200, false},
+		{"PUT", 405, false},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		d.Handler().ServeHTTP(w, httptest.NewRequest(test.method, "/", nil))
+
+		if w.Code != test.code {
+			t.Errorf("%s = %d; want %d", test.method, w.Code, test.code)
+			continue
+		}
+		if w.Code != 200 {
+			continue
+		}
+
+		var status struct {
+			Draining bool `json:"draining"`
+			InFlight int  `json:"in_flight"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil || status.Draining != test.draining {
+			t.Errorf("%s: status %s; want draining %v", test.method, w.Body.String(), test.draining)
+		}
+	}
+}
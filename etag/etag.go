@@ -0,0 +1,125 @@
+// Package etag computes entity tags for resource handlers and evaluates
+// the conditional request headers against them: If-None-Match for cache
+// revalidation and If-Match for optimistic concurrency on updates.
+package etag
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Of returns a strong entity tag for the JSON form of value. Two values
+// encoding to the same JSON get the same tag.
+func Of(value interface{}) (string, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return Bytes(raw), nil
+}
+
+// Bytes returns a strong entity tag for raw content.
+func Bytes(raw []byte) string {
+	digest := sha256.Sum256(raw)
+	return `"` + hex.EncodeToString(digest[:16]) + `"`
+}
+
+// Version returns a strong entity tag for a resource carrying its own
+// revision counter, which is cheaper than hashing the content.
+func Version(version interface{}) string {
+	return fmt.Sprintf(`"v%v"`, version)
+}
+
+// Set writes the ETag response header.
+func Set(w http.ResponseWriter, tag string) {
+	w.Header().Set("ETag", tag)
+}
+
+// opaque strips the weak prefix of a tag.
+func opaque(tag string) string {
+	return strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+}
+
+// matches reports whether tag appears in a comma-separated header list.
+// strong requests the strong comparison of RFC 7232 section 2.3.2, where
+// weak tags never match.
+func matches(header, tag string, strong bool) bool {
+	if strong && strings.HasPrefix(tag, "W/") {
+		return false
+	}
+
+	for _, item := range strings.Split(header, ",") {
+		item = strings.TrimSpace(item)
+
+		if item == "*" {
+			return true
+		}
+
+		if strong && strings.HasPrefix(item, "W/") {
+			continue
+		}
+
+		if opaque(item) == opaque(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check evaluates the conditional headers of r against the current tag of
+// the resource; current is empty when the resource does not exist. It
+// writes 304 or 412 and returns false when the handler must stop, and
+// returns true when the request may proceed.
+//
+// For GET and HEAD a matching If-None-Match yields 304. For other methods
+// a failing If-Match, or If-None-Match matching an existing resource
+// (including "If-None-Match: *" used to create-only), yields 412.
+func Check(w http.ResponseWriter, r *http.Request, current string) bool {
+	safe := r.Method == http.MethodGet || r.Method == http.MethodHead
+
+	if header := r.Header.Get("If-Match"); len(header) > 0 {
+		if len(current) == 0 || !matches(header, current, true) {
+			return fail(w, current)
+		}
+	}
+
+	if header := r.Header.Get("If-None-Match"); len(header) > 0 && len(current) > 0 {
+		if matches(header, current, false) {
+			if safe {
+				Set(w, current)
+				w.WriteHeader(http.StatusNotModified)
+				return false
+			}
+			return fail(w, current)
+		}
+	}
+	return true
+}
+
+// Require behaves like Check but additionally answers 428 Precondition
+// Required when an unsafe request carries no If-Match or If-None-Match,
+// forcing clients into optimistic concurrency.
+func Require(w http.ResponseWriter, r *http.Request, current string) bool {
+	safe := r.Method == http.MethodGet || r.Method == http.MethodHead
+
+	if !safe && len(r.Header.Get("If-Match")) == 0 && len(r.Header.Get("If-None-Match")) == 0 {
+		http.Error(w, "this request requires an If-Match header",
+			http.StatusPreconditionRequired)
+		return false
+	}
+	return Check(w, r, current)
+}
+
+func fail(w http.ResponseWriter, current string) bool {
+	if len(current) > 0 {
+		Set(w, current)
+	}
+
+	http.Error(w, "the resource was modified; fetch it again and retry",
+		http.StatusPreconditionFailed)
+	return false
+}
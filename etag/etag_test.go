@@ -0,0 +1,109 @@
+package etag
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTags(t *testing.T) {
+	a, _ := Of(map[string]int{"a": 1, "b": 2})
+	b, _ := Of(map[string]int{"b": 2, "a": 1})
+	c, _ := Of(map[string]int{"a": 2})
+
+	tests := []struct {
+		name  string
+		got   string
+		want  string
+		equal bool
+	}{
+		{"same JSON", a, b, true},
+		{"different JSON", a, c, false},
+		{"bytes", Bytes([]byte("x")), Bytes([]byte("x")), true},
+		{"version", Version(7), `"v7"`, true},
+	}
+
+	for _, test := range tests {
+		if (test.got == test.want) != test.equal {
+			t.Errorf("%s: %s vs %s, equal = %v", test.name, test.got, test.want, !test.equal)
+		}
+	}
+
+	if _, err := Of(make(chan int)); err == nil {
+		t.Error("Of accepted an unencodable value")
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		header string
+		tag    string
+		strong bool
+		want   bool
+	}{
+		{`"a"`, `"a"`, true, true},
+		{`"b", "a"`, `"a"`, true, true},
+		{`"b"`, `"a"`, true, false},
+		{`*`, `"a"`, true, true},
+		{`W/"a"`, `"a"`, true, false},
+		{`W/"a"`, `"a"`, false, true},
+		{`"a"`, `W/"a"`, true, false},
+		{`"a"`, `W/"a"`, false, true},
+	}
+
+	for _, test := range tests {
+		if got := matches(test.header, test.tag, test.strong); got != test.want {
+			t.Errorf("matches(%s, %s, %v) = %v; want %v", test.header, test.tag, test.strong, got, test.want)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		header  string
+		value   string
+		current string
+		require bool
+		proceed bool
+		code    int
+	}{
+		{"plain get", "GET", "", "", `"a"`, false, true, 200},
+		{"get not modified", "GET", "If-None-Match", `"a"`, `"a"`, false, false, 304},
+		{"get weak not modified", "HEAD", "If-None-Match", `W/"a"`, `"a"`, false, false, 304},
+		{"get modified", "GET", "If-None-Match", `"b"`, `"a"`, false, true, 200},
+		{"update matching", "PUT", "If-Match", `"a"`, `"a"`, false, true, 200},
+		{"update stale", "PUT", "If-Match", `"b"`, `"a"`, false, false, 412},
+		{"update weak", "PUT", "If-Match", `W/"a"`, `"a"`, false, false, 412},
+		{"update missing", "PUT", "If-Match", `*`, "", false, false, 412},
+		{"create only existing", "PUT", "If-None-Match", `*`, `"a"`, false, false, 412},
+		{"create only missing", "PUT", "If-None-Match", `*`, "", false, true, 200},
+		{"required missing", "PATCH", "", "", `"a"`, true, false, 428},
+		{"required present", "PATCH", "If-Match", `"a"`, `"a"`, true, true, 200},
+		{"required safe", "GET", "", "", `"a"`, true, true, 200},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "/", nil)
+		if len(test.header) > 0 {
+			r.Header.Set(test.header, test.value)
+		}
+		w := httptest.NewRecorder()
+
+		check := Check
+		if test.require {
+			check = Require
+		}
+
+		if got := check(w, r, test.current); got != test.proceed {
+			t.Errorf("%s: proceed = %v; want %v", test.name, got, test.proceed)
+		}
+		if w.Code != test.code {
+			t.Errorf("%s: code = %d; want %d", test.name, w.Code, test.code)
+		}
+		if w.Code == http.StatusNotModified && w.Header().Get("ETag") != test.current {
+			t.Errorf("%s: ETag = %q; want %q", test.name, w.Header().Get("ETag"), test.current)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+// Package eventbus is the in-process publish/subscribe hub that decouples
+// internal components: producers publish events on dotted topics such as
+// "job.started" and consumers subscribe with exact topics or prefixes.
+package eventbus
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrClosed is returned when publishing on a closed bus.
+var ErrClosed = errors.New("eventbus: bus is closed")
+
+// PublishError lists the deliveries Publish gave up on. Every other
+// matching subscriber still received the event.
+type PublishError struct {
+	Errors []error
+}
+
+func (e *PublishError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+	return "eventbus: " + strconv.Itoa(len(e.Errors)) + " deliveries failed: " + strings.Join(messages, "; ")
+}
+
+// Is lets errors.Is match any of the delivery errors, e.g.
+// context.DeadlineExceeded.
+func (e *PublishError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Topic names a stream of events, e.g. "job.started" or "webhook.failed".
+type Topic string
+
+// Match reports whether topic is selected by pattern. A pattern is either
+// "*" for every topic, an exact topic, or a prefix ending in ".*" which
+// selects every topic below it at any depth.
+func Match(pattern string, topic Topic) bool {
+	switch {
+	case pattern == "*":
+		return true
+	case strings.HasSuffix(pattern, ".*"):
+		return strings.HasPrefix(string(topic), pattern[:len(pattern)-1])
+	}
+	return pattern == string(topic)
+}
+
+// Event is a published message.
+type Event struct {
+	ID     uint64      `json:"id"`
+	Topic  Topic       `json:"topic"`
+	Time   time.Time   `json:"time"`
+	Source string      `json:"source,omitempty"`
+	Data   interface{} `json:"data,omitempty"`
+}
+
+// Handler consumes events.
+type Handler func(event Event)
+
+// Backpressure decides what an asynchronous subscriber does when its
+// buffer is full.
+type Backpressure int
+
+const (
+	// Block makes Publish wait until the subscriber has room.
+	Block Backpressure = iota
+
+	// DropNewest discards the event being published.
+	DropNewest
+
+	// DropOldest discards the oldest buffered event to make room.
+	DropOldest
+)
+
+// Options configures a subscription.
+type Options struct {
+	// Async delivers events from a dedicated goroutine through a buffer
+	// instead of calling the handler inside Publish.
+	Async bool
+
+	// Buffer is the size of the async buffer; it defaults to 64.
+	Buffer int
+
+	Backpressure Backpressure
+}
+
+// Bus dispatches events to subscribers.
+type Bus struct {
+	sequence    uint64 // first for 64-bit atomic alignment
+	mu          sync.RWMutex
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+// New creates an empty bus.
+func New() *Bus {
+	return &Bus{subscribers: make(map[*Subscription]struct{})}
+}
+
+// Subscribe registers handler for every topic matching one of patterns.
+func (b *Bus) Subscribe(handler Handler, opts Options, patterns ...string) *Subscription {
+	if len(patterns) == 0 {
+		patterns = []string{"*"}
+	}
+
+	sub := &Subscription{
+		bus:      b,
+		patterns: patterns,
+		handler:  handler,
+		opts:     opts,
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	if opts.Async {
+		if opts.Buffer <= 0 {
+			sub.opts.Buffer = 64
+		}
+
+		sub.queue = make(chan Event, sub.opts.Buffer)
+		go sub.loop()
+	} else {
+		close(sub.done)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		sub.stop()
+	} else {
+		b.subscribers[sub] = struct{}{}
+	}
+	return sub
+}
+
+// Publish sends data on topic to every matching subscriber. Synchronous
+// handlers run before Publish returns; ctx bounds how long Publish waits
+// on subscribers using the Block policy. A subscriber that times out
+// doesn't keep the event from the others; the failures are returned as a
+// *PublishError.
+func (b *Bus) Publish(ctx context.Context, topic Topic, source string, data interface{}) error {
+	b.mu.RLock()
+	if b.closed {
+		b.mu.RUnlock()
+		return ErrClosed
+	}
+
+	// Deliver from a snapshot so handlers may subscribe, unsubscribe or
+	// publish themselves without deadlocking on the bus lock.
+	subscribers := make([]*Subscription, 0, len(b.subscribers))
+	for sub := range b.subscribers {
+		if sub.matches(topic) {
+			subscribers = append(subscribers, sub)
+		}
+	}
+	b.mu.RUnlock()
+
+	event := Event{
+		ID:     atomic.AddUint64(&b.sequence, 1),
+		Topic:  topic,
+		Time:   time.Now(),
+		Source: source,
+		Data:   data,
+	}
+
+	var failures []error
+	for _, sub := range subscribers {
+		if err := sub.deliver(ctx, event); err != nil {
+			failures = append(failures, err)
+		}
+	}
+
+	if len(failures) > 0 {
+		return &PublishError{Errors: failures}
+	}
+	return nil
+}
+
+// Close unsubscribes everyone and waits until async subscribers have
+// handled their buffered events.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	subscribers := b.subscribers
+	b.subscribers = make(map[*Subscription]struct{})
+	b.closed = true
+	b.mu.Unlock()
+
+	for sub := range subscribers {
+		sub.stop()
+		<-sub.done
+	}
+}
+
+func (b *Bus) remove(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subscribers, sub)
+}
+
+// Subscription is a registered handler.
+type Subscription struct {
+	dropped  uint64 // first for 64-bit atomic alignment
+	bus      *Bus
+	patterns []string
+	handler  Handler
+	opts     Options
+	queue    chan Event
+	quit     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+func (s *Subscription) matches(topic Topic) bool {
+	for _, pattern := range s.patterns {
+		if Match(pattern, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Subscription) stopped() bool {
+	select {
+	case <-s.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// deliver never holds a lock while it waits, so stopping a subscription,
+// or closing the bus, cannot deadlock on a blocked publisher.
+func (s *Subscription) deliver(ctx context.Context, event Event) error {
+	if s.stopped() {
+		return nil
+	}
+
+	if !s.opts.Async {
+		s.handler(event)
+		return nil
+	}
+
+	switch s.opts.Backpressure {
+	case DropNewest:
+		select {
+		case s.queue <- event:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+
+	case DropOldest:
+		for {
+			select {
+			case s.queue <- event:
+				return nil
+			default:
+			}
+
+			select {
+			case <-s.queue:
+				atomic.AddUint64(&s.dropped, 1)
+			default:
+			}
+		}
+
+	default:
+		select {
+		case s.queue <- event:
+		case <-s.quit:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// loop handles queued events until the subscription stops, then drains
+// what is still buffered.
+func (s *Subscription) loop() {
+	defer close(s.done)
+
+	for {
+		select {
+		case event := <-s.queue:
+			s.handler(event)
+
+		case <-s.quit:
+			for {
+				select {
+				case event := <-s.queue:
+					s.handler(event)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *Subscription) stop() {
+	s.once.Do(func() {
+		close(s.quit)
+	})
+}
+
+// Dropped returns how many events were discarded by the backpressure
+// policy.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Unsubscribe stops delivery. Events already buffered for an async
+// subscriber are still handled; Done is closed once they are.
+func (s *Subscription) Unsubscribe() {
+	s.bus.remove(s)
+	s.stop()
+}
+
+// Done is closed once the subscription stopped and drained its buffer.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
@@ -0,0 +1,233 @@
+package eventbus
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		topic   Topic
+		want    bool
+	}{
+		{"*", "job.started", true},
+		{"job.started", "job.started", true},
+		{"job.started", "job.finished", false},
+		{"job.*", "job.started", true},
+		{"job.*", "job.step.failed", true},
+		{"job.*", "jobs.started", false},
+		{"job.*", "job", false},
+	}
+
+	for _, test := range tests {
+		if got := Match(test.pattern, test.topic); got != test.want {
+			t.Errorf("Match(%q, %q) = %v", test.pattern, test.topic, got)
+		}
+	}
+}
+
+func TestDelivery(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		patterns []string
+		topics   []Topic
+		want     int
+	}{
+		{"sync all", Options{}, nil, []Topic{"a", "b.c"}, 2},
+		{"sync filtered", Options{}, []string{"b.*"}, []Topic{"a", "b.c"}, 1},
+		{"async", Options{Async: true}, []string{"a"}, []Topic{"a", "a", "b"}, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bus := New()
+
+			var mu sync.Mutex
+			count := 0
+			sub := bus.Subscribe(func(Event) {
+				mu.Lock()
+				count++
+				mu.Unlock()
+			}, test.opts, test.patterns...)
+
+			for _, topic := range test.topics {
+				if err := bus.Publish(context.Background(), topic, "test", nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			sub.Unsubscribe()
+			<-sub.Done()
+
+			if count != test.want {
+				t.Errorf("handled %d events; want %d", count, test.want)
+			}
+		})
+	}
+}
+
+func TestBackpressure(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Backpressure
+		first   int
+		dropped uint64
+	}{
+		{"drop newest", DropNewest, 1, 3},
+		{"drop oldest", DropOldest, 4, 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bus := New()
+			release := make(chan struct{})
+			started := make(chan struct{}, 1)
+
+			var handled []int
+			sub := bus.Subscribe(func(event Event) {
+				select {
+				case started <- struct{}{}:
+					<-release
+				default:
+				}
+				handled = append(handled, event.Data.(int))
+			}, Options{Async: true, Buffer: 1, Backpressure: test.policy})
+
+			bus.Publish(context.Background(), "t", "", 0)
+			<-started
+
+			for i := 1; i <= 4; i++ {
+				bus.Publish(context.Background(), "t", "", i)
+			}
+			close(release)
+
+			sub.Unsubscribe()
+			<-sub.Done()
+
+			if sub.Dropped() != test.dropped || len(handled) != 2 || handled[1] != test.first {
+				t.Errorf("handled %v, dropped %d", handled, sub.Dropped())
+			}
+		})
+	}
+}
+
+func TestBlockedPublisher(t *testing.T) {
+	bus := New()
+	release := make(chan struct{})
+	blocked := bus.Subscribe(func(Event) { <-release }, Options{Async: true, Buffer: 1}, "*")
+
+	var mu sync.Mutex
+	var others int
+	bus.Subscribe(func(Event) {
+		mu.Lock()
+		others++
+		mu.Unlock()
+	}, Options{}, "*")
+
+	// The first event occupies the handler, the second the buffer.
+	bus.Publish(context.Background(), "t", "", nil)
+	bus.Publish(context.Background(), "t", "", nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := bus.Publish(ctx, "t", "", nil)
+	var failure *PublishError
+	if !errors.As(err, &failure) || len(failure.Errors) != 1 || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Publish = %v", err)
+	}
+
+	mu.Lock()
+	if others != 3 {
+		t.Errorf("synchronous subscriber got %d events; want 3", others)
+	}
+	mu.Unlock()
+
+	// A publisher blocked on a full subscriber must neither keep the
+	// subscription from stopping nor stay blocked once it stopped.
+	published := make(chan error)
+	go func() {
+		published <- bus.Publish(context.Background(), "t", "", nil)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	stopped := make(chan struct{})
+	go func() {
+		blocked.Unsubscribe()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Unsubscribe deadlocked behind a blocked publisher")
+	}
+
+	select {
+	case err := <-published:
+		if err != nil {
+			t.Errorf("blocked Publish = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Publish stayed blocked after Unsubscribe")
+	}
+
+	release <- struct{}{}
+	release <- struct{}{}
+	<-blocked.Done()
+
+	bus.Close()
+	if err := bus.Publish(context.Background(), "t", "", nil); err != ErrClosed {
+		t.Errorf("Publish after Close = %v", err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	bus := New()
+	server := httptest.NewServer(bus.Handler())
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "?topic=job.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	if response.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", response.Header.Get("Content-Type"))
+	}
+
+	go func() {
+		for i := 0; i < 50; i++ {
+			bus.Publish(context.Background(), "webhook.failed", "", nil)
+			bus.Publish(context.Background(), "job.started", "ci", map[string]string{"job": "build"})
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	reader := bufio.NewReader(response.Body)
+	var message []string
+
+	for len(message) < 3 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			message = append(message, line)
+		}
+	}
+
+	if !strings.HasPrefix(message[0], "id: ") || message[1] != "event: job.started" || !strings.Contains(message[2], `"job":"build"`) {
+		t.Errorf("message = %q", message)
+	}
+}
@@ -0,0 +1,80 @@
+package eventbus
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// heartbeat keeps idle SSE connections open through proxies.
+const heartbeat = 15 * time.Second
+
+// Handler streams bus events to HTTP clients as server-sent events, e.g.
+// GET /events?topic=job.*&topic=webhook.failed. Without topic parameters
+// every event is sent. Slow clients lose events rather than slowing down
+// publishers; each SSE message carries the event ID so gaps are visible.
+func (b *Bus) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		var patterns []string
+		for _, value := range r.URL.Query()["topic"] {
+			for _, pattern := range strings.Split(value, ",") {
+				if pattern = strings.TrimSpace(pattern); len(pattern) > 0 {
+					patterns = append(patterns, pattern)
+				}
+			}
+		}
+
+		// The subscription's own buffer absorbs bursts; once it is full
+		// new events are dropped for this client only.
+		events := make(chan Event)
+		sub := b.Subscribe(func(event Event) {
+			select {
+			case events <- event:
+			case <-r.Context().Done():
+			}
+		}, Options{Async: true, Backpressure: DropNewest}, patterns...)
+		defer sub.Unsubscribe()
+
+		header := w.Header()
+		header.Set("Content-Type", "text/event-stream")
+		header.Set("Cache-Control", "no-cache")
+		header.Set("Connection", "keep-alive")
+		header.Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+
+			case <-sub.Done():
+				return
+
+			case <-ticker.C:
+				fmt.Fprint(w, ": ping\n\n")
+				flusher.Flush()
+
+			case event := <-events:
+				raw, err := json.Marshal(event)
+				if err != nil {
+					continue
+				}
+
+				fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Topic, raw)
+				flusher.Flush()
+			}
+		}
+	})
+}
@@ -0,0 +1,63 @@
+package executor
+
+import (
+	"time"
+
+	"devops.io/cloud/config"
+)
+
+// Configure registers the defaults and validation rules of the executor
+// keys:
+//
+//	executor.timeout     default step timeout, "0s" for none
+//	executor.grace       delay between SIGTERM and SIGKILL on timeout
+//	executor.max_output  default cap on each output stream, in bytes
+//	executor.wait_delay  how long output is read after a step exited
+func Configure(cfg *config.Config) {
+	cfg.SetDefault("executor.timeout", "0s")
+	cfg.SetDefault("executor.grace", "5s")
+	cfg.SetDefault("executor.max_output", 0)
+	cfg.SetDefault("executor.wait_delay", "1s")
+
+	cfg.AddRule(
+		config.IsDuration("executor.timeout"),
+		config.IsDuration("executor.grace"),
+		config.IsInt64("executor.max_output", 0, 1<<40),
+		config.IsDuration("executor.wait_delay"),
+	)
+}
+
+// FromConfig builds a Local executor from the keys registered by
+// Configure.
+func FromConfig(cfg *config.Config) (*Local, error) {
+	timeout, err := cfg.Duration("executor.timeout")
+	if err != nil {
+		return nil, err
+	}
+
+	grace, err := cfg.Duration("executor.grace")
+	if err != nil {
+		return nil, err
+	}
+
+	maxOutput, err := cfg.Int64("executor.max_output")
+	if err != nil {
+		return nil, err
+	}
+
+	waitDelay, err := cfg.Duration("executor.wait_delay")
+	if err != nil {
+		return nil, err
+	}
+
+	if grace <= 0 {
+		grace = 5 * time.Second
+	}
+
+	return &Local{
+		Timeout:   timeout,
+		Grace:     grace,
+		MaxOutput: maxOutput,
+		WaitDelay: waitDelay,
+	}, nil
+}
@@ -0,0 +1,98 @@
+// Package executor runs task steps. Local, the default backend, executes
+// commands on the server host with resource limits, optional sandboxing,
+// output caps and kill-on-timeout semantics.
+package executor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"devops.io/cloud/redact"
+)
+
+var (
+	// ErrNoCommand is returned when a step has nothing to run.
+	ErrNoCommand = errors.New("executor: step has no command")
+
+	// ErrTimeout is returned when a step was killed for exceeding its
+	// timeout.
+	ErrTimeout = errors.New("executor: step timed out")
+
+	// ErrSandboxUnsupported is returned when sandboxing is requested on a
+	// platform that cannot provide it.
+	ErrSandboxUnsupported = errors.New("executor: sandbox not supported on this platform")
+)
+
+// Limits are per-process resource limits applied through ulimit. Zero
+// values leave the corresponding limit untouched.
+type Limits struct {
+	CPUSeconds  int
+	MemoryBytes int64
+	OpenFiles   int
+}
+
+func (l Limits) empty() bool {
+	return l.CPUSeconds == 0 && l.MemoryBytes == 0 && l.OpenFiles == 0
+}
+
+// Sandbox isolates a step from the host. It relies on Linux namespaces and
+// usually requires the server to run with CAP_SYS_ADMIN.
+type Sandbox struct {
+	// Root, if set, is the chroot directory of the step. It must contain
+	// /bin/sh when Limits are used.
+	Root string
+
+	// Unshare puts the step in new mount, PID, IPC and UTS namespaces.
+	Unshare bool
+
+	// NoNetwork additionally puts the step in an empty network namespace.
+	NoNetwork bool
+}
+
+func (s Sandbox) enabled() bool {
+	return len(s.Root) > 0 || s.Unshare || s.NoNetwork
+}
+
+// Step is a single command to execute.
+type Step struct {
+	// Command is the program and its arguments.
+	Command []string
+
+	Dir string
+
+	// Env is appended to the server environment unless CleanEnv is set.
+	Env      []string
+	CleanEnv bool
+
+	// Timeout bounds the run time; zero falls back to the executor default.
+	Timeout time.Duration
+
+	// Stdout and Stderr receive the output; nil discards it.
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// MaxOutput caps the bytes kept from each stream; zero means no cap.
+	MaxOutput int64
+
+	// Redactor, if set, masks secrets in the output before it reaches
+	// Stdout and Stderr.
+	Redactor *redact.Redactor
+
+	Limits  Limits
+	Sandbox Sandbox
+}
+
+// Result summarizes a finished step.
+type Result struct {
+	ExitCode  int
+	Duration  time.Duration
+	TimedOut  bool
+	Truncated bool
+}
+
+// Executor is implemented by every step backend.
+type Executor interface {
+	Run(ctx context.Context, step Step) (Result, error)
+}
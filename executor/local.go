@@ -0,0 +1,347 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Local runs steps as child processes of the server.
+type Local struct {
+	// Timeout applies to steps that don't set their own; zero means none.
+	Timeout time.Duration
+
+	// Grace is how long a timed out step gets between SIGTERM and
+	// SIGKILL; it defaults to five seconds.
+	Grace time.Duration
+
+	// MaxOutput applies to steps that don't set their own; zero means no
+	// cap.
+	MaxOutput int64
+
+	// WaitDelay bounds how long output is still read once the step has
+	// exited, for background processes that inherited its stdout or
+	// stderr; it defaults to one second. Without it Run would wait for
+	// them to exit too.
+	WaitDelay time.Duration
+}
+
+// Run implements Executor. A non-zero exit status is reported through
+// Result.ExitCode, not as an error.
+func (l *Local) Run(ctx context.Context, step Step) (Result, error) {
+	if len(step.Command) == 0 {
+		return Result{}, ErrNoCommand
+	}
+
+	args := step.Command
+	if !step.Limits.empty() {
+		args = withLimits(step.Limits, args)
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = step.Dir
+
+	if step.CleanEnv {
+		cmd.Env = append([]string{}, step.Env...)
+	} else {
+		cmd.Env = append(os.Environ(), step.Env...)
+	}
+
+	if err := configure(cmd, step.Sandbox); err != nil {
+		return Result{}, err
+	}
+
+	stdout := l.output(step, step.Stdout)
+	stderr := l.output(step, step.Stderr)
+
+	// The child writes to pipes of our own rather than ones os/exec
+	// creates, so Wait returns when the child exits instead of when every
+	// process holding the pipes does; copy drains them afterwards.
+	pipes, err := newPipes(cmd)
+	if err != nil {
+		return Result{}, err
+	}
+
+	timeout := step.Timeout
+	if timeout == 0 {
+		timeout = l.Timeout
+	}
+
+	// Only the executor's own timer reports a timeout; a cancelled or
+	// expired parent context is returned as is.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var timedOut int32
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			cancel()
+		})
+		defer timer.Stop()
+	}
+
+	started := time.Now()
+	if err := cmd.Start(); err != nil {
+		pipes.close()
+		return Result{}, err
+	}
+
+	copied := pipes.copy(stdout, stderr)
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-exited:
+	case <-ctx.Done():
+		err = l.stop(cmd, exited)
+	}
+
+	l.drain(pipes, copied)
+
+	truncated := stdout.flush()
+	truncated = stderr.flush() || truncated
+
+	result := Result{
+		ExitCode:  -1,
+		Duration:  time.Since(started),
+		Truncated: truncated,
+	}
+
+	if cmd.ProcessState != nil {
+		result.ExitCode = cmd.ProcessState.ExitCode()
+	}
+
+	if atomic.LoadInt32(&timedOut) == 1 {
+		result.TimedOut = true
+		return result, ErrTimeout
+	} else if err := ctx.Err(); err != nil {
+		return result, err
+	}
+
+	if _, ok := err.(*exec.ExitError); ok {
+		err = nil
+	}
+	return result, err
+}
+
+// stop asks the step's process group to terminate and kills it if it is
+// still alive after the grace period.
+func (l *Local) stop(cmd *exec.Cmd, exited chan error) error {
+	grace := l.Grace
+	if grace <= 0 {
+		grace = 5 * time.Second
+	}
+
+	terminate(cmd)
+
+	select {
+	case err := <-exited:
+		return err
+	case <-time.After(grace):
+		kill(cmd)
+		return <-exited
+	}
+}
+
+// drain waits for the output copies to finish, giving up after WaitDelay
+// when background processes keep the pipes open.
+func (l *Local) drain(pipes *pipes, copied <-chan struct{}) {
+	delay := l.WaitDelay
+	if delay <= 0 {
+		delay = time.Second
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-copied:
+	case <-timer.C:
+		pipes.close()
+		<-copied
+	}
+}
+
+// pipes carries the output of a child process.
+type pipes struct {
+	stdout, stderr *os.File
+
+	// writers are the child's ends, closed in the parent once the child
+	// started so EOF is seen when every process holding them exited.
+	writers []*os.File
+}
+
+// newPipes connects the output of cmd to new pipes.
+func newPipes(cmd *exec.Cmd) (*pipes, error) {
+	stdout, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stderr, stderrWriter, err := os.Pipe()
+	if err != nil {
+		stdout.Close()
+		stdoutWriter.Close()
+		return nil, err
+	}
+
+	cmd.Stdout = stdoutWriter
+	cmd.Stderr = stderrWriter
+
+	return &pipes{
+		stdout:  stdout,
+		stderr:  stderr,
+		writers: []*os.File{stdoutWriter, stderrWriter},
+	}, nil
+}
+
+// copy reads both pipes into their writers. The returned channel is
+// closed once both reached EOF or were closed.
+func (p *pipes) copy(stdout, stderr io.Writer) <-chan struct{} {
+	for _, writer := range p.writers {
+		writer.Close()
+	}
+
+	done := make(chan struct{})
+	var group sync.WaitGroup
+
+	group.Add(2)
+	go func() {
+		defer group.Done()
+		io.Copy(stdout, p.stdout)
+	}()
+	go func() {
+		defer group.Done()
+		io.Copy(stderr, p.stderr)
+	}()
+
+	go func() {
+		group.Wait()
+		close(done)
+	}()
+	return done
+}
+
+func (p *pipes) close() {
+	for _, file := range append(p.writers, p.stdout, p.stderr) {
+		file.Close()
+	}
+}
+
+// withLimits wraps args in a shell applying the limits before exec'ing the
+// real command, since os/exec cannot set rlimits on the child directly.
+func withLimits(limits Limits, args []string) []string {
+	var script []string
+
+	if limits.CPUSeconds > 0 {
+		script = append(script, fmt.Sprintf("ulimit -t %d", limits.CPUSeconds))
+	}
+	if limits.MemoryBytes > 0 {
+		script = append(script, fmt.Sprintf("ulimit -v %d", (limits.MemoryBytes+1023)/1024))
+	}
+	if limits.OpenFiles > 0 {
+		script = append(script, fmt.Sprintf("ulimit -n %d", limits.OpenFiles))
+	}
+
+	script = append(script, `exec "$@"`)
+	return append([]string{"/bin/sh", "-c", strings.Join(script, " && "), "sh"}, args...)
+}
+
+func (l *Local) output(step Step, out io.Writer) *outputWriter {
+	if out == nil {
+		out = io.Discard
+	}
+
+	limit := step.MaxOutput
+	if limit == 0 {
+		limit = l.MaxOutput
+	}
+
+	// The cap applies to redacted output, so cutting the stream can never
+	// leave part of a secret unmasked.
+	capped := &capWriter{out: out, limit: limit}
+	writer := &outputWriter{Writer: capped, capped: capped}
+
+	if step.Redactor != nil {
+		redacted := step.Redactor.NewWriter(capped)
+		writer.Writer = redacted
+		writer.redacted = redacted
+	}
+	return writer
+}
+
+// outputWriter is one output stream of a step: optionally redacted, then
+// capped.
+type outputWriter struct {
+	io.Writer
+	capped   *capWriter
+	redacted io.Closer
+}
+
+// flush finishes the stream and reports whether it was truncated.
+func (w *outputWriter) flush() bool {
+	if w.redacted != nil {
+		w.redacted.Close()
+	}
+	return w.capped.flush()
+}
+
+// capWriter keeps at most limit bytes of a stream and silently drops the
+// rest, so a chatty step cannot exhaust memory or log storage.
+type capWriter struct {
+	mu        sync.Mutex
+	out       io.Writer
+	limit     int64
+	written   int64
+	truncated bool
+}
+
+const truncatedMarker = "\n[output truncated]\n"
+
+func (w *capWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	size := len(data)
+	if w.limit > 0 {
+		left := w.limit - w.written
+		if left <= 0 {
+			w.truncated = true
+			return size, nil
+		}
+
+		if int64(len(data)) > left {
+			data = data[:left]
+			w.truncated = true
+		}
+	}
+
+	written, err := w.out.Write(data)
+	w.written += int64(written)
+
+	if err != nil {
+		return written, err
+	}
+	return size, nil
+}
+
+// flush appends the truncation marker when needed and reports whether the
+// stream was truncated.
+func (w *capWriter) flush() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.truncated {
+		w.out.Write([]byte(truncatedMarker))
+	}
+	return w.truncated
+}
@@ -0,0 +1,188 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"devops.io/cloud/config"
+	"devops.io/cloud/redact"
+)
+
+// buffer is a bytes.Buffer safe for the concurrent writes of a step.
+type buffer struct {
+	mu     sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *buffer) Write(data []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buffer.Write(data)
+}
+
+func (b *buffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buffer.String()
+}
+
+func TestRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs /bin/sh")
+	}
+
+	tests := []struct {
+		name      string
+		local     Local
+		step      Step
+		exitCode  int
+		err       error
+		timedOut  bool
+		stdout    string
+		truncated bool
+		within    time.Duration
+	}{
+		{
+			name:   "output",
+			step:   Step{Command: []string{"/bin/sh", "-c", "echo hello; echo oops >&2"}},
+			stdout: "hello\n",
+		},
+		{
+			name:     "exit code",
+			step:     Step{Command: []string{"/bin/sh", "-c", "exit 3"}},
+			exitCode: 3,
+		},
+		{
+			name:     "timeout",
+			local:    Local{Grace: 100 * time.Millisecond},
+			step:     Step{Command: []string{"sleep", "10"}, Timeout: 50 * time.Millisecond},
+			exitCode: -1,
+			err:      ErrTimeout,
+			timedOut: true,
+			within:   2 * time.Second,
+		},
+		{
+			name:      "output capped",
+			step:      Step{Command: []string{"/bin/sh", "-c", "echo 0123456789"}, MaxOutput: 4},
+			stdout:    "0123" + truncatedMarker,
+			truncated: true,
+		},
+		{
+			name: "cap applies after redaction",
+			step: Step{
+				Command:   []string{"/bin/sh", "-c", "echo ab-s3cr3t-value-cd"},
+				MaxOutput: 8,
+				Redactor:  redact.New("s3cr3t-value"),
+			},
+			stdout:    "ab-***-c" + truncatedMarker,
+			truncated: true,
+		},
+		{
+			name:   "background process keeps pipes open",
+			local:  Local{WaitDelay: 100 * time.Millisecond},
+			step:   Step{Command: []string{"/bin/sh", "-c", "sleep 10 & echo started"}},
+			stdout: "started\n",
+			within: 2 * time.Second,
+		},
+		{
+			name: "no command",
+			step: Step{},
+			err:  ErrNoCommand,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stdout := &buffer{}
+			test.step.Stdout = stdout
+
+			started := time.Now()
+			result, err := test.local.Run(context.Background(), test.step)
+
+			if !errors.Is(err, test.err) {
+				t.Fatalf("Run = %v; want %v", err, test.err)
+			}
+			if err == ErrNoCommand {
+				return
+			}
+
+			if result.ExitCode != test.exitCode || result.TimedOut != test.timedOut || result.Truncated != test.truncated {
+				t.Errorf("result = %+v", result)
+			}
+			if got := stdout.String(); got != test.stdout {
+				t.Errorf("stdout = %q; want %q", got, test.stdout)
+			}
+			if test.within > 0 && time.Since(started) > test.within {
+				t.Errorf("Run took %v", time.Since(started))
+			}
+		})
+	}
+}
+
+func TestCancelIsNotTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  func() (context.Context, context.CancelFunc)
+		want error
+	}{
+		{"cancelled", func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(50*time.Millisecond, cancel)
+			return ctx, cancel
+		}, context.Canceled},
+		{"parent deadline", func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 50*time.Millisecond)
+		}, context.DeadlineExceeded},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := test.ctx()
+			defer cancel()
+
+			local := &Local{Timeout: time.Minute, Grace: 100 * time.Millisecond}
+			result, err := local.Run(ctx, Step{Command: []string{"sleep", "10"}})
+
+			if err != test.want || result.TimedOut {
+				t.Errorf("Run = %+v, %v; want %v without TimedOut", result, err, test.want)
+			}
+		})
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	cfg := config.New("app")
+	Configure(cfg)
+	cfg.LoadEnv([]string{"APP_EXECUTOR_TIMEOUT=1m", "APP_EXECUTOR_MAX_OUTPUT=1024"})
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	local, err := FromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Local{Timeout: time.Minute, Grace: 5 * time.Second, MaxOutput: 1024, WaitDelay: time.Second}
+	if *local != want {
+		t.Errorf("FromConfig = %+v; want %+v", *local, want)
+	}
+}
+
+func TestWithLimits(t *testing.T) {
+	args := withLimits(Limits{CPUSeconds: 10, MemoryBytes: 1 << 20, OpenFiles: 64}, []string{"make", "test"})
+	script := "ulimit -t 10 && ulimit -v 1024 && ulimit -n 64 && exec \"$@\""
+
+	if got := strings.Join(args, "|"); got != "/bin/sh|-c|"+script+"|sh|make|test" {
+		t.Errorf("withLimits = %q", args)
+	}
+}
@@ -0,0 +1,42 @@
+//go:build linux
+// +build linux
+
+package executor
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+func configure(cmd *exec.Cmd, sandbox Sandbox) error {
+	attr := &syscall.SysProcAttr{
+		// The step gets its own process group so a timeout kills every
+		// process it spawned, not only the direct child.
+		Setpgid:   true,
+		Pdeathsig: syscall.SIGKILL,
+	}
+
+	if len(sandbox.Root) > 0 {
+		attr.Chroot = sandbox.Root
+	}
+
+	if sandbox.Unshare {
+		attr.Cloneflags |= syscall.CLONE_NEWNS | syscall.CLONE_NEWPID |
+			syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+	}
+
+	if sandbox.NoNetwork {
+		attr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+
+	cmd.SysProcAttr = attr
+	return nil
+}
+
+func terminate(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+}
+
+func kill(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+// +build !linux
+
+package executor
+
+import (
+	"os/exec"
+)
+
+func configure(cmd *exec.Cmd, sandbox Sandbox) error {
+	if sandbox.enabled() {
+		return ErrSandboxUnsupported
+	}
+	return nil
+}
+
+func terminate(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
+
+func kill(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
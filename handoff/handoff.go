@@ -0,0 +1,343 @@
+// Package handoff restarts a server binary without dropping connections.
+// The running process starts its replacement with its listening sockets
+// passed as inherited file descriptors, waits for the new process to
+// report ready, then drains and exits while the new one keeps accepting
+// on the very same sockets.
+//
+// Scheduler leadership moves along: only the process whose Leader channel
+// is closed may run scheduled automation. Once the new process is ready,
+// Upgrade runs the OnRelease hooks, which stop the old scheduler, and
+// only then passes leadership on, so no schedule fires twice or is
+// skipped during the switch. This covers the processes of one host;
+// electing a leader among several hosts needs a shared lock on top.
+//
+// A typical upgrade on SIGHUP:
+//
+//	upgrader := handoff.New()
+//	listener, _ := upgrader.Listen("tcp", ":8080")
+//	go server.Serve(listener)
+//
+//	upgrader.OnRelease(scheduler.Stop)
+//	go func() {
+//		<-upgrader.Leader()
+//		scheduler.Start()
+//	}()
+//	upgrader.Ready()
+//
+//	<-hangup
+//	if _, err := upgrader.Upgrade(30 * time.Second); err == nil {
+//		server.Shutdown(ctx)
+//	}
+package handoff
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	envListeners = "AUTOMATION_LISTENERS"
+	envReady     = "AUTOMATION_READY_FD"
+	envLeader    = "AUTOMATION_LEADER_FD"
+)
+
+var (
+	// ErrNotReady is returned by Upgrade when the new process exits or
+	// times out before calling Ready.
+	ErrNotReady = errors.New("handoff: new process did not become ready")
+
+	// ErrUnsupported is returned for listeners whose socket can't be
+	// passed on, e.g. on Windows.
+	ErrUnsupported = errors.New("handoff: listener can't be inherited")
+)
+
+type filer interface {
+	File() (*os.File, error)
+}
+
+// Upgrader owns the listeners and the scheduler leadership that survive
+// a restart.
+type Upgrader struct {
+	mu        sync.Mutex
+	inherited map[string]*os.File
+	active    map[string]net.Listener
+	leader    chan struct{}
+	release   []func()
+}
+
+// New creates an upgrader, picking up the sockets passed by a parent
+// process, if any. A process started without a parent leads at once; one
+// started by Upgrade leads once its parent released leadership or
+// exited.
+func New() *Upgrader {
+	u := &Upgrader{
+		inherited: make(map[string]*os.File),
+		active:    make(map[string]net.Listener),
+		leader:    make(chan struct{}),
+	}
+
+	if fd, err := strconv.Atoi(os.Getenv(envLeader)); err == nil {
+		go u.await(os.NewFile(uintptr(fd), "leader"))
+	} else {
+		close(u.leader)
+	}
+	os.Unsetenv(envLeader)
+
+	for _, entry := range strings.Split(os.Getenv(envListeners), ",") {
+		separator := strings.LastIndexByte(entry, '=')
+		if separator < 0 {
+			continue
+		}
+
+		fd, err := strconv.Atoi(entry[separator+1:])
+		if err != nil {
+			continue
+		}
+		u.inherited[entry[:separator]] = os.NewFile(uintptr(fd), entry[:separator])
+	}
+
+	os.Unsetenv(envListeners)
+	return u
+}
+
+// await takes leadership once the parent writes to pipe, or when it
+// exits and the pipe breaks: either way it no longer schedules anything.
+func (u *Upgrader) await(pipe *os.File) {
+	defer pipe.Close()
+
+	buffer := make([]byte, 1)
+	pipe.Read(buffer)
+	close(u.leader)
+}
+
+// Leader returns a channel closed once this process leads the scheduler.
+func (u *Upgrader) Leader() <-chan struct{} {
+	return u.leader
+}
+
+// OnRelease registers fn to run when Upgrade hands leadership over. It
+// must stop scheduling and return once no scheduled run is starting.
+func (u *Upgrader) OnRelease(fn func()) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.release = append(u.release, fn)
+}
+
+// Inherited reports whether the process was started by Upgrade.
+func (u *Upgrader) Inherited() bool {
+	return len(os.Getenv(envReady)) > 0
+}
+
+// Listen returns the listener the parent passed for network and addr,
+// or a new one. The same arguments must be used across versions.
+func (u *Upgrader) Listen(network, addr string) (net.Listener, error) {
+	key := network + "/" + addr
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if file, ok := u.inherited[key]; ok {
+		delete(u.inherited, key)
+
+		// FileListener duplicates the descriptor, so the original copy
+		// is closed either way.
+		listener, err := net.FileListener(file)
+		file.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("handoff: inherited %s: %w", key, err)
+		}
+
+		u.active[key] = listener
+		return listener, nil
+	}
+
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	u.active[key] = listener
+	return listener, nil
+}
+
+// Ready tells the parent, if any, that this process serves requests, so
+// it can start draining. It also closes inherited sockets that this
+// version no longer listens on.
+func (u *Upgrader) Ready() error {
+	u.mu.Lock()
+	for key, file := range u.inherited {
+		file.Close()
+		delete(u.inherited, key)
+	}
+	u.mu.Unlock()
+
+	value := os.Getenv(envReady)
+	if len(value) == 0 {
+		return nil
+	}
+	os.Unsetenv(envReady)
+
+	fd, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("handoff: malformed %s", envReady)
+	}
+
+	pipe := os.NewFile(uintptr(fd), "ready")
+	defer pipe.Close()
+
+	_, err = pipe.Write([]byte{1})
+	return err
+}
+
+// Upgrade starts the current executable again with the same arguments
+// and hands it every active listener. Once the new process called Ready,
+// the OnRelease hooks run and scheduler leadership passes to it; Upgrade
+// then returns and the caller drains and exits. On failure the new
+// process is killed and the current one keeps serving and leading.
+func (u *Upgrader) Upgrade(timeout time.Duration) (*os.Process, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	files, specs, err := u.files()
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+
+	if err != nil {
+		return nil, err
+	}
+
+	ready, signal, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer ready.Close()
+
+	// The leadership pipe works the other way round: the new process
+	// waits on it until we write, or exit.
+	await, handover, err := os.Pipe()
+	if err != nil {
+		signal.Close()
+		return nil, err
+	}
+	defer handover.Close()
+
+	// Inherited descriptors are numbered from 3, in ExtraFiles order.
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(files[:len(files):len(files)], signal, await)
+	cmd.Env = append(environment(),
+		envListeners+"="+strings.Join(specs, ","),
+		envReady+"="+strconv.Itoa(3+len(files)),
+		envLeader+"="+strconv.Itoa(4+len(files)),
+	)
+
+	err = cmd.Start()
+
+	// Drop our copies of the child's ends, so the read sees EOF if the
+	// child dies before writing.
+	signal.Close()
+	await.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		buffer := make([]byte, 1)
+		if _, err := io.ReadFull(ready, buffer); err != nil {
+			result <- ErrNotReady
+			return
+		}
+		result <- nil
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err = <-result:
+	case <-timer.C:
+		err = ErrNotReady
+	}
+
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, err
+	}
+
+	u.resign()
+	handover.Write([]byte{1})
+
+	// The new process outlives this one; reap it in the background in
+	// case we are still around when it exits.
+	go cmd.Wait()
+	return cmd.Process, nil
+}
+
+// resign runs the OnRelease hooks. They run once: after a successful
+// Upgrade this process no longer leads.
+func (u *Upgrader) resign() {
+	u.mu.Lock()
+	hooks := u.release
+	u.release = nil
+	u.mu.Unlock()
+
+	for _, hook := range hooks {
+		hook()
+	}
+}
+
+// files duplicates the descriptor of every active listener.
+func (u *Upgrader) files() ([]*os.File, []string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var files []*os.File
+	var specs []string
+
+	for key, listener := range u.active {
+		source, ok := listener.(filer)
+		if !ok {
+			return files, nil, fmt.Errorf("%w: %s", ErrUnsupported, key)
+		}
+
+		file, err := source.File()
+		if err != nil {
+			return files, nil, fmt.Errorf("%w: %s: %v", ErrUnsupported, key, err)
+		}
+
+		files = append(files, file)
+		specs = append(specs, key+"="+strconv.Itoa(2+len(files)))
+	}
+	return files, specs, nil
+}
+
+// environment returns os.Environ without the handoff variables.
+func environment() []string {
+	var result []string
+	for _, entry := range os.Environ() {
+		if strings.HasPrefix(entry, envListeners+"=") || strings.HasPrefix(entry, envReady+"=") ||
+			strings.HasPrefix(entry, envLeader+"=") {
+			continue
+		}
+		result = append(result, entry)
+	}
+	return result
+}
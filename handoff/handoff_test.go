@@ -0,0 +1,132 @@
+package handoff
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// envChild makes the test binary act as the upgraded process.
+const envChild = "HANDOFF_TEST_CHILD"
+
+func TestMain(m *testing.M) {
+	if len(os.Getenv(envChild)) > 0 {
+		os.Exit(child())
+	}
+	os.Exit(m.Run())
+}
+
+// child serves one connection on the inherited listener and answers
+// whether leadership arrived. It listens with the parent's arguments,
+// which select the inherited socket.
+func child() int {
+	u := New()
+	listener, err := u.Listen("tcp", os.Getenv(envChild))
+	if err != nil {
+		return 1
+	}
+	if err := u.Ready(); err != nil {
+		return 1
+	}
+
+	conn, err := listener.Accept()
+	if err != nil {
+		return 1
+	}
+	defer conn.Close()
+
+	select {
+	case <-u.Leader():
+		fmt.Fprintln(conn, "leader")
+	case <-time.After(5 * time.Second):
+		fmt.Fprintln(conn, "follower")
+	}
+	return 0
+}
+
+func TestLeaderWithoutParent(t *testing.T) {
+	u := New()
+
+	select {
+	case <-u.Leader():
+	default:
+		t.Fatal("process without a parent does not lead")
+	}
+
+	if u.Inherited() {
+		t.Error("Inherited = true without a parent")
+	}
+}
+
+func TestEnvironment(t *testing.T) {
+	tests := []struct {
+		key   string
+		value string
+		kept  bool
+	}{
+		{envListeners, "tcp:127.0.0.1:80=3", false},
+		{envReady, "4", false},
+		{envLeader, "5", false},
+		{"HANDOFF_TEST_OTHER", "1", true},
+	}
+
+	for _, test := range tests {
+		os.Setenv(test.key, test.value)
+
+		kept := false
+		for _, entry := range environment() {
+			if entry == test.key+"="+test.value {
+				kept = true
+			}
+		}
+		os.Unsetenv(test.key)
+
+		if kept != test.kept {
+			t.Errorf("%s: kept = %v; want %v", test.key, kept, test.kept)
+		}
+	}
+}
+
+func TestUpgradeHandsOverLeadership(t *testing.T) {
+	u := New()
+	listener, err := u.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+
+	released := 0
+	u.OnRelease(func() { released++ })
+
+	os.Setenv(envChild, "127.0.0.1:0")
+	_, err = u.Upgrade(10 * time.Second)
+	os.Unsetenv(envChild)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if released != 1 {
+		t.Errorf("release hooks ran %d times; want 1", released)
+	}
+
+	// Only the child accepts from now on.
+	listener.Close()
+
+	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "leader\n" {
+		t.Errorf("child answered %q; want %q", line, "leader\n")
+	}
+}
@@ -0,0 +1,117 @@
+package httpclient
+
+import (
+	"sync"
+	"time"
+)
+
+// State is the state of a host's circuit breaker.
+type State int
+
+const (
+	// Closed lets every request through.
+	Closed State = iota
+
+	// Open rejects requests until the cooldown elapses.
+	Open
+
+	// HalfOpen lets a single probe through to test the host.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// Stats counts the outbound traffic to one host.
+type Stats struct {
+	Requests int64
+	Retries  int64
+	Failures int64
+	Rejected int64
+	State    State
+}
+
+type breaker struct {
+	mu       sync.Mutex
+	state    State
+	failures int
+	until    time.Time
+	probing  bool
+	stats    Stats
+}
+
+// allow reports whether a request may go out now.
+func (b *breaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open && !now.Before(b.until) {
+		b.state = HalfOpen
+	}
+
+	switch {
+	case b.state == Open, b.state == HalfOpen && b.probing:
+		b.stats.Rejected++
+		return false
+
+	case b.state == HalfOpen:
+		b.probing = true
+	}
+
+	b.stats.Requests++
+	return true
+}
+
+// record updates the breaker with the outcome of an allowed request.
+func (b *breaker) record(ok bool, threshold int, cooldown time.Duration, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if ok {
+		b.state = Closed
+		b.failures = 0
+		return
+	}
+
+	b.stats.Failures++
+	b.failures++
+
+	if b.state == HalfOpen || b.failures >= threshold {
+		b.state = Open
+		b.until = now.Add(cooldown)
+	}
+}
+
+// abandon ends an allowed request without an outcome, freeing the probe
+// slot of a half-open breaker.
+func (b *breaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+func (b *breaker) retried() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.stats.Retries++
+}
+
+func (b *breaker) snapshot() Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := b.stats
+	stats.State = b.state
+	return stats
+}
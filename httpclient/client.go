@@ -0,0 +1,276 @@
+// Package httpclient provides the HTTP client used for outbound calls
+// such as webhooks and notifiers. It adds per-attempt timeouts, retries
+// with backoff on transient failures and a circuit breaker per host, and
+// counts traffic so the calls can be observed.
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting a host whose breaker is
+// open.
+var ErrCircuitOpen = errors.New("httpclient: circuit open")
+
+// Options configures a Transport.
+type Options struct {
+	// Transport performs the actual requests; it defaults to
+	// http.DefaultTransport.
+	Transport http.RoundTripper
+
+	// Timeout bounds each attempt, including reading the response body;
+	// it defaults to 10 seconds.
+	Timeout time.Duration
+
+	// MaxAttempts per request, the first one included; it defaults to 3.
+	MaxAttempts int
+
+	// Backoff is the delay before the first retry, doubled on every
+	// further attempt up to MaxBackoff. Defaults are 200ms and 5s.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Methods lists the methods that may be retried; it defaults to the
+	// idempotent ones. Requests carrying an Idempotency-Key header are
+	// retried whatever their method.
+	Methods []string
+
+	// Threshold is how many consecutive failures open a host's breaker;
+	// it defaults to 5.
+	Threshold int
+
+	// Cooldown is how long an open breaker rejects requests before a
+	// probe is let through; it defaults to 30 seconds.
+	Cooldown time.Duration
+}
+
+// Transport is an http.RoundTripper adding retries and circuit breaking.
+type Transport struct {
+	opts     Options
+	methods  map[string]bool
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+// NewTransport creates a Transport.
+func NewTransport(opts Options) *Transport {
+	if opts.Transport == nil {
+		opts.Transport = http.DefaultTransport
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 3
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = 200 * time.Millisecond
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 5 * time.Second
+	}
+	if len(opts.Methods) == 0 {
+		opts.Methods = []string{
+			http.MethodGet,
+			http.MethodHead,
+			http.MethodOptions,
+			http.MethodPut,
+			http.MethodDelete,
+		}
+	}
+	if opts.Threshold <= 0 {
+		opts.Threshold = 5
+	}
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = 30 * time.Second
+	}
+
+	methods := make(map[string]bool, len(opts.Methods))
+	for _, method := range opts.Methods {
+		methods[method] = true
+	}
+
+	return &Transport{
+		opts:     opts,
+		methods:  methods,
+		breakers: make(map[string]*breaker),
+	}
+}
+
+// New returns an http.Client using a new Transport.
+func New(opts Options) *http.Client {
+	return &http.Client{Transport: NewTransport(opts)}
+}
+
+// Stats returns the counters of every host contacted so far.
+func (t *Transport) Stats() map[string]Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := make(map[string]Stats, len(t.breakers))
+	for host, b := range t.breakers {
+		stats[host] = b.snapshot()
+	}
+	return stats
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(request *http.Request) (*http.Response, error) {
+	b := t.breaker(request.URL.Host)
+	attempts := 1
+
+	if t.retryable(request) {
+		attempts = t.opts.MaxAttempts
+	}
+
+	for attempt := 1; ; attempt++ {
+		if !b.allow(time.Now()) {
+			return nil, ErrCircuitOpen
+		}
+
+		response, err := t.attempt(request, attempt)
+		if err != nil && request.Context().Err() != nil {
+			// The caller gave up, which says nothing about the host.
+			b.abandon()
+			return nil, err
+		}
+		b.record(!failed(response, err), t.opts.Threshold, t.opts.Cooldown, time.Now())
+
+		if attempt >= attempts || !transient(request.Context(), response, err) {
+			return response, err
+		}
+
+		delay := t.backoff(attempt, response)
+		if response != nil {
+			io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+			response.Body.Close()
+		}
+
+		select {
+		case <-time.After(delay):
+			b.retried()
+		case <-request.Context().Done():
+			return nil, request.Context().Err()
+		}
+	}
+}
+
+func (t *Transport) breaker(host string) *breaker {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b, ok := t.breakers[host]
+	if !ok {
+		b = &breaker{}
+		t.breakers[host] = b
+	}
+	return b
+}
+
+// retryable reports whether request may safely be sent more than once.
+func (t *Transport) retryable(request *http.Request) bool {
+	if request.Body != nil && request.Body != http.NoBody && request.GetBody == nil {
+		return false
+	}
+	return t.methods[request.Method] || len(request.Header.Get("Idempotency-Key")) > 0
+}
+
+// attempt sends one copy of request bounded by the per-attempt timeout.
+func (t *Transport) attempt(request *http.Request, attempt int) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(request.Context(), t.opts.Timeout)
+	outgoing := request.Clone(ctx)
+
+	if attempt > 1 && request.GetBody != nil {
+		body, err := request.GetBody()
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		outgoing.Body = body
+	}
+
+	response, err := t.opts.Transport.RoundTrip(outgoing)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// The timeout keeps running while the caller reads the body.
+	response.Body = &cancelBody{ReadCloser: response.Body, cancel: cancel}
+	return response, nil
+}
+
+func (t *Transport) backoff(attempt int, response *http.Response) time.Duration {
+	if response != nil {
+		if delay, ok := retryAfter(response.Header.Get("Retry-After")); ok {
+			if delay > t.opts.MaxBackoff {
+				delay = t.opts.MaxBackoff
+			}
+			return delay
+		}
+	}
+
+	delay := t.opts.Backoff << uint(attempt-1)
+	if delay <= 0 || delay > t.opts.MaxBackoff {
+		delay = t.opts.MaxBackoff
+	}
+
+	// Up to 20% jitter spreads retries of concurrent callers.
+	return delay - time.Duration(rand.Int63n(int64(delay)/5+1))
+}
+
+// retryAfter parses a Retry-After value, given either in seconds or as an
+// HTTP date.
+func retryAfter(value string) (time.Duration, bool) {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if when, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(when); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// failed reports whether an outcome counts against the host's breaker.
+func failed(response *http.Response, err error) bool {
+	return err != nil || response.StatusCode >= 500
+}
+
+// transient reports whether an outcome is worth retrying.
+func transient(ctx context.Context, response *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+
+	switch response.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelBody) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
@@ -0,0 +1,250 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// script is a RoundTripper answering statuses in turn; zero stands for a
+// connection error. The last outcome repeats.
+type script struct {
+	mu       sync.Mutex
+	statuses []int
+	calls    int
+	bodies   []string
+}
+
+func (s *script) RoundTrip(request *http.Request) (*http.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if request.Body != nil {
+		raw, _ := io.ReadAll(request.Body)
+		s.bodies = append(s.bodies, string(raw))
+	}
+
+	status := s.statuses[len(s.statuses)-1]
+	if s.calls < len(s.statuses) {
+		status = s.statuses[s.calls]
+	}
+	s.calls++
+
+	if status == 0 {
+		return nil, errors.New("connection refused")
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    request,
+	}, nil
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		body     string
+		key      bool
+		statuses []int
+		calls    int
+		status   int
+		fail     bool
+	}{
+		{"success", "GET", "", false, []int{200}, 1, 200, false},
+		{"retries unavailable", "GET", "", false, []int{503, 502, 200}, 3, 200, false},
+		{"retries connection errors", "GET", "", false, []int{0, 200}, 2, 200, false},
+		{"gives up", "GET", "", false, []int{503}, 3, 503, false},
+		{"no retry on internal error", "GET", "", false, []int{500, 200}, 1, 500, false},
+		{"no retry on client error", "GET", "", false, []int{404, 200}, 1, 404, false},
+		{"post not retried", "POST", "x", false, []int{503, 200}, 1, 503, false},
+		{"post with key retried", "POST", "x", true, []int{503, 200}, 2, 200, false},
+		{"last error returned", "GET", "", false, []int{0}, 3, 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stub := &script{statuses: test.statuses}
+			client := New(Options{Transport: stub, Backoff: time.Millisecond, MaxBackoff: time.Millisecond})
+
+			var body io.Reader
+			if len(test.body) > 0 {
+				body = strings.NewReader(test.body)
+			}
+			request, _ := http.NewRequest(test.method, "http://example.test/", body)
+			if test.key {
+				request.Header.Set("Idempotency-Key", "k")
+			}
+
+			response, err := client.Do(request)
+			if (err != nil) != test.fail {
+				t.Fatalf("error = %v", err)
+			}
+			if err == nil {
+				response.Body.Close()
+				if response.StatusCode != test.status {
+					t.Errorf("status = %d; want %d", response.StatusCode, test.status)
+				}
+			}
+
+			if stub.calls != test.calls {
+				t.Errorf("%d calls; want %d", stub.calls, test.calls)
+			}
+			for i, sent := range stub.bodies {
+				if sent != test.body {
+					t.Errorf("attempt %d sent %q; want %q", i+1, sent, test.body)
+				}
+			}
+		})
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	stub := &script{statuses: []int{500, 500, 200}}
+	transport := NewTransport(Options{Transport: stub, Threshold: 2, Cooldown: 20 * time.Millisecond})
+	client := &http.Client{Transport: transport}
+
+	steps := []struct {
+		name  string
+		sleep time.Duration
+		err   error
+		state State
+	}{
+		{"first failure", 0, nil, Closed},
+		{"opens", 0, nil, Open},
+		{"rejects while open", 0, ErrCircuitOpen, Open},
+		{"probe closes", 30 * time.Millisecond, nil, Closed},
+	}
+
+	for _, step := range steps {
+		time.Sleep(step.sleep)
+
+		response, err := client.Get("http://example.test/")
+		if err == nil {
+			response.Body.Close()
+		}
+		if !errors.Is(err, step.err) {
+			t.Errorf("%s: error = %v; want %v", step.name, err, step.err)
+		}
+
+		if state := transport.Stats()["example.test"].State; state != step.state {
+			t.Errorf("%s: state = %s; want %s", step.name, state, step.state)
+		}
+	}
+
+	stats := transport.Stats()["example.test"]
+	if stats.Requests != 3 || stats.Failures != 2 || stats.Rejected != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestHalfOpenSingleProbe(t *testing.T) {
+	b := &breaker{}
+	now := time.Now()
+	b.record(false, 1, time.Second, now)
+
+	later := now.Add(2 * time.Second)
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"probe", b.allow(later)},
+		{"second caller while probing", !b.allow(later)},
+	}
+
+	for _, test := range tests {
+		if !test.want {
+			t.Errorf("%s failed", test.name)
+		}
+	}
+
+	b.record(false, 1, time.Second, later)
+	if b.snapshot().State != Open {
+		t.Error("failed probe didn't reopen the breaker")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	transport := NewTransport(Options{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second})
+
+	tests := []struct {
+		attempt    int
+		retryAfter string
+		min, max   time.Duration
+	}{
+		{1, "", 80 * time.Millisecond, 100 * time.Millisecond},
+		{2, "", 160 * time.Millisecond, 200 * time.Millisecond},
+		{10, "", 800 * time.Millisecond, time.Second},
+		{100, "", 800 * time.Millisecond, time.Second},
+		{1, "0", 0, 0},
+		{1, "60", time.Second, time.Second},
+		{1, time.Now().Add(500 * time.Millisecond).UTC().Format(http.TimeFormat), 0, 500 * time.Millisecond},
+		{1, time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), time.Second, time.Second},
+		{1, "Mon, 02 Jan 2006 15:04:05 GMT", 0, 0},
+		{1, "soon", 80 * time.Millisecond, 100 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		response := &http.Response{Header: http.Header{}}
+		if len(test.retryAfter) > 0 {
+			response.Header.Set("Retry-After", test.retryAfter)
+		}
+
+		if delay := transport.backoff(test.attempt, response); delay < test.min || delay > test.max {
+			t.Errorf("backoff(%d, %q) = %s; want %s..%s", test.attempt, test.retryAfter, delay, test.min, test.max)
+		}
+	}
+}
+
+func TestAttemptTimeout(t *testing.T) {
+	slow := roundTripper(func(request *http.Request) (*http.Response, error) {
+		<-request.Context().Done()
+		return nil, request.Context().Err()
+	})
+
+	client := New(Options{Transport: slow, Timeout: 10 * time.Millisecond, MaxAttempts: 2, Backoff: time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	request, _ := http.NewRequestWithContext(ctx, "GET", "http://example.test/", nil)
+	started := time.Now()
+	if _, err := client.Do(request); err == nil {
+		t.Fatal("slow request succeeded")
+	}
+	if elapsed := time.Since(started); elapsed > 500*time.Millisecond {
+		t.Errorf("took %s; the attempt timeout didn't apply", elapsed)
+	}
+}
+
+func TestCallerCancelNotAFailure(t *testing.T) {
+	slow := roundTripper(func(request *http.Request) (*http.Response, error) {
+		<-request.Context().Done()
+		return nil, request.Context().Err()
+	})
+	transport := NewTransport(Options{Transport: slow, Threshold: 1, Timeout: time.Second})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	request, _ := http.NewRequestWithContext(ctx, "GET", "http://example.test/", nil)
+	if _, err := transport.RoundTrip(request); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v", err)
+	}
+
+	if stats := transport.Stats()["example.test"]; stats.State != Closed || stats.Failures != 0 {
+		t.Errorf("stats = %+v; the caller's deadline counted against the host", stats)
+	}
+}
+
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
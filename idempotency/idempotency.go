@@ -0,0 +1,285 @@
+// Package idempotency makes mutating endpoints safe to retry. Clients send
+// an Idempotency-Key header; the first response for a key is stored and
+// replayed for every retry carrying the same key, so a flaky client can't
+// trigger the same job twice.
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Header is the request header carrying the key.
+const Header = "Idempotency-Key"
+
+// ErrInProgress is returned by Store.Reserve when another request holds
+// the key.
+var ErrInProgress = errors.New("idempotency: request in progress")
+
+// Record is a stored response.
+type Record struct {
+	Fingerprint string
+	Status      int
+	Header      http.Header
+	Body        []byte
+	Created     time.Time
+}
+
+// Store persists records. Implementations must make Reserve atomic so two
+// concurrent requests with the same key can't both run the handler.
+type Store interface {
+	// Get returns the record saved for key, or nil when there is none.
+	Get(ctx context.Context, key string) (*Record, error)
+
+	// Reserve marks key as in progress for at most ttl. It returns
+	// ErrInProgress when the key is already reserved.
+	Reserve(ctx context.Context, key string, ttl time.Duration) error
+
+	// Extend pushes the expiry of a reservation to ttl from now. It is a
+	// no-op when key is not reserved.
+	Extend(ctx context.Context, key string, ttl time.Duration) error
+
+	// Save stores the final record and clears the reservation.
+	Save(ctx context.Context, key string, record *Record, ttl time.Duration) error
+
+	// Release clears a reservation without saving anything, so the
+	// request can be retried.
+	Release(ctx context.Context, key string) error
+}
+
+// Options configures the middleware.
+type Options struct {
+	// TTL is how long responses are replayed; it defaults to 24 hours.
+	TTL time.Duration
+
+	// Lock bounds how long a key stays reserved by a request that never
+	// finishes; it defaults to one minute. The reservation is extended
+	// every Lock/2 while the handler runs, so Lock doesn't cap handler
+	// duration; it only decides how soon a crashed instance's keys are
+	// freed.
+	Lock time.Duration
+
+	// MaxBody is the largest request body accepted with a key; it
+	// defaults to 10 MiB.
+	MaxBody int64
+
+	// Methods lists the methods the middleware applies to; it defaults
+	// to POST, PUT, PATCH and DELETE.
+	Methods []string
+
+	// Scope namespaces keys by caller identity so two clients can't
+	// collide or read each other's responses. It defaults to a digest of
+	// the Authorization header. Requests whose scope is empty bypass the
+	// middleware, since their responses could be replayed to anyone.
+	Scope func(r *http.Request) string
+}
+
+// Authorization scopes keys by a digest of the Authorization header.
+func Authorization(r *http.Request) string {
+	credentials := r.Header.Get("Authorization")
+	if len(credentials) == 0 {
+		return ""
+	}
+
+	digest := sha256.Sum256([]byte(credentials))
+	return hex.EncodeToString(digest[:])
+}
+
+// Middleware returns an http middleware enforcing idempotency keys.
+// Requests without a key or a scope pass through untouched. A retry whose
+// body or target differs from the original gets 422, a retry arriving
+// while the original is still running gets 409, and replayed responses
+// carry an Idempotent-Replayed header. 5xx responses are not stored so
+// they can be retried, and Set-Cookie headers are never replayed.
+func Middleware(store Store, opts Options) func(http.Handler) http.Handler {
+	if opts.TTL <= 0 {
+		opts.TTL = 24 * time.Hour
+	}
+	if opts.Lock <= 0 {
+		opts.Lock = time.Minute
+	}
+	if opts.MaxBody <= 0 {
+		opts.MaxBody = 10 << 20
+	}
+	if len(opts.Methods) == 0 {
+		opts.Methods = []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	}
+	if opts.Scope == nil {
+		opts.Scope = Authorization
+	}
+
+	methods := make(map[string]bool)
+	for _, method := range opts.Methods {
+		methods[method] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(Header)
+			if len(key) == 0 || !methods[r.Method] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			scope := opts.Scope(r)
+			if len(scope) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if len(key) > 255 {
+				http.Error(w, "idempotency key too long", http.StatusBadRequest)
+				return
+			}
+
+			body, err := io.ReadAll(io.LimitReader(r.Body, opts.MaxBody+1))
+			if err != nil {
+				http.Error(w, "cannot read body", http.StatusBadRequest)
+				return
+			} else if int64(len(body)) > opts.MaxBody {
+				http.Error(w, "body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			key = scope + "\x00" + key
+
+			ctx := r.Context()
+			fingerprint := fingerprint(r, body)
+
+			record, err := store.Get(ctx, key)
+			if err != nil {
+				http.Error(w, "idempotency store unavailable", http.StatusServiceUnavailable)
+				return
+			} else if record != nil {
+				replay(w, record, fingerprint)
+				return
+			}
+
+			if err := store.Reserve(ctx, key, opts.Lock); err == ErrInProgress {
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "a request with this idempotency key is in progress", http.StatusConflict)
+				return
+			} else if err != nil {
+				http.Error(w, "idempotency store unavailable", http.StatusServiceUnavailable)
+				return
+			}
+
+			recorder := &recorder{ResponseWriter: w, status: http.StatusOK}
+			completed := false
+
+			stop := extend(store, key, opts.Lock)
+			defer func() {
+				stop()
+
+				// Keep the key retryable when the handler panics or fails
+				// with a server error.
+				if !completed || recorder.status >= 500 {
+					store.Release(context.Background(), key)
+					return
+				}
+
+				header := w.Header().Clone()
+				header.Del("Set-Cookie")
+
+				store.Save(context.Background(), key, &Record{
+					Fingerprint: fingerprint,
+					Status:      recorder.status,
+					Header:      header,
+					Body:        recorder.body.Bytes(),
+					Created:     time.Now(),
+				}, opts.TTL)
+			}()
+
+			next.ServeHTTP(recorder, r)
+			completed = true
+		})
+	}
+}
+
+// extend keeps key reserved until the returned function is called.
+func extend(store Store, key string, lock time.Duration) func() {
+	// A tiny Lock must neither make NewTicker panic on a zero interval
+	// nor extend in a busy loop.
+	interval := lock / 2
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				store.Extend(context.Background(), key, lock)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+func replay(w http.ResponseWriter, record *Record, fingerprint string) {
+	if record.Fingerprint != fingerprint {
+		http.Error(w, "idempotency key reused with a different request",
+			http.StatusUnprocessableEntity)
+		return
+	}
+
+	header := w.Header()
+	for name, values := range record.Header {
+		header[name] = append([]string(nil), values...)
+	}
+
+	header.Set("Idempotent-Replayed", "true")
+	w.WriteHeader(record.Status)
+	w.Write(record.Body)
+}
+
+func fingerprint(r *http.Request, body []byte) string {
+	digest := sha256.New()
+
+	io.WriteString(digest, r.Method)
+	digest.Write([]byte{0})
+	io.WriteString(digest, r.URL.RequestURI())
+	digest.Write([]byte{0})
+	digest.Write(body)
+
+	return hex.EncodeToString(digest.Sum(nil))
+}
+
+// recorder copies the response while it is written to the client.
+type recorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(data []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
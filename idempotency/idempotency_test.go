@@ -0,0 +1,238 @@
+package idempotency
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	type request struct {
+		method string
+		path   string
+		key    string
+		auth   string
+		body   string
+	}
+
+	tests := []struct {
+		name     string
+		requests []request
+		codes    []int
+		calls    int32
+		replayed []bool
+	}{
+		{
+			name: "replays the same request",
+			requests: []request{
+				{"POST", "/jobs", "k", "token", "a"},
+				{"POST", "/jobs", "k", "token", "a"},
+			},
+			codes:    []int{201, 201},
+			calls:    1,
+			replayed: []bool{false, true},
+		},
+		{
+			name: "rejects a different body",
+			requests: []request{
+				{"POST", "/jobs", "k", "token", "a"},
+				{"POST", "/jobs", "k", "token", "b"},
+			},
+			codes:    []int{201, 422},
+			calls:    1,
+			replayed: []bool{false, false},
+		},
+		{
+			name: "scopes keys by caller",
+			requests: []request{
+				{"POST", "/jobs", "k", "alice", "a"},
+				{"POST", "/jobs", "k", "bob", "a"},
+			},
+			codes:    []int{201, 201},
+			calls:    2,
+			replayed: []bool{false, false},
+		},
+		{
+			name: "bypasses anonymous requests",
+			requests: []request{
+				{"POST", "/jobs", "k", "", "a"},
+				{"POST", "/jobs", "k", "", "a"},
+			},
+			codes:    []int{201, 201},
+			calls:    2,
+			replayed: []bool{false, false},
+		},
+		{
+			name: "ignores safe methods",
+			requests: []request{
+				{"GET", "/jobs", "k", "token", ""},
+				{"GET", "/jobs", "k", "token", ""},
+			},
+			codes:    []int{201, 201},
+			calls:    2,
+			replayed: []bool{false, false},
+		},
+		{
+			name: "retries server errors",
+			requests: []request{
+				{"POST", "/fail", "k", "token", "a"},
+				{"POST", "/fail", "k", "token", "a"},
+			},
+			codes:    []int{500, 500},
+			calls:    2,
+			replayed: []bool{false, false},
+		},
+		{
+			name:     "rejects long keys",
+			requests: []request{{"POST", "/jobs", strings.Repeat("k", 256), "token", "a"}},
+			codes:    []int{400},
+			calls:    0,
+			replayed: []bool{false},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int32
+			handler := Middleware(NewMemory(), Options{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				if r.URL.Path == "/fail" {
+					http.Error(w, "boom", http.StatusInternalServerError)
+					return
+				}
+				http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+				w.WriteHeader(http.StatusCreated)
+			}))
+
+			for i, item := range test.requests {
+				r := httptest.NewRequest(item.method, item.path, strings.NewReader(item.body))
+				r.Header.Set(Header, item.key)
+				if len(item.auth) > 0 {
+					r.Header.Set("Authorization", "Bearer "+item.auth)
+				}
+
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, r)
+
+				if w.Code != test.codes[i] {
+					t.Errorf("request %d: code = %d; want %d", i, w.Code, test.codes[i])
+				}
+
+				replayed := w.Header().Get("Idempotent-Replayed") == "true"
+				if replayed != test.replayed[i] {
+					t.Errorf("request %d: replayed = %v; want %v", i, replayed, test.replayed[i])
+				}
+				if replayed && len(w.Header().Get("Set-Cookie")) > 0 {
+					t.Errorf("request %d: replayed Set-Cookie", i)
+				}
+			}
+
+			if calls != test.calls {
+				t.Errorf("handler called %d times; want %d", calls, test.calls)
+			}
+		})
+	}
+}
+
+func TestLockExtendedWhileRunning(t *testing.T) {
+	store := NewMemory()
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	handler := Middleware(store, Options{Lock: 20 * time.Millisecond})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	request := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/jobs", nil)
+		r.Header.Set(Header, "k")
+		r.Header.Set("Authorization", "Bearer token")
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	done := make(chan struct{})
+	go func() {
+		request()
+		close(done)
+	}()
+	<-started
+
+	// Outlive the lock several times over; the retry must still conflict.
+	time.Sleep(100 * time.Millisecond)
+	if w := request(); w.Code != http.StatusConflict {
+		t.Errorf("retry during a long request = %d; want %d", w.Code, http.StatusConflict)
+	}
+
+	close(release)
+	<-done
+}
+
+func TestTinyLock(t *testing.T) {
+	handler := Middleware(NewMemory(), Options{Lock: time.Nanosecond})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	r := httptest.NewRequest("POST", "/jobs", nil)
+	r.Header.Set(Header, "k")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusCreated {
+		t.Errorf("code = %d; want %d", w.Code, http.StatusCreated)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		run  func(m *Memory) error
+		want error
+	}{
+		{"reserve", func(m *Memory) error { return m.Reserve(ctx, "k", time.Minute) }, nil},
+		{"reserve twice", func(m *Memory) error {
+			m.Reserve(ctx, "k", time.Minute)
+			return m.Reserve(ctx, "k", time.Minute)
+		}, ErrInProgress},
+		{"reserve after expiry", func(m *Memory) error {
+			m.Reserve(ctx, "k", time.Nanosecond)
+			time.Sleep(time.Millisecond)
+			return m.Reserve(ctx, "k", time.Minute)
+		}, nil},
+		{"reserve after release", func(m *Memory) error {
+			m.Reserve(ctx, "k", time.Minute)
+			m.Release(ctx, "k")
+			return m.Reserve(ctx, "k", time.Minute)
+		}, nil},
+		{"release keeps records", func(m *Memory) error {
+			m.Save(ctx, "k", &Record{}, time.Minute)
+			m.Release(ctx, "k")
+			if record, _ := m.Get(ctx, "k"); record == nil {
+				return fmt.Errorf("record released")
+			}
+			return nil
+		}, nil},
+		{"extend", func(m *Memory) error {
+			m.Reserve(ctx, "k", 5*time.Millisecond)
+			m.Extend(ctx, "k", time.Minute)
+			time.Sleep(10 * time.Millisecond)
+			return m.Reserve(ctx, "k", time.Minute)
+		}, ErrInProgress},
+	}
+
+	for _, test := range tests {
+		if err := test.run(NewMemory()); err != test.want {
+			t.Errorf("%s = %v; want %v", test.name, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory is a process-local Store. It only protects retries that reach
+// the same instance; deployments with several instances need a shared
+// Store.
+type Memory struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+}
+
+type memoryEntry struct {
+	record  *Record
+	expires time.Time
+}
+
+// NewMemory creates an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{entries: make(map[string]*memoryEntry)}
+}
+
+func (m *Memory) lookup(key string, now time.Time) *memoryEntry {
+	entry, ok := m.entries[key]
+	if !ok {
+		return nil
+	}
+
+	if now.After(entry.expires) {
+		delete(m.entries, key)
+		return nil
+	}
+	return entry
+}
+
+// Get implements Store.
+func (m *Memory) Get(ctx context.Context, key string) (*Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if entry := m.lookup(key, time.Now()); entry != nil {
+		return entry.record, nil
+	}
+	return nil, nil
+}
+
+// Reserve implements Store.
+func (m *Memory) Reserve(ctx context.Context, key string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if entry := m.lookup(key, now); entry != nil {
+		return ErrInProgress
+	}
+
+	m.entries[key] = &memoryEntry{expires: now.Add(ttl)}
+	return nil
+}
+
+// Extend implements Store.
+func (m *Memory) Extend(ctx context.Context, key string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if entry := m.lookup(key, time.Now()); entry != nil && entry.record == nil {
+		entry.expires = time.Now().Add(ttl)
+	}
+	return nil
+}
+
+// Save implements Store.
+func (m *Memory) Save(ctx context.Context, key string, record *Record, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[key] = &memoryEntry{record: record, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+// Release implements Store.
+func (m *Memory) Release(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if entry, ok := m.entries[key]; ok && entry.record == nil {
+		delete(m.entries, key)
+	}
+	return nil
+}
+
+// Purge drops expired entries; call it periodically on long-running
+// servers.
+func (m *Memory) Purge() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for key := range m.entries {
+		m.lookup(key, now)
+	}
+}
@@ -0,0 +1,319 @@
+// Package ipfilter rejects requests by client address before they reach
+// the handlers. Policies combine static allow and deny CIDR lists with
+// optional country rules backed by a pluggable GeoIP lookup, and may be
+// set per route.
+package ipfilter
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CountryLookup resolves an address to an ISO 3166-1 alpha-2 code.
+type CountryLookup interface {
+	Country(ip net.IP) (string, error)
+}
+
+// CountryFunc adapts a function to CountryLookup.
+type CountryFunc func(ip net.IP) (string, error)
+
+// Country implements CountryLookup.
+func (f CountryFunc) Country(ip net.IP) (string, error) {
+	return f(ip)
+}
+
+// Policy lists the addresses and countries a route accepts. Entries are
+// CIDRs or single addresses; deny rules win over allow rules, and empty
+// allow lists accept everything not denied.
+type Policy struct {
+	Allow          []string
+	Deny           []string
+	AllowCountries []string
+	DenyCountries  []string
+}
+
+// Decision describes the outcome for one request.
+type Decision struct {
+	Time    time.Time
+	IP      string
+	Country string
+	Method  string
+	Path    string
+	Allowed bool
+	Reason  string
+}
+
+// Options configures a Filter.
+type Options struct {
+	// Default applies to paths not covered by Routes.
+	Default Policy
+
+	// Routes maps path prefixes to their own policy; the longest
+	// matching prefix wins. Prefixes match whole path segments, so
+	// "/admin" and "/admin/" both cover "/admin" and "/admin/users" but
+	// not "/administrator".
+	Routes map[string]Policy
+
+	// Lookup resolves countries; country rules are ignored without it.
+	Lookup CountryLookup
+
+	// FailClosed rejects requests whose country can't be resolved while
+	// country rules apply; by default they are let through.
+	FailClosed bool
+
+	// TrustedProxies are the peers whose X-Forwarded-For header is
+	// believed when finding the client address.
+	TrustedProxies []string
+
+	// Log receives every decision, e.g. to record it in an audit trail.
+	Log func(Decision)
+}
+
+type policy struct {
+	allow          []*net.IPNet
+	deny           []*net.IPNet
+	allowCountries map[string]bool
+	denyCountries  map[string]bool
+}
+
+type route struct {
+	prefix string
+	policy *policy
+}
+
+// Filter is the compiled form of Options.
+type Filter struct {
+	opts     Options
+	fallback *policy
+	routes   []route
+	proxies  []*net.IPNet
+}
+
+// New compiles opts, failing on malformed addresses.
+func New(opts Options) (*Filter, error) {
+	fallback, err := compile(opts.Default)
+	if err != nil {
+		return nil, err
+	}
+
+	proxies, err := networks(opts.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Filter{opts: opts, fallback: fallback, proxies: proxies}
+	for prefix, spec := range opts.Routes {
+		compiled, err := compile(spec)
+		if err != nil {
+			return nil, fmt.Errorf("%w in route %s", err, prefix)
+		}
+		f.routes = append(f.routes, route{prefix: strings.TrimSuffix(prefix, "/"), policy: compiled})
+	}
+
+	sort.Slice(f.routes, func(i, j int) bool {
+		return len(f.routes[i].prefix) > len(f.routes[j].prefix)
+	})
+	return f, nil
+}
+
+// Middleware rejects filtered requests with 403 Forbidden.
+func (f *Filter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !f.Check(r).Allowed {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Check evaluates r and reports the decision to Options.Log.
+func (f *Filter) Check(r *http.Request) Decision {
+	ip := f.ClientIP(r)
+	decision := Decision{
+		Time:    time.Now(),
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Allowed: true,
+	}
+
+	if ip != nil {
+		decision.IP = ip.String()
+	}
+
+	// Routes match the cleaned path, as muxes route "//admin" and
+	// "/x/../admin" to /admin too.
+	f.evaluate(f.policy(path.Clean("/"+r.URL.Path)), ip, &decision)
+	if f.opts.Log != nil {
+		f.opts.Log(decision)
+	}
+	return decision
+}
+
+// ClientIP returns the address of the client, looking through trusted
+// proxies, or nil when it can't be parsed.
+func (f *Filter) ClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !contains(f.proxies, ip) {
+		return ip
+	}
+
+	// Walk the chain from the nearest hop and stop at the first address
+	// not added by one of our own proxies.
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseHop(hops[i])
+		if hop == nil {
+			break
+		}
+
+		ip = hop
+		if !contains(f.proxies, hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// parseHop parses one X-Forwarded-For entry, which some proxies write
+// with a port, e.g. "203.0.113.7:52100" or "[2001:db8::1]:443".
+func parseHop(hop string) net.IP {
+	hop = strings.TrimSpace(hop)
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+	}
+	return net.ParseIP(hop)
+}
+
+func (f *Filter) policy(path string) *policy {
+	for _, candidate := range f.routes {
+		if covers(candidate.prefix, path) {
+			return candidate.policy
+		}
+	}
+	return f.fallback
+}
+
+// covers reports whether path lies under prefix on a segment boundary;
+// prefix has no trailing slash, so the root route is the empty string.
+func covers(prefix, path string) bool {
+	return len(prefix) == 0 || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+func (f *Filter) evaluate(p *policy, ip net.IP, decision *Decision) {
+	deny := func(reason string) {
+		decision.Allowed = false
+		decision.Reason = reason
+	}
+
+	switch {
+	case ip == nil:
+		deny("unparsable client address")
+		return
+
+	case contains(p.deny, ip):
+		deny("address denied")
+		return
+
+	case len(p.allow) > 0 && !contains(p.allow, ip):
+		deny("address not allowed")
+		return
+	}
+
+	if f.opts.Lookup == nil || len(p.allowCountries)+len(p.denyCountries) == 0 {
+		return
+	}
+
+	country, err := f.opts.Lookup.Country(ip)
+	if err != nil {
+		if f.opts.FailClosed {
+			deny("country lookup failed: " + err.Error())
+		}
+		return
+	}
+
+	decision.Country = strings.ToUpper(country)
+	switch {
+	case p.denyCountries[decision.Country]:
+		deny("country denied")
+
+	case len(p.allowCountries) > 0 && !p.allowCountries[decision.Country]:
+		deny("country not allowed")
+	}
+}
+
+func compile(spec Policy) (*policy, error) {
+	allow, err := networks(spec.Allow)
+	if err != nil {
+		return nil, err
+	}
+
+	deny, err := networks(spec.Deny)
+	if err != nil {
+		return nil, err
+	}
+
+	return &policy{
+		allow:          allow,
+		deny:           deny,
+		allowCountries: countries(spec.AllowCountries),
+		denyCountries:  countries(spec.DenyCountries),
+	}, nil
+}
+
+// networks parses CIDRs, treating bare addresses as single hosts.
+func networks(entries []string) ([]*net.IPNet, error) {
+	var result []*net.IPNet
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("ipfilter: invalid address %q", entry)
+			}
+
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+
+			result = append(result, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("ipfilter: invalid network %q", entry)
+		}
+		result = append(result, network)
+	}
+	return result, nil
+}
+
+func countries(codes []string) map[string]bool {
+	result := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		result[strings.ToUpper(strings.TrimSpace(code))] = true
+	}
+	return result
+}
+
+func contains(list []*net.IPNet, ip net.IP) bool {
+	for _, network := range list {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package ipfilter
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRoutes(t *testing.T) {
+	f, err := New(Options{
+		Routes: map[string]Policy{
+			"/admin/":     {Allow: []string{"10.0.0.0/8"}},
+			"/admin/open": {},
+			"/api":        {Deny: []string{"192.0.2.1"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		remote string
+		want   bool
+	}{
+		{"/admin", "198.51.100.1:1", false},
+		{"/admin", "10.1.2.3:1", true},
+		{"/admin/users", "198.51.100.1:1", false},
+		{"//admin", "198.51.100.1:1", false},
+		{"/x/../admin/users", "198.51.100.1:1", false},
+		{"/admin/./open/../users", "198.51.100.1:1", false},
+		{"/administrator", "198.51.100.1:1", true},
+		{"/admin/open", "198.51.100.1:1", true},
+		{"/admin/open/x", "198.51.100.1:1", true},
+		{"/admin/opened", "198.51.100.1:1", false},
+		{"/api/jobs", "192.0.2.1:1", false},
+		{"/apis", "192.0.2.1:1", true},
+		{"/", "192.0.2.1:1", true},
+		{"/", "garbage", false},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.path, nil)
+		r.RemoteAddr = test.remote
+
+		if got := f.Check(r); got.Allowed != test.want {
+			t.Errorf("%s from %s: allowed = %v (%s); want %v", test.path, test.remote, got.Allowed, got.Reason, test.want)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	f, err := New(Options{TrustedProxies: []string{"10.0.0.0/8", "fd00::/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"direct", "203.0.113.7:4000", nil, "203.0.113.7"},
+		{"untrusted peer", "203.0.113.7:4000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"one proxy", "10.0.0.1:4000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"spoofed head", "10.0.0.1:4000", []string{"1.2.3.4, 198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"split headers", "10.0.0.1:4000", []string{"198.51.100.1", "10.0.0.2"}, "198.51.100.1"},
+		{"hop with port", "10.0.0.1:4000", []string{"198.51.100.1:52100"}, "198.51.100.1"},
+		{"ipv6 hop with port", "10.0.0.1:4000", []string{"[2001:db8::1]:443"}, "2001:db8::1"},
+		{"ipv6 proxy", "[fd00::1]:4000", []string{"2001:db8::2"}, "2001:db8::2"},
+		{"garbage hop", "10.0.0.1:4000", []string{"unknown"}, "10.0.0.1"},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remote
+		for _, value := range test.forwarded {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+
+		if got := f.ClientIP(r); got.String() != test.want {
+			t.Errorf("%s: ClientIP = %s; want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCountries(t *testing.T) {
+	lookup := CountryFunc(func(ip net.IP) (string, error) {
+		switch ip.String() {
+		case "192.0.2.1":
+			return "fr", nil
+		case "192.0.2.2":
+			return "us", nil
+		}
+		return "", errors.New("unknown")
+	})
+
+	tests := []struct {
+		name       string
+		policy     Policy
+		failClosed bool
+		remote     string
+		want       bool
+	}{
+		{"allowed country", Policy{AllowCountries: []string{"FR"}}, false, "192.0.2.1:1", true},
+		{"other country", Policy{AllowCountries: []string{"FR"}}, false, "192.0.2.2:1", false},
+		{"denied country", Policy{DenyCountries: []string{"us"}}, false, "192.0.2.2:1", false},
+		{"unknown fails open", Policy{AllowCountries: []string{"FR"}}, false, "192.0.2.3:1", true},
+		{"unknown fails closed", Policy{AllowCountries: []string{"FR"}}, true, "192.0.2.3:1", false},
+		{"deny address wins", Policy{Deny: []string{"192.0.2.1"}, AllowCountries: []string{"FR"}}, false, "192.0.2.1:1", false},
+	}
+
+	for _, test := range tests {
+		var logged []Decision
+		f, err := New(Options{
+			Default:    test.policy,
+			Lookup:     lookup,
+			FailClosed: test.failClosed,
+			Log:        func(d Decision) { logged = append(logged, d) },
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		handler := f.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remote
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if allowed := w.Code == http.StatusOK; allowed != test.want {
+			t.Errorf("%s: code = %d; want allowed %v", test.name, w.Code, test.want)
+		}
+		if len(logged) != 1 || logged[0].Allowed != test.want {
+			t.Errorf("%s: logged %+v", test.name, logged)
+		}
+	}
+}
+
+func TestNewRejectsMalformed(t *testing.T) {
+	tests := []Options{
+		{Default: Policy{Allow: []string{"10.0.0.0/33"}}},
+		{Default: Policy{Deny: []string{"not an ip"}}},
+		{TrustedProxies: []string{"10.0.0"}},
+		{Routes: map[string]Policy{"/x": {Allow: []string{"::g"}}}},
+	}
+
+	for i, opts := range tests {
+		if _, err := New(opts); err == nil {
+			t.Errorf("options %d accepted", i)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package kv
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bucket is a namespace of keys. A ttl of zero or less never expires.
+type Bucket struct {
+	db   *DB
+	name string
+}
+
+// lookup returns the live entry of key; db.mu must be held.
+func (b *Bucket) lookup(key string, now time.Time) *entry {
+	stored, ok := b.db.buckets[b.name][key]
+	if !ok || stored.expired(now) {
+		return nil
+	}
+	return stored
+}
+
+// Get returns the value of key, or ErrNotFound.
+func (b *Bucket) Get(key string) ([]byte, error) {
+	b.db.mu.Lock()
+	defer b.db.mu.Unlock()
+
+	if b.db.closed {
+		return nil, ErrClosed
+	}
+
+	stored := b.lookup(key, time.Now())
+	if stored == nil {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), stored.value...), nil
+}
+
+// Put sets the value of key.
+func (b *Bucket) Put(key string, value []byte, ttl time.Duration) error {
+	b.db.mu.Lock()
+	defer b.db.mu.Unlock()
+
+	return b.put(key, value, ttl)
+}
+
+func (b *Bucket) put(key string, value []byte, ttl time.Duration) error {
+	return b.db.write(record{
+		Bucket:  b.name,
+		Key:     key,
+		Value:   append([]byte(nil), value...),
+		Expires: expiry(ttl, time.Now()),
+	})
+}
+
+// PutIfAbsent sets the value of key unless it already has a live one. It
+// reports whether it did.
+func (b *Bucket) PutIfAbsent(key string, value []byte, ttl time.Duration) (bool, error) {
+	b.db.mu.Lock()
+	defer b.db.mu.Unlock()
+
+	if b.lookup(key, time.Now()) != nil {
+		return false, nil
+	}
+	return true, b.put(key, value, ttl)
+}
+
+// Update replaces the value of key with the one fn derives from the
+// current value, atomically; value is nil when key is missing. fn
+// returns the new value and ttl, or a nil value to delete the key. An
+// error from fn is returned unchanged and nothing is written.
+func (b *Bucket) Update(key string, fn func(value []byte) ([]byte, time.Duration, error)) error {
+	b.db.mu.Lock()
+	defer b.db.mu.Unlock()
+
+	if b.db.closed {
+		return ErrClosed
+	}
+
+	var current []byte
+	if stored := b.lookup(key, time.Now()); stored != nil {
+		current = append([]byte(nil), stored.value...)
+	}
+
+	value, ttl, err := fn(current)
+	if err != nil {
+		return err
+	}
+
+	if value == nil {
+		if current == nil {
+			return nil
+		}
+		return b.db.write(record{Bucket: b.name, Key: key, Delete: true})
+	}
+	return b.put(key, value, ttl)
+}
+
+// Add increments the counter at key by delta and returns the new count.
+// A missing counter starts from zero and expires after ttl, which later
+// increments keep: a key per time window makes a fixed-window rate
+// limiter.
+func (b *Bucket) Add(key string, delta int64, ttl time.Duration) (int64, error) {
+	b.db.mu.Lock()
+	defer b.db.mu.Unlock()
+
+	now := time.Now()
+	count := delta
+	expires := expiry(ttl, now)
+
+	if stored := b.lookup(key, now); stored != nil {
+		count += decodeCount(stored.value)
+		expires = 0
+		if !stored.expires.IsZero() {
+			expires = stored.expires.UnixNano()
+		}
+	}
+
+	return count, b.db.write(record{
+		Bucket:  b.name,
+		Key:     key,
+		Value:   encodeCount(count),
+		Expires: expires,
+	})
+}
+
+// Delete removes key; missing keys are not an error.
+func (b *Bucket) Delete(key string) error {
+	b.db.mu.Lock()
+	defer b.db.mu.Unlock()
+
+	if b.db.closed {
+		return ErrClosed
+	}
+	if _, ok := b.db.buckets[b.name][key]; !ok {
+		return nil
+	}
+	return b.db.write(record{Bucket: b.name, Key: key, Delete: true})
+}
+
+// Scan calls fn for every live key starting with prefix, in no
+// particular order, until fn returns false. fn must not use the store.
+func (b *Bucket) Scan(prefix string, fn func(key string, value []byte) bool) error {
+	b.db.mu.Lock()
+	defer b.db.mu.Unlock()
+
+	if b.db.closed {
+		return ErrClosed
+	}
+
+	now := time.Now()
+	for key, stored := range b.db.buckets[b.name] {
+		if !strings.HasPrefix(key, prefix) || stored.expired(now) {
+			continue
+		}
+		if !fn(key, append([]byte(nil), stored.value...)) {
+			break
+		}
+	}
+	return nil
+}
+
+func encodeCount(count int64) []byte {
+	return []byte(strconv.FormatInt(count, 10))
+}
+
+func decodeCount(value []byte) int64 {
+	count, _ := strconv.ParseInt(string(value), 10, 64)
+	return count
+}
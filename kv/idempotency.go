@@ -0,0 +1,100 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"devops.io/cloud/idempotency"
+)
+
+// errUnchanged aborts an Update that has nothing to write.
+var errUnchanged = errors.New("kv: unchanged")
+
+// Idempotency is an idempotency.Store kept in a bucket, so replayable
+// responses survive restarts.
+type Idempotency struct {
+	bucket *Bucket
+}
+
+// NewIdempotency stores idempotency records in bucket.
+func NewIdempotency(bucket *Bucket) *Idempotency {
+	return &Idempotency{bucket: bucket}
+}
+
+// idempotencyEntry is a reservation while Record is nil.
+type idempotencyEntry struct {
+	Record *idempotency.Record `json:"record"`
+}
+
+// Get implements idempotency.Store.
+func (s *Idempotency) Get(ctx context.Context, key string) (*idempotency.Record, error) {
+	value, err := s.bucket.Get(key)
+	if err == ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var stored idempotencyEntry
+	if err := json.Unmarshal(value, &stored); err != nil {
+		return nil, err
+	}
+	return stored.Record, nil
+}
+
+// Reserve implements idempotency.Store.
+func (s *Idempotency) Reserve(ctx context.Context, key string, ttl time.Duration) error {
+	reserved, err := s.bucket.PutIfAbsent(key, []byte(`{"record":null}`), ttl)
+	if err != nil {
+		return err
+	} else if !reserved {
+		return idempotency.ErrInProgress
+	}
+	return nil
+}
+
+// Extend implements idempotency.Store.
+func (s *Idempotency) Extend(ctx context.Context, key string, ttl time.Duration) error {
+	err := s.bucket.Update(key, func(value []byte) ([]byte, time.Duration, error) {
+		if value == nil || !reservation(value) {
+			return nil, 0, errUnchanged
+		}
+		return value, ttl, nil
+	})
+
+	if err == errUnchanged {
+		return nil
+	}
+	return err
+}
+
+// Save implements idempotency.Store.
+func (s *Idempotency) Save(ctx context.Context, key string, record *idempotency.Record, ttl time.Duration) error {
+	value, err := json.Marshal(idempotencyEntry{Record: record})
+	if err != nil {
+		return err
+	}
+	return s.bucket.Put(key, value, ttl)
+}
+
+// Release implements idempotency.Store.
+func (s *Idempotency) Release(ctx context.Context, key string) error {
+	err := s.bucket.Update(key, func(value []byte) ([]byte, time.Duration, error) {
+		if value == nil || !reservation(value) {
+			return nil, 0, errUnchanged
+		}
+		return nil, 0, nil
+	})
+
+	if err == errUnchanged {
+		return nil
+	}
+	return err
+}
+
+func reservation(value []byte) bool {
+	var stored idempotencyEntry
+	return json.Unmarshal(value, &stored) == nil && stored.Record == nil
+}
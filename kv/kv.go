@@ -0,0 +1,313 @@
+// Package kv is a small embedded key-value store for server-local state,
+// such as idempotency keys, rate counters and sessions, when no external
+// database is configured. Keys live in named buckets and may expire.
+//
+// The whole data set is held in memory. Every change is appended to a
+// log file that Open replays, and the log is rewritten once it holds
+// mostly stale entries, so the store suits small, frequently changing
+// state rather than bulk data.
+package kv
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned for missing and expired keys.
+	ErrNotFound = errors.New("kv: key not found")
+
+	// ErrClosed is returned once the store has been closed.
+	ErrClosed = errors.New("kv: store is closed")
+)
+
+// Options configures a store.
+type Options struct {
+	// Sync flushes the log to disk after every change instead of leaving
+	// it to the operating system; it survives machine crashes at the
+	// cost of write latency.
+	Sync bool
+
+	// CompactAbove is the log size below which the log is never
+	// rewritten; it defaults to 4 MiB.
+	CompactAbove int64
+}
+
+type entry struct {
+	value   []byte
+	expires time.Time
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+// record is one line of the log. Expires is in Unix nanoseconds, zero
+// for keys that never expire.
+type record struct {
+	Bucket  string `json:"b"`
+	Key     string `json:"k"`
+	Value   []byte `json:"v,omitempty"`
+	Expires int64  `json:"e,omitempty"`
+	Delete  bool   `json:"d,omitempty"`
+}
+
+func expiry(ttl time.Duration, now time.Time) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return now.Add(ttl).UnixNano()
+}
+
+// DB is an open store.
+type DB struct {
+	mu      sync.Mutex
+	opts    Options
+	path    string
+	file    *os.File
+	buckets map[string]map[string]*entry
+	logged  int64
+	live    int64
+	writes  int
+	closed  bool
+}
+
+// purgeEvery is how many writes pass between sweeps of expired keys.
+const purgeEvery = 1024
+
+// Open loads the store kept at path, creating it if needed. An empty
+// path keeps the store in memory only.
+func Open(path string, opts Options) (*DB, error) {
+	if opts.CompactAbove <= 0 {
+		opts.CompactAbove = 4 << 20
+	}
+
+	db := &DB{opts: opts, path: path, buckets: make(map[string]map[string]*entry)}
+	if len(path) == 0 {
+		return db, nil
+	}
+
+	if err := db.replay(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	db.file = file
+
+	return db, nil
+}
+
+// replay rebuilds the data set from the log. A torn last line, left by a
+// crash in the middle of a write, is cut off.
+func (db *DB) replay() error {
+	file, err := os.Open(db.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	now := time.Now()
+	reader := bufio.NewReader(file)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			if len(line) > 0 {
+				return os.Truncate(db.path, db.logged)
+			}
+			return nil
+		}
+
+		var item record
+		if err := json.Unmarshal(line, &item); err != nil {
+			return os.Truncate(db.path, db.logged)
+		}
+
+		db.logged += int64(len(line))
+		db.apply(item, now)
+	}
+}
+
+// apply changes the data set; db.mu must be held, except during replay.
+func (db *DB) apply(item record, now time.Time) {
+	bucket := db.buckets[item.Bucket]
+	if previous, ok := bucket[item.Key]; ok {
+		db.live -= int64(len(item.Key) + len(previous.value))
+		delete(bucket, item.Key)
+	}
+
+	stored := &entry{value: item.Value}
+	if item.Expires != 0 {
+		stored.expires = time.Unix(0, item.Expires)
+	}
+	if item.Delete || stored.expired(now) {
+		return
+	}
+
+	if bucket == nil {
+		bucket = make(map[string]*entry)
+		db.buckets[item.Bucket] = bucket
+	}
+	bucket[item.Key] = stored
+	db.live += int64(len(item.Key) + len(item.Value))
+}
+
+// write logs and applies a change; db.mu must be held.
+func (db *DB) write(item record) error {
+	if db.closed {
+		return ErrClosed
+	}
+
+	if db.file != nil {
+		line, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+
+		line = append(line, '\n')
+		if _, err := db.file.Write(line); err != nil {
+			return err
+		}
+		if db.opts.Sync {
+			if err := db.file.Sync(); err != nil {
+				return err
+			}
+		}
+		db.logged += int64(len(line))
+	}
+
+	now := time.Now()
+	db.apply(item, now)
+
+	if db.writes++; db.writes%purgeEvery == 0 {
+		db.purge(now)
+	}
+
+	if db.logged > db.opts.CompactAbove && db.logged > 2*db.live {
+		return db.compact()
+	}
+	return nil
+}
+
+// Compact rewrites the log with only the live entries.
+func (db *DB) Compact() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	return db.compact()
+}
+
+func (db *DB) compact() error {
+	if db.file == nil {
+		return nil
+	}
+
+	temp, err := os.OpenFile(db.path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	db.purge(time.Now())
+
+	writer := bufio.NewWriter(temp)
+	written := int64(0)
+
+	for name, bucket := range db.buckets {
+		for key, stored := range bucket {
+			item := record{Bucket: name, Key: key, Value: stored.value}
+			if !stored.expires.IsZero() {
+				item.Expires = stored.expires.UnixNano()
+			}
+
+			line, err := json.Marshal(item)
+			if err != nil {
+				temp.Close()
+				return err
+			}
+
+			writer.Write(append(line, '\n'))
+			written += int64(len(line) + 1)
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(temp.Name(), db.path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(db.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	db.file.Close()
+	db.file = file
+	db.logged = written
+	return nil
+}
+
+// purge drops expired keys; db.mu must be held. Their log lines go with
+// the next compaction.
+func (db *DB) purge(now time.Time) {
+	for name, bucket := range db.buckets {
+		for key, stored := range bucket {
+			if stored.expired(now) {
+				delete(bucket, key)
+				db.live -= int64(len(key) + len(stored.value))
+			}
+		}
+
+		if len(bucket) == 0 {
+			delete(db.buckets, name)
+		}
+	}
+}
+
+// Close flushes and closes the log.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return nil
+	}
+	db.closed = true
+
+	if db.file == nil {
+		return nil
+	}
+	if err := db.file.Sync(); err != nil {
+		db.file.Close()
+		return err
+	}
+	return db.file.Close()
+}
+
+// Bucket returns the namespace called name; buckets need no creation.
+func (db *DB) Bucket(name string) *Bucket {
+	return &Bucket{db: db, name: name}
+}
@@ -0,0 +1,237 @@
+package kv
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"devops.io/cloud/idempotency"
+	"devops.io/cloud/session"
+)
+
+var (
+	_ idempotency.Store = (*Idempotency)(nil)
+	_ session.Store     = (*Sessions)(nil)
+)
+
+func open(t *testing.T, path string, opts Options) *DB {
+	db, err := Open(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestBucket(t *testing.T) {
+	db := open(t, "", Options{})
+	jobs, other := db.Bucket("jobs"), db.Bucket("other")
+
+	jobs.Put("a", []byte("1"), 0)
+	jobs.Put("gone", []byte("1"), time.Nanosecond)
+	other.Put("a", []byte("2"), 0)
+	time.Sleep(time.Millisecond)
+
+	tests := []struct {
+		name   string
+		bucket *Bucket
+		key    string
+		value  string
+		err    error
+	}{
+		{"stored", jobs, "a", "1", nil},
+		{"namespaced", other, "a", "2", nil},
+		{"missing", jobs, "b", "", ErrNotFound},
+		{"expired", jobs, "gone", "", ErrNotFound},
+	}
+
+	for _, test := range tests {
+		value, err := test.bucket.Get(test.key)
+		if err != test.err || string(value) != test.value {
+			t.Errorf("%s: Get = %q, %v; want %q, %v", test.name, value, err, test.value, test.err)
+		}
+	}
+
+	if ok, _ := jobs.PutIfAbsent("a", []byte("3"), 0); ok {
+		t.Error("PutIfAbsent replaced a live key")
+	}
+	if ok, _ := jobs.PutIfAbsent("gone", []byte("3"), 0); !ok {
+		t.Error("PutIfAbsent refused an expired key")
+	}
+
+	jobs.Delete("a")
+	if _, err := jobs.Get("a"); err != ErrNotFound {
+		t.Errorf("Get after Delete = %v", err)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	db := open(t, "", Options{})
+	limits := db.Bucket("limits")
+
+	for i := int64(1); i <= 3; i++ {
+		if count, err := limits.Add("alice:minute", 1, time.Minute); err != nil || count != i {
+			t.Fatalf("Add = %d, %v; want %d", count, err, i)
+		}
+	}
+
+	limits.Add("bob:minute", 5, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	if count, _ := limits.Add("bob:minute", 1, time.Minute); count != 1 {
+		t.Errorf("expired counter continued at %d; want 1", count)
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.log")
+
+	db, err := Open(path, Options{Sync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket := db.Bucket("sessions")
+	bucket.Put("kept", []byte("yes"), 0)
+	bucket.Put("deleted", []byte("no"), 0)
+	bucket.Delete("deleted")
+	bucket.Put("replaced", []byte("old"), 0)
+	bucket.Put("replaced", []byte("new"), time.Hour)
+	db.Close()
+
+	// A crash in the middle of a write leaves a torn line behind.
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	file.WriteString(`{"b":"sessions","k":"torn"`)
+	file.Close()
+
+	tests := []struct {
+		key   string
+		value string
+		err   error
+	}{
+		{"kept", "yes", nil},
+		{"deleted", "", ErrNotFound},
+		{"replaced", "new", nil},
+		{"torn", "", ErrNotFound},
+	}
+
+	reopened := open(t, path, Options{})
+	for _, test := range tests {
+		value, err := reopened.Bucket("sessions").Get(test.key)
+		if err != test.err || string(value) != test.value {
+			t.Errorf("%s: Get = %q, %v; want %q, %v", test.key, value, err, test.value, test.err)
+		}
+	}
+
+	if err := reopened.Bucket("sessions").Put("after", []byte("1"), 0); err != nil {
+		t.Fatal(err)
+	}
+	reopened.Close()
+
+	again := open(t, path, Options{})
+	if value, err := again.Bucket("sessions").Get("after"); err != nil || string(value) != "1" {
+		t.Errorf("write after a torn line lost: %q, %v", value, err)
+	}
+}
+
+func TestCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.log")
+	db := open(t, path, Options{CompactAbove: 1024})
+	bucket := db.Bucket("counters")
+
+	for i := 0; i < 1000; i++ {
+		bucket.Put("hot", []byte(strconv.Itoa(i)), 0)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 4096 {
+		t.Errorf("log is %d bytes after rewriting one key; want it compacted", info.Size())
+	}
+
+	db.Close()
+	if value, _ := open(t, path, Options{}).Bucket("counters").Get("hot"); string(value) != "999" {
+		t.Errorf("compacted value = %q; want %q", value, "999")
+	}
+}
+
+func TestIdempotency(t *testing.T) {
+	ctx := context.Background()
+	store := NewIdempotency(open(t, "", Options{}).Bucket("idempotency"))
+
+	if err := store.Reserve(ctx, "k", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Reserve(ctx, "k", time.Minute); err != idempotency.ErrInProgress {
+		t.Errorf("second Reserve = %v; want ErrInProgress", err)
+	}
+	if record, _ := store.Get(ctx, "k"); record != nil {
+		t.Errorf("Get of a reservation = %+v; want nil", record)
+	}
+
+	store.Save(ctx, "k", &idempotency.Record{Status: 201, Body: []byte("done")}, time.Hour)
+	store.Release(ctx, "k")
+
+	record, err := store.Get(ctx, "k")
+	if err != nil || record == nil || record.Status != 201 || string(record.Body) != "done" {
+		t.Errorf("Get = %+v, %v; want the saved record", record, err)
+	}
+
+	store.Reserve(ctx, "r", time.Minute)
+	store.Release(ctx, "r")
+	if err := store.Reserve(ctx, "r", time.Minute); err != nil {
+		t.Errorf("Reserve after Release = %v", err)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	store := NewSessions(open(t, "", Options{}).Bucket("sessions"))
+	now := time.Now()
+
+	for _, item := range []*session.Session{
+		{ID: "1", Subject: "alice", Expires: now.Add(time.Hour)},
+		{ID: "2", Subject: "alice", Expires: now.Add(time.Hour)},
+		{ID: "3", Subject: "bob", Expires: now.Add(time.Hour)},
+		{ID: "4", Subject: "bob", Expires: now.Add(-time.Second)},
+	} {
+		if err := store.Save(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		id      string
+		subject string
+		err     error
+	}{
+		{"1", "alice", nil},
+		{"3", "bob", nil},
+		{"4", "", session.ErrNoSession},
+		{"5", "", session.ErrNoSession},
+	}
+
+	for _, test := range tests {
+		stored, err := store.Get(test.id)
+		if err != test.err || (err == nil && stored.Subject != test.subject) {
+			t.Errorf("Get(%s) = %+v, %v; want subject %q, %v", test.id, stored, err, test.subject, test.err)
+		}
+	}
+
+	if err := store.Touch("3", now, now.Add(2*time.Hour)); err != nil {
+		t.Errorf("Touch = %v", err)
+	}
+	if err := store.Touch("5", now, now.Add(time.Hour)); err != session.ErrNoSession {
+		t.Errorf("Touch of a missing session = %v; want ErrNoSession", err)
+	}
+
+	if count, err := store.DeleteSubject("alice"); err != nil || count != 2 {
+		t.Errorf("DeleteSubject = %d, %v; want 2", count, err)
+	}
+	if _, err := store.Get("1"); err != session.ErrNoSession {
+		t.Errorf("Get after DeleteSubject = %v", err)
+	}
+}
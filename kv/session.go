@@ -0,0 +1,100 @@
+package kv
+
+import (
+	"encoding/json"
+	"time"
+
+	"devops.io/cloud/session"
+)
+
+// Sessions is a session.Store kept in a bucket, so logins survive
+// restarts. Sessions expire with the bucket keys.
+type Sessions struct {
+	bucket *Bucket
+}
+
+// NewSessions stores sessions in bucket.
+func NewSessions(bucket *Bucket) *Sessions {
+	return &Sessions{bucket: bucket}
+}
+
+// Get implements session.Store.
+func (s *Sessions) Get(id string) (*session.Session, error) {
+	value, err := s.bucket.Get(id)
+	if err == ErrNotFound {
+		return nil, session.ErrNoSession
+	} else if err != nil {
+		return nil, err
+	}
+
+	stored := &session.Session{}
+	if err := json.Unmarshal(value, stored); err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// Save implements session.Store.
+func (s *Sessions) Save(stored *session.Session) error {
+	ttl := time.Until(stored.Expires)
+	if ttl <= 0 {
+		return s.bucket.Delete(stored.ID)
+	}
+
+	value, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	return s.bucket.Put(stored.ID, value, ttl)
+}
+
+// Touch implements session.Store.
+func (s *Sessions) Touch(id string, lastSeen, expires time.Time) error {
+	return s.bucket.Update(id, func(value []byte) ([]byte, time.Duration, error) {
+		if value == nil {
+			return nil, 0, session.ErrNoSession
+		}
+
+		stored := &session.Session{}
+		if err := json.Unmarshal(value, stored); err != nil {
+			return nil, 0, err
+		}
+
+		ttl := time.Until(expires)
+		if ttl <= 0 {
+			return nil, 0, nil
+		}
+
+		stored.LastSeen, stored.Expires = lastSeen, expires
+		value, err := json.Marshal(stored)
+		return value, ttl, err
+	})
+}
+
+// Delete implements session.Store.
+func (s *Sessions) Delete(id string) error {
+	return s.bucket.Delete(id)
+}
+
+// DeleteSubject implements session.Store. It scans every session, which
+// suits the small stores this package is meant for.
+func (s *Sessions) DeleteSubject(subject string) (int, error) {
+	var ids []string
+	err := s.bucket.Scan("", func(id string, value []byte) bool {
+		var stored session.Session
+		if json.Unmarshal(value, &stored) == nil && stored.Subject == subject {
+			ids = append(ids, id)
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		if err := s.bucket.Delete(id); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), nil
+}
@@ -0,0 +1,70 @@
+package limits
+
+import (
+	"time"
+
+	"devops.io/cloud/config"
+)
+
+// Configure registers the defaults and validation rules of the server
+// limits, with keys under prefix, e.g. "server". Durations of "-1s"
+// disable a timeout.
+//
+//	<prefix>.max_header_bytes     request line and headers
+//	<prefix>.max_body_bytes       request body, up to 1 GiB
+//	<prefix>.read_header_timeout
+//	<prefix>.read_timeout
+//	<prefix>.write_timeout
+//	<prefix>.idle_timeout
+func Configure(cfg *config.Config, prefix string) {
+	defaults := Options{}.defaults()
+
+	cfg.SetDefault(prefix+".max_header_bytes", defaults.MaxHeaderBytes)
+	cfg.SetDefault(prefix+".max_body_bytes", int(defaults.MaxBodyBytes))
+	cfg.SetDefault(prefix+".read_header_timeout", defaults.ReadHeaderTimeout.String())
+	cfg.SetDefault(prefix+".read_timeout", defaults.ReadTimeout.String())
+	cfg.SetDefault(prefix+".write_timeout", defaults.WriteTimeout.String())
+	cfg.SetDefault(prefix+".idle_timeout", defaults.IdleTimeout.String())
+
+	cfg.AddRule(
+		config.IsInt(prefix+".max_header_bytes", 1<<10, 1<<20),
+		config.IsInt(prefix+".max_body_bytes", 1, 1<<30),
+		config.IsDuration(prefix+".read_header_timeout"),
+		config.IsDuration(prefix+".read_timeout"),
+		config.IsDuration(prefix+".write_timeout"),
+		config.IsDuration(prefix+".idle_timeout"),
+	)
+}
+
+// FromConfig reads the options registered by Configure.
+func FromConfig(cfg *config.Config, prefix string) (Options, error) {
+	var opts Options
+	var err error
+
+	if opts.MaxHeaderBytes, err = cfg.Int(prefix + ".max_header_bytes"); err != nil {
+		return Options{}, err
+	}
+
+	body, err := cfg.Int(prefix + ".max_body_bytes")
+	if err != nil {
+		return Options{}, err
+	}
+	opts.MaxBodyBytes = int64(body)
+
+	timeouts := []struct {
+		key    string
+		target *time.Duration
+	}{
+		{".read_header_timeout", &opts.ReadHeaderTimeout},
+		{".read_timeout", &opts.ReadTimeout},
+		{".write_timeout", &opts.WriteTimeout},
+		{".idle_timeout", &opts.IdleTimeout},
+	}
+
+	for _, timeout := range timeouts {
+		if *timeout.target, err = cfg.Duration(prefix + timeout.key); err != nil {
+			return Options{}, err
+		}
+	}
+	return opts, nil
+}
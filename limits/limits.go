@@ -0,0 +1,123 @@
+// Package limits bounds what a client may send and how slowly it may send
+// it: header and body sizes, and the time allowed for each phase of a
+// connection. Slow clients holding connections open (slowloris) run into
+// the header timeout before any handler runs.
+package limits
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Options configures the limits. Zero fields take the defaults below;
+// negative ones disable the limit.
+type Options struct {
+	// MaxHeaderBytes bounds the request line and headers; it defaults to
+	// 64 KiB. When disabled, net/http's own 1 MiB applies.
+	MaxHeaderBytes int
+
+	// MaxBodyBytes bounds request bodies; larger ones are answered with
+	// 413. It defaults to 10 MiB.
+	MaxBodyBytes int64
+
+	// ReadHeaderTimeout bounds the time to read the headers; it defaults
+	// to 10 seconds.
+	ReadHeaderTimeout time.Duration
+
+	// ReadTimeout bounds the time to read a whole request, body
+	// included; it defaults to one minute.
+	ReadTimeout time.Duration
+
+	// WriteTimeout bounds the time from the end of the headers to the
+	// end of the response; it defaults to two minutes. Disable it for
+	// servers with long-lived streams.
+	WriteTimeout time.Duration
+
+	// IdleTimeout bounds how long a keep-alive connection waits for the
+	// next request; it defaults to two minutes.
+	IdleTimeout time.Duration
+}
+
+func (o Options) defaults() Options {
+	if o.MaxHeaderBytes == 0 {
+		o.MaxHeaderBytes = 64 << 10
+	}
+	if o.MaxBodyBytes == 0 {
+		o.MaxBodyBytes = 10 << 20
+	}
+	if o.ReadHeaderTimeout == 0 {
+		o.ReadHeaderTimeout = 10 * time.Second
+	}
+	if o.ReadTimeout == 0 {
+		o.ReadTimeout = time.Minute
+	}
+	if o.WriteTimeout == 0 {
+		o.WriteTimeout = 2 * time.Minute
+	}
+	if o.IdleTimeout == 0 {
+		o.IdleTimeout = 2 * time.Minute
+	}
+	return o
+}
+
+// Apply sets the limits on server and wraps its handler with Middleware.
+// It must be called before the server starts.
+func Apply(server *http.Server, opts Options) {
+	opts = opts.defaults()
+
+	if opts.MaxHeaderBytes > 0 {
+		server.MaxHeaderBytes = opts.MaxHeaderBytes
+	}
+	server.ReadHeaderTimeout = positive(opts.ReadHeaderTimeout)
+	server.ReadTimeout = positive(opts.ReadTimeout)
+	server.WriteTimeout = positive(opts.WriteTimeout)
+	server.IdleTimeout = positive(opts.IdleTimeout)
+
+	handler := server.Handler
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	server.Handler = Middleware(opts)(handler)
+}
+
+// Middleware enforces MaxBodyBytes. Bodies announcing a larger
+// Content-Length are answered with 413 before the handler runs; others
+// are cut off at the limit, and TooLarge tells the handler why its read
+// failed.
+func Middleware(opts Options) func(http.Handler) http.Handler {
+	opts = opts.defaults()
+
+	return func(next http.Handler) http.Handler {
+		if opts.MaxBodyBytes < 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > opts.MaxBodyBytes {
+				w.Header().Set("Connection", "close")
+				http.Error(w, "request body too large, limit is "+strconv.FormatInt(opts.MaxBodyBytes, 10)+" bytes",
+					http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			r.Body = http.MaxBytesReader(w, r.Body, opts.MaxBodyBytes)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// TooLarge reports whether err comes from reading past MaxBodyBytes; the
+// handler should then answer 413.
+func TooLarge(err error) bool {
+	return err != nil && err.Error() == "http: request body too large"
+}
+
+// positive turns disabled (negative) limits into the zero that
+// http.Server reads as none.
+func positive(value time.Duration) time.Duration {
+	if value < 0 {
+		return 0
+	}
+	return value
+}
@@ -0,0 +1,139 @@
+package limits
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"devops.io/cloud/config"
+)
+
+func TestMiddleware(t *testing.T) {
+	handler := Middleware(Options{MaxBodyBytes: 8})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); TooLarge(err) {
+			http.Error(w, "too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name    string
+		body    string
+		chunked bool
+		code    int
+	}{
+		{"within", "12345678", false, http.StatusNoContent},
+		{"announced too large", "123456789", false, http.StatusRequestEntityTooLarge},
+		{"streamed too large", "123456789", true, http.StatusRequestEntityTooLarge},
+		{"empty", "", false, http.StatusNoContent},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body))
+		if test.chunked {
+			request.ContentLength = -1
+		}
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		if recorder.Code != test.code {
+			t.Errorf("%s: status = %d; want %d", test.name, recorder.Code, test.code)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   Options
+		header int
+		read   time.Duration
+		idle   time.Duration
+	}{
+		{"defaults", Options{}, 64 << 10, time.Minute, 2 * time.Minute},
+		{"configured", Options{MaxHeaderBytes: 4 << 10, ReadTimeout: time.Second, IdleTimeout: time.Second}, 4 << 10, time.Second, time.Second},
+		{"disabled", Options{MaxHeaderBytes: -1, ReadTimeout: -1, IdleTimeout: -1}, 0, 0, 0},
+	}
+
+	for _, test := range tests {
+		server := &http.Server{}
+		Apply(server, test.opts)
+
+		if server.MaxHeaderBytes != test.header || server.ReadTimeout != test.read || server.IdleTimeout != test.idle {
+			t.Errorf("%s: header %d, read %v, idle %v; want %d, %v, %v", test.name,
+				server.MaxHeaderBytes, server.ReadTimeout, server.IdleTimeout, test.header, test.read, test.idle)
+		}
+		if server.ReadHeaderTimeout <= 0 {
+			t.Errorf("%s: no header timeout against slow clients", test.name)
+		}
+		if server.Handler == nil {
+			t.Errorf("%s: handler not wrapped", test.name)
+		}
+	}
+}
+
+func TestSlowHeaders(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	Apply(server.Config, Options{ReadHeaderTimeout: 50 * time.Millisecond})
+	server.Start()
+	defer server.Close()
+
+	conn, err := (&net.Dialer{}).Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Never finish the headers; the server must hang up.
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\n")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Errorf("connection not closed by the server: %v", err)
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  map[string]string
+		body    int64
+		write   time.Duration
+		invalid bool
+	}{
+		{name: "defaults", body: 10 << 20, write: 2 * time.Minute},
+		{name: "configured", values: map[string]string{"server.max_body_bytes": "1024", "server.write_timeout": "-1s"}, body: 1024, write: -time.Second},
+		{name: "out of range", values: map[string]string{"server.max_header_bytes": "10"}, invalid: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := config.New("app")
+			Configure(cfg, "server")
+			for key, value := range test.values {
+				cfg.Set(config.File, key, value)
+			}
+
+			if err := cfg.Validate(); (err != nil) != test.invalid {
+				t.Fatalf("Validate = %v", err)
+			}
+			if test.invalid {
+				return
+			}
+
+			opts, err := FromConfig(cfg, "server")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opts.MaxBodyBytes != test.body || opts.WriteTimeout != test.write {
+				t.Errorf("body %d, write %v; want %d, %v", opts.MaxBodyBytes, opts.WriteTimeout, test.body, test.write)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+// Package loadshed rejects excess requests early with 503 when an
+// endpoint is overloaded, so one hot endpoint can't degrade the whole
+// server. Each endpoint has a concurrency limit that shrinks while its
+// p99 latency exceeds the configured bound and grows back once it
+// recovers.
+package loadshed
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Options configures a Shedder.
+type Options struct {
+	// MaxInFlight is the concurrency limit of an endpoint when healthy;
+	// it defaults to 100.
+	MaxInFlight int
+
+	// MinInFlight is how far the limit may shrink; it defaults to 1.
+	MinInFlight int
+
+	// MaxLatency is the p99 latency bound; zero disables adaptation and
+	// only MaxInFlight applies.
+	MaxLatency time.Duration
+
+	// Window is how often the limit is adjusted from the latencies seen
+	// since the last adjustment; it defaults to one second.
+	Window time.Duration
+
+	// RetryAfter is advertised to rejected clients; it defaults to one
+	// second.
+	RetryAfter time.Duration
+
+	// Endpoint groups requests; it defaults to the method and path.
+	// Pass the route pattern when paths carry IDs.
+	Endpoint func(*http.Request) string
+
+	// MaxEndpoints bounds how many endpoints besides Overflow are
+	// tracked, since clients choose the paths; it defaults to 1000. When
+	// full, the longest idle endpoint is forgotten, and requests share
+	// the Overflow endpoint while every tracked one is busy.
+	MaxEndpoints int
+
+	// Share returns the fraction of the limit a request may fill, so
+	// less urgent requests are shed first and leave headroom for the
+	// others, e.g. priority.Share. By default every request may use the
+	// whole limit.
+	Share func(*http.Request) float64
+}
+
+// Stats describes one endpoint.
+type Stats struct {
+	InFlight int
+	Limit    int
+	P99      time.Duration
+	Shed     int64
+}
+
+// Overflow is the endpoint of requests that find every tracked endpoint
+// busy once MaxEndpoints is reached.
+const Overflow = "overflow"
+
+// maxSamples bounds the latencies kept per window; a busier window keeps
+// the most recent ones.
+const maxSamples = 1024
+
+type endpoint struct {
+	inFlight int
+	limit    int
+	p99      time.Duration
+	shed     int64
+	samples  []time.Duration
+	next     int
+	adjusted time.Time
+	used     time.Time
+}
+
+// Shedder tracks load per endpoint.
+type Shedder struct {
+	opts      Options
+	mu        sync.Mutex
+	endpoints map[string]*endpoint
+}
+
+// New creates a shedder.
+func New(opts Options) *Shedder {
+	if opts.MaxInFlight <= 0 {
+		opts.MaxInFlight = 100
+	}
+	if opts.MinInFlight <= 0 {
+		opts.MinInFlight = 1
+	}
+	if opts.MinInFlight > opts.MaxInFlight {
+		opts.MinInFlight = opts.MaxInFlight
+	}
+	if opts.Window <= 0 {
+		opts.Window = time.Second
+	}
+	if opts.RetryAfter <= 0 {
+		opts.RetryAfter = time.Second
+	}
+	if opts.MaxEndpoints <= 0 {
+		opts.MaxEndpoints = 1000
+	}
+	if opts.Endpoint == nil {
+		opts.Endpoint = func(r *http.Request) string {
+			return r.Method + " " + r.URL.Path
+		}
+	}
+
+	return &Shedder{opts: opts, endpoints: make(map[string]*endpoint)}
+}
+
+// Middleware answers 503 with Retry-After to requests over the limit of
+// their endpoint.
+func (s *Shedder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := s.opts.Endpoint(r)
+
+		share := 1.0
+		if s.opts.Share != nil {
+			share = s.opts.Share(r)
+		}
+
+		name, ok := s.acquire(name, share)
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int((s.opts.RetryAfter+time.Second-1)/time.Second)))
+			http.Error(w, "server overloaded", http.StatusServiceUnavailable)
+			return
+		}
+
+		start := time.Now()
+		defer func() {
+			s.release(name, time.Since(start))
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Stats returns the state of every endpoint seen so far.
+func (s *Shedder) Stats() map[string]Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make(map[string]Stats, len(s.endpoints))
+	for name, e := range s.endpoints {
+		stats[name] = Stats{InFlight: e.inFlight, Limit: e.limit, P99: e.p99, Shed: e.shed}
+	}
+	return stats
+}
+
+// acquire takes a slot of the endpoint name, or of Overflow when name
+// can't be tracked, and returns the endpoint release must be given.
+func (s *Shedder) acquire(name string, share float64) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	e, ok := s.endpoints[name]
+	if !ok {
+		if len(s.endpoints) >= s.opts.MaxEndpoints && !s.evict() {
+			name = Overflow
+			e = s.endpoints[name]
+		}
+
+		if e == nil {
+			e = &endpoint{limit: s.opts.MaxInFlight, adjusted: now}
+			s.endpoints[name] = e
+		}
+	}
+	e.used = now
+
+	limit := e.limit
+	if share > 0 && share < 1 {
+		limit = int(math.Ceil(float64(limit) * share))
+	}
+
+	if e.inFlight >= limit {
+		e.shed++
+		return name, false
+	}
+
+	e.inFlight++
+	return name, true
+}
+
+// evict forgets the endpoint idle for the longest time and reports
+// whether there was an idle one.
+func (s *Shedder) evict() bool {
+	var oldest string
+	var found *endpoint
+
+	for name, e := range s.endpoints {
+		if e.inFlight > 0 || name == Overflow {
+			continue
+		}
+		if found == nil || e.used.Before(found.used) {
+			oldest, found = name, e
+		}
+	}
+
+	if found == nil {
+		return false
+	}
+
+	delete(s.endpoints, oldest)
+	return true
+}
+
+func (s *Shedder) release(name string, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.endpoints[name]
+	e.inFlight--
+
+	if s.opts.MaxLatency <= 0 {
+		return
+	}
+
+	if len(e.samples) < maxSamples {
+		e.samples = append(e.samples, latency)
+	} else {
+		e.samples[e.next] = latency
+		e.next = (e.next + 1) % maxSamples
+	}
+
+	if now := time.Now(); now.Sub(e.adjusted) >= s.opts.Window {
+		s.adjust(e)
+		e.adjusted = now
+	}
+}
+
+// adjust updates the limit from the window's samples: it is cut by a
+// quarter while p99 is over the bound and raised by a tenth otherwise.
+func (s *Shedder) adjust(e *endpoint) {
+	sort.Slice(e.samples, func(i, j int) bool {
+		return e.samples[i] < e.samples[j]
+	})
+
+	e.p99 = e.samples[len(e.samples)*99/100]
+	e.samples = e.samples[:0]
+	e.next = 0
+
+	if e.p99 > s.opts.MaxLatency {
+		e.limit -= (e.limit + 3) / 4
+		if e.limit < s.opts.MinInFlight {
+			e.limit = s.opts.MinInFlight
+		}
+		return
+	}
+
+	e.limit += e.limit/10 + 1
+	if e.limit > s.opts.MaxInFlight {
+		e.limit = s.opts.MaxInFlight
+	}
+}
@@ -0,0 +1,173 @@
+package loadshed
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hold runs n requests to path in the background; done tracks them.
+func hold(handler http.Handler, path string, n int, done *sync.WaitGroup) {
+	for i := 0; i < n; i++ {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+		}()
+	}
+}
+
+func TestLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		max     int
+		share   float64
+		held    int
+		allowed bool
+	}{
+		{"under the limit", 3, 1, 2, true},
+		{"at the limit", 3, 1, 3, false},
+		{"half share", 4, 0.5, 2, false},
+		{"half share with room", 4, 0.5, 1, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := New(Options{MaxInFlight: test.max})
+			entered := make(chan struct{}, test.held)
+			release := make(chan struct{})
+
+			blocking := s.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				entered <- struct{}{}
+				<-release
+			}))
+
+			var done sync.WaitGroup
+			hold(blocking, "/jobs", test.held, &done)
+			for i := 0; i < test.held; i++ {
+				<-entered
+			}
+
+			share := test.share
+			s.opts.Share = func(*http.Request) float64 { return share }
+
+			w := httptest.NewRecorder()
+			s.Middleware(http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest("GET", "/jobs", nil))
+
+			if allowed := w.Code != http.StatusServiceUnavailable; allowed != test.allowed {
+				t.Errorf("allowed = %v; want %v", allowed, test.allowed)
+			}
+			if !test.allowed && w.Header().Get("Retry-After") != "1" {
+				t.Errorf("Retry-After = %q", w.Header().Get("Retry-After"))
+			}
+
+			close(release)
+			done.Wait()
+
+			if stats := s.Stats()["GET /jobs"]; stats.InFlight != 0 {
+				t.Errorf("in flight after release = %d", stats.InFlight)
+			}
+		})
+	}
+}
+
+func TestAdapt(t *testing.T) {
+	tests := []struct {
+		name    string
+		latency time.Duration
+		limit   int
+		want    int
+	}{
+		{"slow shrinks", 50 * time.Millisecond, 100, 75},
+		{"floor", 50 * time.Millisecond, 2, 2},
+		{"fast grows", time.Millisecond, 50, 56},
+		{"ceiling", time.Millisecond, 100, 100},
+	}
+
+	for _, test := range tests {
+		s := New(Options{MaxInFlight: 100, MinInFlight: 2, MaxLatency: 10 * time.Millisecond})
+		e := &endpoint{limit: test.limit}
+		for i := 0; i < 10; i++ {
+			e.samples = append(e.samples, test.latency)
+		}
+
+		s.adjust(e)
+		if e.limit != test.want || e.p99 != test.latency || len(e.samples) != 0 {
+			t.Errorf("%s: limit = %d, p99 = %s; want %d, %s", test.name, e.limit, e.p99, test.want, test.latency)
+		}
+	}
+}
+
+func TestSamplesBounded(t *testing.T) {
+	s := New(Options{MaxLatency: time.Second, Window: time.Hour})
+	name, _ := s.acquire("GET /", 1)
+
+	for i := 0; i < 3*maxSamples; i++ {
+		s.endpoints[name].inFlight++
+		s.release(name, time.Duration(i))
+	}
+
+	if n := len(s.endpoints[name].samples); n != maxSamples {
+		t.Errorf("%d samples kept; want %d", n, maxSamples)
+	}
+}
+
+func TestEndpointsBounded(t *testing.T) {
+	s := New(Options{MaxEndpoints: 3, MaxInFlight: 1})
+	release := make(chan struct{})
+	entered := make(chan struct{}, 3)
+	var done sync.WaitGroup
+
+	blocking := s.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/idle" {
+			entered <- struct{}{}
+			<-release
+		}
+	}))
+
+	blocking.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/idle", nil))
+	hold(blocking, "/a", 1, &done)
+	hold(blocking, "/b", 1, &done)
+	<-entered
+	<-entered
+
+	// Full: the idle endpoint makes room for /c.
+	hold(blocking, "/c", 1, &done)
+	<-entered
+
+	tests := []struct {
+		name    string
+		present bool
+	}{
+		{"GET /idle", false},
+		{"GET /a", true},
+		{"GET /b", true},
+		{"GET /c", true},
+	}
+
+	stats := s.Stats()
+	for _, test := range tests {
+		if _, ok := stats[test.name]; ok != test.present {
+			t.Errorf("%s tracked = %v; want %v", test.name, ok, test.present)
+		}
+	}
+
+	// Every tracked endpoint is busy, so new paths share Overflow.
+	for i := 0; i < 50; i++ {
+		s.Middleware(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", fmt.Sprintf("/x/%d", i), nil))
+	}
+
+	stats = s.Stats()
+	if len(stats) != 4 {
+		t.Errorf("%d endpoints tracked; want 3 and overflow", len(stats))
+	}
+	if _, ok := stats[Overflow]; !ok {
+		t.Error("no overflow endpoint")
+	}
+
+	close(release)
+	done.Wait()
+}
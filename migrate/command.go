@@ -0,0 +1,103 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+)
+
+// ErrUsage is returned by Command for malformed arguments.
+var ErrUsage = errors.New("usage: migrate up | down [steps] | to <version> | status | verify")
+
+// Command runs a migrate subcommand, so a binary can expose migrations
+// next to running them on startup:
+//
+//	up               apply every pending migration
+//	down [steps]     roll back the last steps migrations, one by default
+//	to <version>     migrate up or down to version
+//	status           list migrations and whether they were applied
+//	verify           check applied migrations against their scripts
+func Command(ctx context.Context, m *Migrator, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return ErrUsage
+	}
+
+	switch args[0] {
+	case "up":
+		done, err := m.Up(ctx)
+		report(out, "applied", done, err)
+		return err
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			parsed, err := strconv.Atoi(args[1])
+			if err != nil || parsed <= 0 {
+				return ErrUsage
+			}
+			steps = parsed
+		}
+
+		done, err := m.Down(ctx, steps)
+		report(out, "reverted", done, err)
+		return err
+
+	case "to":
+		if len(args) < 2 {
+			return ErrUsage
+		}
+
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || version < 0 {
+			return ErrUsage
+		}
+
+		done, err := m.To(ctx, version)
+		report(out, "migrated", done, err)
+		return err
+
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		table := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(table, "VERSION\tNAME\tAPPLIED\tNOTE")
+
+		for _, status := range statuses {
+			applied, note := "-", ""
+			if status.Applied {
+				applied = status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if status.Modified {
+				note = "modified since applied"
+			}
+			fmt.Fprintf(table, "%d\t%s\t%s\t%s\n", status.Version, status.Name, applied, note)
+		}
+		return table.Flush()
+
+	case "verify":
+		if err := m.Verify(ctx); err != nil {
+			return err
+		}
+
+		fmt.Fprintln(out, "all applied migrations match their scripts")
+		return nil
+	}
+	return ErrUsage
+}
+
+func report(out io.Writer, verb string, migrations []Migration, err error) {
+	if len(migrations) == 0 && err == nil {
+		fmt.Fprintln(out, "nothing to do")
+		return
+	}
+
+	for _, migration := range migrations {
+		fmt.Fprintf(out, "%s %d_%s\n", verb, migration.Version, migration.Name)
+	}
+}
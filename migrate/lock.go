@@ -0,0 +1,180 @@
+package migrate
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// lockPoll is how often a waiting instance retries a held lock row.
+var lockPoll = time.Second
+
+// Lock keeps two instances sharing a database from migrating at once. It
+// returns once the lock is held, with the function releasing it. held is
+// derived from ctx and is cancelled if the lock is lost, which stops the
+// migration with ErrLockLost.
+type Lock func(ctx context.Context, db *sql.DB) (held context.Context, unlock func(), err error)
+
+// PostgresLock takes a session-level advisory lock on key. PostgreSQL
+// releases it by itself if the instance dies while migrating.
+func PostgresLock(key int64) Lock {
+	return func(ctx context.Context, db *sql.DB) (context.Context, func(), error) {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", key); err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("migrate: lock: %w", err)
+		}
+
+		return ctx, func() {
+			conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key)
+			conn.Close()
+		}, nil
+	}
+}
+
+// MySQLLock takes the named lock of GET_LOCK, which MySQL releases by
+// itself if the instance dies while migrating.
+func MySQLLock(name string) Lock {
+	return func(ctx context.Context, db *sql.DB) (context.Context, func(), error) {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var held sql.NullInt64
+		if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, -1)", name).Scan(&held); err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("migrate: lock: %w", err)
+		} else if held.Int64 != 1 {
+			conn.Close()
+			return nil, nil, errors.New("migrate: lock: GET_LOCK failed")
+		}
+
+		return ctx, func() {
+			conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", name)
+			conn.Close()
+		}, nil
+	}
+}
+
+// rowLock is the default Lock: a single row in Table + "_lock", inserted
+// by the holder. The holder refreshes the row every Stale/3 while it
+// migrates; a row left unrefreshed for Stale belongs to a crashed instance
+// and is taken over. The holder gives up as lost once its row is gone or
+// no refresh succeeded for Stale.
+func (m *Migrator) rowLock(ctx context.Context, db *sql.DB) (context.Context, func(), error) {
+	table := m.opts.Table + "_lock"
+	p := m.opts.Placeholder
+
+	// Replicas may race to create the table, and all but one can fail
+	// doing so on some engines; the insert below reports real problems.
+	db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+table+" ("+
+		"id INTEGER PRIMARY KEY, "+
+		"holder VARCHAR(32) NOT NULL, "+
+		"refreshed_at TIMESTAMP NOT NULL)")
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return nil, nil, err
+	}
+	holder := hex.EncodeToString(random)
+
+	insert := fmt.Sprintf("INSERT INTO %s (id, holder, refreshed_at) VALUES (1, %s, %s)", table, p(1), p(2))
+	for {
+		_, err := db.ExecContext(ctx, insert, holder, time.Now().UTC())
+		if err == nil {
+			break
+		}
+
+		var refreshed time.Time
+		switch err := db.QueryRowContext(ctx, "SELECT refreshed_at FROM "+table+" WHERE id = 1").Scan(&refreshed); {
+		case errors.Is(err, sql.ErrNoRows):
+			// Released in between.
+			continue
+		case err != nil:
+			return nil, nil, fmt.Errorf("migrate: lock: %w", err)
+		}
+
+		if time.Since(refreshed) > m.opts.Stale {
+			db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE id = 1 AND refreshed_at = %s", table, p(1)), refreshed)
+			continue
+		}
+
+		timer := time.NewTimer(lockPoll)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	held, lost := context.WithCancel(ctx)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		refresh := fmt.Sprintf("UPDATE %s SET refreshed_at = %s WHERE id = 1 AND holder = %s", table, p(1), p(2))
+		interval := m.opts.Stale / 3
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		refreshed := time.Now()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			attempt, cancel := context.WithTimeout(context.Background(), interval)
+			result, err := db.ExecContext(attempt, refresh, time.Now().UTC(), holder)
+			cancel()
+
+			if err == nil {
+				if rows, err := result.RowsAffected(); err == nil && rows != 1 {
+					// Taken over by another instance.
+					lost()
+					return
+				}
+				refreshed = time.Now()
+			} else if time.Since(refreshed) >= m.opts.Stale {
+				lost()
+				return
+			}
+		}
+	}()
+
+	return held, func() {
+		close(stop)
+		<-done
+		lost()
+		db.ExecContext(context.Background(), fmt.Sprintf("DELETE FROM %s WHERE id = 1 AND holder = %s", table, p(1)), holder)
+	}, nil
+}
+
+// locked runs fn while holding the migration lock, with a context that
+// ends if the lock is lost.
+func (m *Migrator) locked(ctx context.Context, fn func(ctx context.Context) ([]Migration, error)) ([]Migration, error) {
+	held, unlock, err := m.opts.Lock(ctx, m.db)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	done, err := fn(held)
+	if err != nil && held.Err() != nil && ctx.Err() == nil {
+		return done, ErrLockLost
+	}
+	return done, err
+}
@@ -0,0 +1,350 @@
+// Package migrate applies versioned SQL migrations through database/sql.
+// Applied versions are recorded with the checksum of their up script, so
+// a migration edited after it ran is detected instead of silently
+// diverging between environments.
+//
+// Each migration runs in a transaction together with its bookkeeping
+// row. That makes it atomic only where DDL is transactional, as on
+// PostgreSQL and SQLite: MySQL and MariaDB commit implicitly around every
+// DDL statement, so a script failing halfway there leaves its earlier
+// statements applied and unrecorded, and must be repaired by hand.
+//
+// Up, Down and To hold a lock for their whole run, so replicas that
+// migrate on startup together apply each script once.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrNoDown is returned when rolling back a migration without a
+	// down script.
+	ErrNoDown = errors.New("migrate: migration has no down script")
+
+	// ErrUnknownVersion is returned for target versions that don't
+	// exist, and for applied versions missing from the source.
+	ErrUnknownVersion = errors.New("migrate: unknown version")
+
+	// ErrLockLost is returned when the migration lock was lost while
+	// migrating; the script running then was aborted.
+	ErrLockLost = errors.New("migrate: lock lost")
+)
+
+// ChecksumError reports an applied migration whose script changed.
+type ChecksumError struct {
+	Version  int64
+	Name     string
+	Applied  string
+	Expected string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("migrate: %d_%s changed after it was applied", e.Version, e.Name)
+}
+
+// Options configures a Migrator.
+type Options struct {
+	// Table records applied versions; it defaults to
+	// "schema_migrations".
+	Table string
+
+	// Placeholder renders the n-th (1-based) bind parameter; it defaults
+	// to "?". Use Dollar for PostgreSQL.
+	Placeholder func(n int) string
+
+	// Lock serializes Up, Down and To across instances. It defaults to
+	// a row in Table + "_lock"; PostgresLock and MySQLLock are released
+	// by the database when an instance dies.
+	Lock Lock
+
+	// Stale is how long the default lock row may go unrefreshed before
+	// it counts as abandoned; it defaults to one minute. The row is
+	// refreshed beside the running script, but where writers are
+	// serialized, as on SQLite, refreshes wait for the script: Stale
+	// must then exceed the longest one.
+	Stale time.Duration
+}
+
+// Dollar renders PostgreSQL-style placeholders.
+func Dollar(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// Status describes one migration.
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	Modified  bool
+}
+
+// Migrator applies a set of migrations to a database.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+	opts       Options
+}
+
+type record struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// New creates a migrator for migrations, as returned by Load.
+func New(db *sql.DB, migrations []Migration, opts Options) *Migrator {
+	if len(opts.Table) == 0 {
+		opts.Table = "schema_migrations"
+	}
+	if opts.Placeholder == nil {
+		opts.Placeholder = func(int) string {
+			return "?"
+		}
+	}
+	if opts.Stale <= 0 {
+		opts.Stale = time.Minute
+	}
+
+	m := &Migrator{db: db, migrations: migrations, opts: opts}
+	if m.opts.Lock == nil {
+		m.opts.Lock = m.rowLock
+	}
+	return m
+}
+
+// Up verifies the applied migrations and applies every pending one, in
+// version order. It returns the migrations it applied.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	return m.locked(ctx, func(ctx context.Context) ([]Migration, error) {
+		return m.up(ctx)
+	})
+}
+
+func (m *Migrator) up(ctx context.Context) ([]Migration, error) {
+	applied, err := m.verified(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		if err := m.apply(ctx, migration); err != nil {
+			return done, err
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// Down rolls back the last steps applied migrations, newest first.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	return m.locked(ctx, func(ctx context.Context) ([]Migration, error) {
+		return m.down(ctx, steps)
+	})
+}
+
+func (m *Migrator) down(ctx context.Context, steps int) ([]Migration, error) {
+	applied, err := m.verified(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+
+		if err := m.revert(ctx, migration); err != nil {
+			return done, err
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// To migrates up or down until version is the newest applied one; zero
+// rolls everything back.
+func (m *Migrator) To(ctx context.Context, version int64) ([]Migration, error) {
+	if version != 0 && m.find(version) < 0 {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+
+	return m.locked(ctx, func(ctx context.Context) ([]Migration, error) {
+		return m.to(ctx, version)
+	})
+}
+
+func (m *Migrator) to(ctx context.Context, version int64) ([]Migration, error) {
+	applied, err := m.verified(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok || migration.Version <= version {
+			continue
+		}
+
+		if err := m.revert(ctx, migration); err != nil {
+			return done, err
+		}
+		done = append(done, migration)
+	}
+
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok || migration.Version > version {
+			continue
+		}
+
+		if err := m.apply(ctx, migration); err != nil {
+			return done, err
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// Status lists every known migration and whether it was applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := Status{Version: migration.Version, Name: migration.Name}
+
+		if row, ok := applied[migration.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = row.appliedAt
+			status.Modified = row.checksum != migration.Checksum()
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Verify checks that every applied migration still exists unchanged.
+func (m *Migrator) Verify(ctx context.Context) error {
+	_, err := m.verified(ctx)
+	return err
+}
+
+func (m *Migrator) verified(ctx context.Context) (map[int64]record, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for version, row := range applied {
+		index := m.find(version)
+		if index < 0 {
+			return nil, fmt.Errorf("%w: %d_%s is applied but missing from the source", ErrUnknownVersion, version, row.name)
+		}
+
+		migration := m.migrations[index]
+		if expected := migration.Checksum(); row.checksum != expected {
+			return nil, &ChecksumError{
+				Version:  version,
+				Name:     migration.Name,
+				Applied:  row.checksum,
+				Expected: expected,
+			}
+		}
+	}
+	return applied, nil
+}
+
+// applied creates the bookkeeping table if needed and reads it.
+func (m *Migrator) applied(ctx context.Context) (map[int64]record, error) {
+	create := "CREATE TABLE IF NOT EXISTS " + m.opts.Table + " (" +
+		"version BIGINT PRIMARY KEY, " +
+		"name VARCHAR(255) NOT NULL, " +
+		"checksum VARCHAR(64) NOT NULL, " +
+		"applied_at TIMESTAMP NOT NULL)"
+
+	if _, err := m.db.ExecContext(ctx, create); err != nil {
+		return nil, err
+	}
+
+	rows, err := m.db.QueryContext(ctx, "SELECT version, name, checksum, applied_at FROM "+m.opts.Table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]record)
+	for rows.Next() {
+		var version int64
+		var row record
+
+		if err := rows.Scan(&version, &row.name, &row.checksum, &row.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = row
+	}
+	return applied, rows.Err()
+}
+
+func (m *Migrator) apply(ctx context.Context, migration Migration) error {
+	insert := fmt.Sprintf("INSERT INTO %s (version, name, checksum, applied_at) VALUES (%s, %s, %s, %s)",
+		m.opts.Table, m.opts.Placeholder(1), m.opts.Placeholder(2), m.opts.Placeholder(3), m.opts.Placeholder(4))
+
+	return m.transaction(ctx, migration, migration.Up, insert,
+		migration.Version, migration.Name, migration.Checksum(), time.Now().UTC())
+}
+
+func (m *Migrator) revert(ctx context.Context, migration Migration) error {
+	if len(migration.Down) == 0 {
+		return fmt.Errorf("%w: %d_%s", ErrNoDown, migration.Version, migration.Name)
+	}
+
+	remove := fmt.Sprintf("DELETE FROM %s WHERE version = %s", m.opts.Table, m.opts.Placeholder(1))
+	return m.transaction(ctx, migration, migration.Down, remove, migration.Version)
+}
+
+// transaction runs script and the bookkeeping statement atomically,
+// where the engine has transactional DDL. The driver must accept several
+// statements in one Exec for multi-statement scripts.
+func (m *Migrator) transaction(ctx context.Context, migration Migration, script, bookkeeping string, args ...interface{}) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migrate: %d_%s: %w", migration.Version, migration.Name, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, bookkeeping, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *Migrator) find(version int64) int {
+	for i, migration := range m.migrations {
+		if migration.Version == version {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,338 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDB understands the statements of this package, plus scripts of the
+// form "RUN <name>", which are logged, "FAIL", which errors, and "STEAL",
+// which hands the lock row to another instance mid-script.
+type fakeDB struct {
+	mu      sync.Mutex
+	tables  map[string]bool
+	records map[int64][]driver.Value
+	lock    []driver.Value
+	scripts []string
+}
+
+var (
+	fakeMu  sync.Mutex
+	fakeDBs = map[string]*fakeDB{}
+)
+
+func init() {
+	sql.Register("migratetest", fakeDriver{})
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeMu.Lock()
+	defer fakeMu.Unlock()
+
+	if fakeDBs[name] == nil {
+		fakeDBs[name] = &fakeDB{tables: map[string]bool{}, records: map[int64][]driver.Value{}}
+	}
+	return &fakeConn{fakeDBs[name]}, nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{c.db, query}, nil
+}
+
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	db, q := s.db, s.query
+
+	if q == "STEAL" {
+		db.mu.Lock()
+		db.lock = []driver.Value{"other", time.Now().UTC()}
+		db.mu.Unlock()
+
+		time.Sleep(50 * time.Millisecond)
+		return driver.RowsAffected(0), nil
+	}
+
+	if strings.HasPrefix(q, "RUN ") {
+		// Widen the window in which unlocked replicas would overlap.
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(q, "CREATE TABLE IF NOT EXISTS "):
+		db.tables[strings.Fields(q)[5]] = true
+	case strings.HasPrefix(q, "RUN "):
+		db.scripts = append(db.scripts, q[4:])
+	case q == "FAIL":
+		return nil, errors.New("script failed")
+
+	case strings.Contains(q, "_lock (id"):
+		if db.lock != nil {
+			return nil, errors.New("duplicate key")
+		}
+		db.lock = args
+	case strings.Contains(q, "_lock WHERE id = 1 AND holder"):
+		if db.lock != nil && db.lock[0] == args[0] {
+			db.lock = nil
+		}
+	case strings.Contains(q, "_lock WHERE id = 1 AND refreshed_at"):
+		if db.lock != nil && db.lock[1].(time.Time).Equal(args[0].(time.Time)) {
+			db.lock = nil
+		}
+	case strings.HasPrefix(q, "UPDATE "):
+		if db.lock == nil || db.lock[0] != args[1] {
+			return driver.RowsAffected(0), nil
+		}
+		db.lock = []driver.Value{args[1], args[0]}
+
+	case strings.HasPrefix(q, "INSERT INTO "):
+		version := args[0].(int64)
+		if db.records[version] != nil {
+			return nil, errors.New("duplicate key")
+		}
+		db.records[version] = args
+	case strings.HasPrefix(q, "DELETE FROM "):
+		delete(db.records, args[0].(int64))
+
+	default:
+		return nil, fmt.Errorf("unexpected statement %q", q)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	db := s.db
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if strings.HasPrefix(s.query, "SELECT refreshed_at ") {
+		if db.lock == nil {
+			return &fakeRows{columns: []string{"refreshed_at"}}, nil
+		}
+		return &fakeRows{columns: []string{"refreshed_at"}, rows: [][]driver.Value{{db.lock[1]}}}, nil
+	}
+
+	rows := &fakeRows{columns: []string{"version", "name", "checksum", "applied_at"}}
+	for _, record := range db.records {
+		rows.rows = append(rows.rows, record)
+	}
+	return rows, nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// open returns a fresh database named after the test.
+func open(t *testing.T) (*sql.DB, *fakeDB) {
+	fakeMu.Lock()
+	delete(fakeDBs, t.Name())
+	fakeMu.Unlock()
+
+	db, err := sql.Open("migratetest", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	db.Ping()
+	fakeMu.Lock()
+	defer fakeMu.Unlock()
+	return db, fakeDBs[t.Name()]
+}
+
+var migrations = []Migration{
+	{Version: 1, Name: "users", Up: "RUN up 1", Down: "RUN down 1"},
+	{Version: 2, Name: "roles", Up: "RUN up 2", Down: "RUN down 2"},
+	{Version: 3, Name: "audit", Up: "RUN up 3"},
+}
+
+func versions(done []Migration) []int64 {
+	result := []int64{}
+	for _, migration := range done {
+		result = append(result, migration.Version)
+	}
+	return result
+}
+
+func TestMigrator(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(ctx context.Context, m *Migrator) ([]Migration, error)
+		done []int64
+		err  error
+	}{
+		{"up", func(ctx context.Context, m *Migrator) ([]Migration, error) {
+			return m.Up(ctx)
+		}, []int64{1, 2, 3}, nil},
+		{"to 2", func(ctx context.Context, m *Migrator) ([]Migration, error) {
+			return m.To(ctx, 2)
+		}, []int64{1, 2}, nil},
+		{"to unknown", func(ctx context.Context, m *Migrator) ([]Migration, error) {
+			return m.To(ctx, 7)
+		}, []int64{}, ErrUnknownVersion},
+		{"down without script", func(ctx context.Context, m *Migrator) ([]Migration, error) {
+			if _, err := m.Up(ctx); err != nil {
+				return nil, err
+			}
+			return m.Down(ctx, 1)
+		}, []int64{}, ErrNoDown},
+		{"up then to 1", func(ctx context.Context, m *Migrator) ([]Migration, error) {
+			if _, err := m.To(ctx, 2); err != nil {
+				return nil, err
+			}
+			return m.To(ctx, 1)
+		}, []int64{2}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, fake := open(t)
+			m := New(db, migrations, Options{})
+
+			done, err := test.run(context.Background(), m)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("err = %v; want %v", err, test.err)
+			}
+			if got := versions(done); !reflect.DeepEqual(got, test.done) {
+				t.Errorf("done = %v; want %v", got, test.done)
+			}
+			if fake.lock != nil {
+				t.Error("lock row left behind")
+			}
+		})
+	}
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	db, _ := open(t)
+	ctx := context.Background()
+
+	if _, err := New(db, migrations, Options{}).Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	edited := append([]Migration(nil), migrations...)
+	edited[0].Up = "RUN up 1 edited"
+
+	var mismatch *ChecksumError
+	if _, err := New(db, edited, Options{}).Up(ctx); !errors.As(err, &mismatch) || mismatch.Version != 1 {
+		t.Errorf("err = %v; want a checksum error for version 1", err)
+	}
+}
+
+func TestConcurrentUp(t *testing.T) {
+	defer func(poll time.Duration) { lockPoll = poll }(lockPoll)
+	lockPoll = time.Millisecond
+
+	db, fake := open(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := New(db, migrations, Options{}).Up(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	want := []string{"up 1", "up 2", "up 3"}
+	if !reflect.DeepEqual(fake.scripts, want) {
+		t.Errorf("scripts = %v; want each once: %v", fake.scripts, want)
+	}
+}
+
+func TestRowLock(t *testing.T) {
+	defer func(poll time.Duration) { lockPoll = poll }(lockPoll)
+	lockPoll = time.Millisecond
+
+	tests := []struct {
+		name string
+		age  time.Duration
+		err  error
+	}{
+		{"stale row taken over", 2 * time.Minute, nil},
+		{"fresh row waited on", 0, context.DeadlineExceeded},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, fake := open(t)
+			fake.lock = []driver.Value{"crashed", time.Now().UTC().Add(-test.age)}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			_, err := New(db, migrations, Options{}).Up(ctx)
+			if !errors.Is(err, test.err) {
+				t.Errorf("err = %v; want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestLockLost(t *testing.T) {
+	db, fake := open(t)
+	stolen := []Migration{
+		{Version: 1, Name: "steal", Up: "STEAL"},
+		{Version: 2, Name: "roles", Up: "RUN up 2"},
+	}
+
+	_, err := New(db, stolen, Options{Stale: 15 * time.Millisecond}).Up(context.Background())
+	if !errors.Is(err, ErrLockLost) {
+		t.Errorf("err = %v; want %v", err, ErrLockLost)
+	}
+	if len(fake.scripts) > 0 {
+		t.Errorf("scripts %v ran without the lock", fake.scripts)
+	}
+}
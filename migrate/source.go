@@ -0,0 +1,103 @@
+package migrate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Migration is one versioned schema change.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Checksum identifies the Up script; applied migrations must keep it.
+func (m Migration) Checksum() string {
+	digest := sha256.Sum256([]byte(m.Up))
+	return hex.EncodeToString(digest[:])
+}
+
+// Load reads migrations from dir of fsys, typically an embed.FS. Files
+// are named <version>_<name>.up.sql and <version>_<name>.down.sql; the
+// down script is optional. Migrations are returned by version.
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".sql") {
+			continue
+		}
+
+		version, title, direction, err := parse(name)
+		if err != nil {
+			return nil, err
+		}
+
+		raw, err := fs.ReadFile(fsys, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: title}
+			byVersion[version] = m
+		} else if m.Name != title {
+			return nil, fmt.Errorf("migrate: version %d is used by %q and %q", version, m.Name, title)
+		}
+
+		if direction == "up" {
+			m.Up = string(raw)
+		} else {
+			m.Down = string(raw)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if len(m.Up) == 0 {
+			return nil, fmt.Errorf("migrate: version %d has no up script", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// parse splits "0003_add_runs.up.sql" into 3, "add_runs" and "up".
+func parse(file string) (int64, string, string, error) {
+	base := strings.TrimSuffix(file, ".sql")
+
+	direction := path.Ext(base)
+	if direction != ".up" && direction != ".down" {
+		return 0, "", "", fmt.Errorf("migrate: %s: expected .up.sql or .down.sql", file)
+	}
+	base = strings.TrimSuffix(base, direction)
+
+	separator := strings.IndexByte(base, '_')
+	if separator <= 0 {
+		return 0, "", "", fmt.Errorf("migrate: %s: expected <version>_<name>", file)
+	}
+
+	version, err := strconv.ParseInt(base[:separator], 10, 64)
+	if err != nil || version <= 0 {
+		return 0, "", "", fmt.Errorf("migrate: %s: invalid version", file)
+	}
+	return version, base[separator+1:], direction[1:], nil
+}
@@ -0,0 +1,150 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"devops.io/cloud/httpclient"
+)
+
+// defaultClient is shared by drivers without a Client so they share the
+// circuit breakers of their hosts. Posts are retried: a duplicate
+// notification is better than a lost one.
+var defaultClient = httpclient.New(httpclient.Options{
+	Methods: []string{http.MethodPost},
+})
+
+// Slack posts messages to a Slack incoming webhook.
+type Slack struct {
+	ID         string
+	WebhookURL string
+	Channel    string
+	Client     *http.Client
+}
+
+// Name implements Driver.
+func (s *Slack) Name() string {
+	return s.ID
+}
+
+// Send implements Driver.
+func (s *Slack) Send(ctx context.Context, message Message) error {
+	payload := map[string]string{
+		"text": fmt.Sprintf("*%s*\n%s", message.Subject, message.Body),
+	}
+
+	if len(s.Channel) > 0 {
+		payload["channel"] = s.Channel
+	}
+	return postJSON(ctx, s.Client, s.WebhookURL, nil, payload)
+}
+
+// Webhook posts the event and the rendered message as JSON to any URL.
+type Webhook struct {
+	ID      string
+	URL     string
+	Headers map[string]string
+	Client  *http.Client
+}
+
+// Name implements Driver.
+func (w *Webhook) Name() string {
+	return w.ID
+}
+
+// Send implements Driver.
+func (w *Webhook) Send(ctx context.Context, message Message) error {
+	return postJSON(ctx, w.Client, w.URL, w.Headers, map[string]interface{}{
+		"subject": message.Subject,
+		"body":    message.Body,
+		"event":   message.Event,
+	})
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, payload interface{}) error {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+
+	if client == nil {
+		client = defaultClient
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("%s answered %s", url, response.Status)
+	}
+	return nil
+}
+
+// SMTP sends plain-text mail.
+type SMTP struct {
+	ID   string
+	Addr string
+	Auth smtp.Auth
+	From string
+	To   []string
+}
+
+// Name implements Driver.
+func (s *SMTP) Name() string {
+	return s.ID
+}
+
+// Send implements Driver. net/smtp has no context support, so ctx is only
+// checked before dialing.
+func (s *SMTP) Send(ctx context.Context, message Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return smtp.SendMail(s.Addr, s.Auth, s.From, s.To, s.compose(message))
+}
+
+// crlf turns every line ending, whatever its style, into CRLF.
+var crlf = strings.NewReplacer("\r\n", "\r\n", "\r", "\r\n", "\n", "\r\n")
+
+// compose renders message as a mail. The subject is MIME-encoded when it
+// isn't plain ASCII.
+func (s *SMTP) compose(message Message) []byte {
+	var mail bytes.Buffer
+
+	fmt.Fprintf(&mail, "From: %s\r\n", s.From)
+	fmt.Fprintf(&mail, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&mail, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", sanitizeHeader(message.Subject)))
+	fmt.Fprintf(&mail, "Date: %s\r\n", message.Event.Time.Format(time.RFC1123Z))
+	mail.WriteString("MIME-Version: 1.0\r\n")
+	mail.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	mail.WriteString(crlf.Replace(message.Body))
+
+	return mail.Bytes()
+}
+
+func sanitizeHeader(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
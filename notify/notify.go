@@ -0,0 +1,233 @@
+// Package notify sends templated messages about job lifecycle events
+// through pluggable drivers such as Slack, SMTP or a generic webhook.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Kind is a job lifecycle transition.
+type Kind string
+
+const (
+	Started   Kind = "start"
+	Succeeded Kind = "success"
+	Failed    Kind = "failure"
+	TimedOut  Kind = "timeout"
+)
+
+// Event describes a lifecycle transition of a job or pipeline run.
+type Event struct {
+	Kind     Kind              `json:"kind"`
+	Job      string            `json:"job,omitempty"`
+	Pipeline string            `json:"pipeline,omitempty"`
+	Run      string            `json:"run,omitempty"`
+	Time     time.Time         `json:"time"`
+	Duration time.Duration     `json:"duration,omitempty"`
+	Error    string            `json:"error,omitempty"`
+	URL      string            `json:"url,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+}
+
+// Message is a rendered notification handed to a driver.
+type Message struct {
+	Subject string
+	Body    string
+	Event   Event
+}
+
+// Driver delivers messages to one destination.
+type Driver interface {
+	Name() string
+	Send(ctx context.Context, message Message) error
+}
+
+// Rule selects which events reach which drivers and how they read.
+type Rule struct {
+	// Job and Pipeline are path.Match patterns; empty matches anything.
+	Job      string
+	Pipeline string
+
+	// Kinds lists the transitions to report; empty means all of them.
+	Kinds []Kind
+
+	// Drivers names the registered drivers to send through.
+	Drivers []string
+
+	// Subject and Body are text/template sources evaluated against the
+	// Event; empty values fall back to the defaults of this package.
+	Subject string
+	Body    string
+
+	subject *template.Template
+	body    *template.Template
+}
+
+const (
+	defaultSubject = `[{{.Kind}}] {{if .Pipeline}}{{.Pipeline}}{{else}}{{.Job}}{{end}}`
+	defaultBody    = `{{if .Pipeline}}Pipeline {{.Pipeline}}{{else}}Job {{.Job}}{{end}}` +
+		`{{if .Run}} (run {{.Run}}){{end}} reported {{.Kind}} at {{.Time.Format "2006-01-02 15:04:05 MST"}}` +
+		`{{if .Duration}} after {{.Duration}}{{end}}.` +
+		`{{if .Error}}
+Error: {{.Error}}{{end}}{{if .URL}}
+Details: {{.URL}}{{end}}`
+)
+
+func (r *Rule) compile() error {
+	subject, body := r.Subject, r.Body
+	if len(subject) == 0 {
+		subject = defaultSubject
+	}
+	if len(body) == 0 {
+		body = defaultBody
+	}
+
+	var err error
+	if r.subject, err = template.New("subject").Parse(subject); err != nil {
+		return fmt.Errorf("notify: subject template: %v", err)
+	}
+	if r.body, err = template.New("body").Parse(body); err != nil {
+		return fmt.Errorf("notify: body template: %v", err)
+	}
+	return nil
+}
+
+func (r *Rule) matches(event Event) bool {
+	if ok, _ := path.Match(pattern(r.Job), event.Job); !ok {
+		return false
+	}
+	if ok, _ := path.Match(pattern(r.Pipeline), event.Pipeline); !ok {
+		return false
+	}
+	if len(r.Kinds) == 0 {
+		return true
+	}
+
+	for _, kind := range r.Kinds {
+		if kind == event.Kind {
+			return true
+		}
+	}
+	return false
+}
+
+func pattern(value string) string {
+	if len(value) == 0 {
+		return "*"
+	}
+	return value
+}
+
+func (r *Rule) render(event Event) (Message, error) {
+	var subject, body bytes.Buffer
+
+	if err := r.subject.Execute(&subject, event); err != nil {
+		return Message{}, err
+	}
+	if err := r.body.Execute(&body, event); err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Body:    body.String(),
+		Event:   event,
+	}, nil
+}
+
+// Notifier routes events to drivers according to its rules.
+type Notifier struct {
+	mu      sync.RWMutex
+	drivers map[string]Driver
+	rules   []*Rule
+}
+
+// New creates a Notifier without drivers or rules.
+func New() *Notifier {
+	return &Notifier{drivers: make(map[string]Driver)}
+}
+
+// Register makes a driver available to rules under its Name.
+func (n *Notifier) Register(driver Driver) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.drivers[driver.Name()] = driver
+}
+
+// AddRule validates and registers a rule.
+func (n *Notifier) AddRule(rule Rule) error {
+	if err := rule.compile(); err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, name := range rule.Drivers {
+		if _, ok := n.drivers[name]; !ok {
+			return fmt.Errorf("notify: unknown driver %q", name)
+		}
+	}
+
+	n.rules = append(n.rules, &rule)
+	return nil
+}
+
+// Notify renders and sends event through every matching rule. A driver is
+// used at most once per event even if several rules select it. Delivery
+// errors of all drivers are joined into the returned error.
+func (n *Notifier) Notify(ctx context.Context, event Event) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	// Register may write the drivers map while events are sent, so both
+	// the rules and the drivers are copied under the lock.
+	n.mu.RLock()
+	rules := append([]*Rule(nil), n.rules...)
+	drivers := make(map[string]Driver, len(n.drivers))
+	for name, driver := range n.drivers {
+		drivers[name] = driver
+	}
+	n.mu.RUnlock()
+
+	var failures []string
+	used := make(map[string]bool)
+
+	for _, rule := range rules {
+		if !rule.matches(event) {
+			continue
+		}
+
+		message, err := rule.render(event)
+		if err != nil {
+			failures = append(failures, err.Error())
+			continue
+		}
+
+		for _, name := range rule.Drivers {
+			if used[name] {
+				continue
+			}
+			used[name] = true
+
+			if err := drivers[name].Send(ctx, message); err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+			}
+		}
+	}
+
+	if len(failures) > 0 {
+		return errors.New("notify: " + strings.Join(failures, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recorder struct {
+	id   string
+	err  error
+	mu   sync.Mutex
+	sent []Message
+}
+
+func (r *recorder) Name() string {
+	return r.id
+}
+
+func (r *recorder) Send(ctx context.Context, message Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sent = append(r.sent, message)
+	return r.err
+}
+
+func (r *recorder) subjects() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var subjects []string
+	for _, message := range r.sent {
+		subjects = append(subjects, message.Subject)
+	}
+	return subjects
+}
+
+func TestNotify(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		rules  []Rule
+		event  Event
+		chat   []string
+		mail   []string
+		failed string
+	}{
+		{
+			name:  "default templates",
+			rules: []Rule{{Drivers: []string{"chat"}}},
+			event: Event{Kind: Failed, Job: "build", Time: at},
+			chat:  []string{"[failure] build"},
+		},
+		{
+			name: "patterns and kinds select rules",
+			rules: []Rule{
+				{Job: "deploy-*", Kinds: []Kind{Failed}, Drivers: []string{"chat"}, Subject: "deploy {{.Job}} failed"},
+				{Job: "build", Drivers: []string{"mail"}},
+			},
+			event: Event{Kind: Failed, Job: "deploy-prod", Time: at},
+			chat:  []string{"deploy deploy-prod failed"},
+		},
+		{
+			name: "driver used once per event",
+			rules: []Rule{
+				{Drivers: []string{"chat"}, Subject: "first"},
+				{Drivers: []string{"chat", "mail"}, Subject: "second"},
+			},
+			event: Event{Kind: Succeeded, Pipeline: "release", Time: at},
+			chat:  []string{"first"},
+			mail:  []string{"second"},
+		},
+		{
+			name:   "failures joined",
+			rules:  []Rule{{Drivers: []string{"chat", "broken"}}},
+			event:  Event{Kind: Started, Job: "build", Time: at},
+			chat:   []string{"[start] build"},
+			failed: "notify: broken: unreachable",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chat := &recorder{id: "chat"}
+			mail := &recorder{id: "mail"}
+
+			n := New()
+			n.Register(chat)
+			n.Register(mail)
+			n.Register(&recorder{id: "broken", err: errors.New("unreachable")})
+
+			for _, rule := range test.rules {
+				if err := n.AddRule(rule); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := n.Notify(context.Background(), test.event)
+			if (err == nil) != (len(test.failed) == 0) || (err != nil && err.Error() != test.failed) {
+				t.Errorf("Notify = %v; want %q", err, test.failed)
+			}
+
+			if got := strings.Join(chat.subjects(), "|"); got != strings.Join(test.chat, "|") {
+				t.Errorf("chat got %q; want %q", got, test.chat)
+			}
+			if got := strings.Join(mail.subjects(), "|"); got != strings.Join(test.mail, "|") {
+				t.Errorf("mail got %q; want %q", got, test.mail)
+			}
+		})
+	}
+}
+
+func TestAddRuleValidation(t *testing.T) {
+	n := New()
+	n.Register(&recorder{id: "chat"})
+
+	tests := []struct {
+		name string
+		rule Rule
+		ok   bool
+	}{
+		{"valid", Rule{Drivers: []string{"chat"}}, true},
+		{"unknown driver", Rule{Drivers: []string{"pager"}}, false},
+		{"bad template", Rule{Drivers: []string{"chat"}, Subject: "{{.Job"}, false},
+	}
+
+	for _, test := range tests {
+		if err := n.AddRule(test.rule); (err == nil) != test.ok {
+			t.Errorf("%s: AddRule = %v", test.name, err)
+		}
+	}
+}
+
+func TestRegisterDuringNotify(t *testing.T) {
+	n := New()
+	n.Register(&recorder{id: "chat"})
+	n.AddRule(Rule{Drivers: []string{"chat"}})
+
+	var group sync.WaitGroup
+	group.Add(2)
+
+	go func() {
+		defer group.Done()
+		for i := 0; i < 200; i++ {
+			n.Register(&recorder{id: fmt.Sprintf("extra-%d", i)})
+		}
+	}()
+
+	go func() {
+		defer group.Done()
+		for i := 0; i < 200; i++ {
+			n.Notify(context.Background(), Event{Kind: Started, Job: "build"})
+		}
+	}()
+	group.Wait()
+}
+
+func TestWebhookDrivers(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []map[string]interface{}
+	status := http.StatusOK
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		mu.Lock()
+		bodies = append(bodies, body)
+		mu.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	client := server.Client()
+	message := Message{Subject: "done", Body: "all good", Event: Event{Kind: Succeeded, Job: "build"}}
+
+	tests := []struct {
+		name   string
+		driver Driver
+		status int
+		field  string
+		want   string
+		fails  bool
+	}{
+		{"slack", &Slack{ID: "slack", WebhookURL: server.URL, Channel: "#ci", Client: client}, http.StatusOK, "text", "*done*\nall good", false},
+		{"webhook", &Webhook{ID: "hook", URL: server.URL, Client: client}, http.StatusOK, "subject", "done", false},
+		{"rejected", &Webhook{ID: "hook", URL: server.URL, Client: client}, http.StatusBadRequest, "subject", "done", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mu.Lock()
+			bodies, status = nil, test.status
+			mu.Unlock()
+
+			err := test.driver.Send(context.Background(), message)
+			if (err != nil) != test.fails {
+				t.Fatalf("Send = %v", err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if len(bodies) != 1 || bodies[0][test.field] != test.want {
+				t.Errorf("payloads = %v", bodies)
+			}
+		})
+	}
+}
+
+func TestSanitizeHeader(t *testing.T) {
+	if got := sanitizeHeader("a\r\nBcc: x"); got != "a  Bcc: x" {
+		t.Errorf("sanitizeHeader = %q", got)
+	}
+}
+
+func TestCompose(t *testing.T) {
+	s := &SMTP{From: "ci@example.com", To: []string{"ops@example.com"}}
+	mail := string(s.compose(Message{
+		Subject: "Build échoué\r\nBcc: x",
+		Body:    "windows\r\nunix\nold mac\rend",
+	}))
+
+	header, body := mail, ""
+	if i := strings.Index(mail, "\r\n\r\n"); i >= 0 {
+		header, body = mail[:i], mail[i+4:]
+	}
+
+	if !strings.Contains(header, "\r\nSubject: =?utf-8?q?Build_=C3=A9chou=C3=A9__Bcc:_x?=\r\n") {
+		t.Errorf("header = %q", header)
+	}
+	if want := "windows\r\nunix\r\nold mac\r\nend"; body != want {
+		t.Errorf("body = %q; want %q", body, want)
+	}
+}
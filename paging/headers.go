@@ -0,0 +1,61 @@
+package paging
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// WriteHeaders sets X-Total-Count and an RFC 8288 Link header with the
+// first, prev, next and last pages, keeping every other query parameter
+// of the request.
+func WriteHeaders(w http.ResponseWriter, r *http.Request, spec Spec, total int) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+
+	last := 1
+	if spec.PerPage > 0 && total > 0 {
+		last = (total + spec.PerPage - 1) / spec.PerPage
+	}
+
+	link := func(page int, rel string) string {
+		target := *r.URL
+		query := target.Query()
+
+		query.Set("page", strconv.Itoa(page))
+		query.Set("per_page", strconv.Itoa(spec.PerPage))
+		target.RawQuery = query.Encode()
+
+		return fmt.Sprintf(`<%s>; rel="%s"`, reference(r, &target), rel)
+	}
+
+	links := []string{link(1, "first")}
+	if spec.Page > 1 {
+		previous := spec.Page - 1
+		if previous > last {
+			previous = last
+		}
+		links = append(links, link(previous, "prev"))
+	}
+	if spec.Page < last {
+		links = append(links, link(spec.Page+1, "next"))
+	}
+	links = append(links, link(last, "last"))
+
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
+
+// reference returns target as an absolute URL when the request host is
+// known, as a path otherwise.
+func reference(r *http.Request, target *url.URL) string {
+	if len(r.Host) == 0 {
+		return target.RequestURI()
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + target.RequestURI()
+}
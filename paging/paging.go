@@ -0,0 +1,191 @@
+// Package paging implements the list conventions shared by every list
+// endpoint: page/per_page pagination, sort=field,-other ordering and
+// field[op]=value filters, plus consistent Link and X-Total-Count headers.
+package paging
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Op is a filter comparison.
+type Op string
+
+const (
+	Eq       Op = "eq"
+	Ne       Op = "ne"
+	Lt       Op = "lt"
+	Lte      Op = "lte"
+	Gt       Op = "gt"
+	Gte      Op = "gte"
+	Contains Op = "like"
+	In       Op = "in"
+)
+
+var operators = map[Op]bool{
+	Eq: true, Ne: true, Lt: true, Lte: true, Gt: true, Gte: true, Contains: true, In: true,
+}
+
+// Filter restricts a list to items whose field compares to Value.
+type Filter struct {
+	Field string
+	Op    Op
+	Value string
+}
+
+// Order sorts by one field.
+type Order struct {
+	Field string
+	Desc  bool
+}
+
+// Spec is a parsed list request.
+type Spec struct {
+	Page    int
+	PerPage int
+	Sort    []Order
+	Filters []Filter
+}
+
+// Offset is the index of the first item of the page.
+func (s Spec) Offset() int {
+	return (s.Page - 1) * s.PerPage
+}
+
+// Limit is the number of items of a page.
+func (s Spec) Limit() int {
+	return s.PerPage
+}
+
+// Options describes what a list endpoint supports.
+type Options struct {
+	// DefaultPerPage defaults to 30 and MaxPerPage to 100.
+	DefaultPerPage int
+	MaxPerPage     int
+
+	// Sortable and Filterable list the accepted fields. Sorting by or
+	// filtering with an operator on other fields is rejected so clients
+	// learn about typos instead of silently getting unsorted or
+	// unfiltered results. Plain parameters naming other fields are left
+	// alone, as endpoints take their own parameters next to the filters.
+	Sortable   []string
+	Filterable []string
+
+	// DefaultSort applies when the request has no sort parameter.
+	DefaultSort []Order
+}
+
+// Error reports an invalid list parameter.
+type Error struct {
+	Param  string
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("paging: invalid %s: %s", e.Param, e.Reason)
+}
+
+const maxInt = int(^uint(0) >> 1)
+
+// reserved parameters are never treated as filters.
+var reserved = map[string]bool{"page": true, "per_page": true, "sort": true}
+
+// Parse builds a Spec from query parameters. Filters are written as
+// field=value or field[op]=value, e.g. status=failed or
+// created[gte]=2021-01-01; "in" takes a comma-separated list.
+func Parse(values url.Values, opts Options) (Spec, error) {
+	if opts.DefaultPerPage <= 0 {
+		opts.DefaultPerPage = 30
+	}
+	if opts.MaxPerPage <= 0 {
+		opts.MaxPerPage = 100
+	}
+
+	spec := Spec{Page: 1, PerPage: opts.DefaultPerPage, Sort: opts.DefaultSort}
+
+	if value := values.Get("per_page"); len(value) > 0 {
+		perPage, err := strconv.Atoi(value)
+		if err != nil || perPage < 1 {
+			return spec, &Error{"per_page", "must be a positive integer"}
+		}
+
+		if perPage > opts.MaxPerPage {
+			perPage = opts.MaxPerPage
+		}
+		spec.PerPage = perPage
+	}
+
+	if value := values.Get("page"); len(value) > 0 {
+		page, err := strconv.Atoi(value)
+		if err != nil || page < 1 {
+			return spec, &Error{"page", "must be a positive integer"}
+		}
+
+		// The offset of the page must be representable, or slices would
+		// be cut at a negative index and SQL would get a negative OFFSET.
+		if page-1 > maxInt/spec.PerPage {
+			return spec, &Error{"page", "is out of range"}
+		}
+		spec.Page = page
+	}
+
+	if value := values.Get("sort"); len(value) > 0 {
+		spec.Sort = nil
+
+		for _, field := range strings.Split(value, ",") {
+			order := Order{Field: strings.TrimSpace(field)}
+			if strings.HasPrefix(order.Field, "-") {
+				order.Field = order.Field[1:]
+				order.Desc = true
+			}
+
+			if !contains(opts.Sortable, order.Field) {
+				return spec, &Error{"sort", fmt.Sprintf("cannot sort by %q", order.Field)}
+			}
+			spec.Sort = append(spec.Sort, order)
+		}
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if reserved[name] {
+			continue
+		}
+
+		field, op := name, Eq
+		open := strings.IndexByte(name, '[')
+		if open > 0 && strings.HasSuffix(name, "]") {
+			field, op = name[:open], Op(name[open+1:len(name)-1])
+		} else if !contains(opts.Filterable, field) {
+			continue
+		}
+
+		if !contains(opts.Filterable, field) {
+			return spec, &Error{name, fmt.Sprintf("cannot filter by %q", field)}
+		} else if !operators[op] {
+			return spec, &Error{name, fmt.Sprintf("unknown operator %q", op)}
+		}
+
+		for _, value := range values[name] {
+			spec.Filters = append(spec.Filters, Filter{Field: field, Op: op, Value: value})
+		}
+	}
+	return spec, nil
+}
+
+func contains(items []string, value string) bool {
+	for _, item := range items {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package paging
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var options = Options{
+	Sortable:   []string{"name", "size", "created"},
+	Filterable: []string{"name", "size", "status", "created"},
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		query string
+		want  Spec
+		param string
+	}{
+		{"", Spec{Page: 1, PerPage: 30}, ""},
+		{"page=3&per_page=10", Spec{Page: 3, PerPage: 10}, ""},
+		{"per_page=1000", Spec{Page: 1, PerPage: 100}, ""},
+		{"sort=name,-size", Spec{Page: 1, PerPage: 30, Sort: []Order{{"name", false}, {"size", true}}}, ""},
+		{"status=failed&size[gte]=10", Spec{Page: 1, PerPage: 30, Filters: []Filter{
+			{"size", Gte, "10"}, {"status", Eq, "failed"},
+		}}, ""},
+		{"page=0", Spec{}, "page"},
+		{"page=x", Spec{}, "page"},
+		{"page=" + strconv.Itoa(maxInt) + "&per_page=30", Spec{}, "page"},
+		{"page=" + strconv.Itoa(maxInt/30+1) + "&per_page=30", Spec{Page: maxInt/30 + 1, PerPage: 30}, ""},
+		{"page=" + strconv.Itoa(maxInt/30+2) + "&per_page=30", Spec{}, "page"},
+		{"per_page=-1", Spec{}, "per_page"},
+		{"sort=secret", Spec{}, "sort"},
+		{"owner=me&q=x", Spec{Page: 1, PerPage: 30}, ""},
+		{"owner[eq]=me", Spec{}, "owner[eq]"},
+		{"size[regex]=1", Spec{}, "size[regex]"},
+	}
+
+	for _, test := range tests {
+		values, _ := url.ParseQuery(test.query)
+		spec, err := Parse(values, options)
+
+		if len(test.param) > 0 {
+			if e, ok := err.(*Error); !ok || e.Param != test.param {
+				t.Errorf("Parse(%s) error = %v; want one on %s", test.query, err, test.param)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Parse(%s) = %v", test.query, err)
+		} else if !reflect.DeepEqual(spec, test.want) {
+			t.Errorf("Parse(%s) = %+v; want %+v", test.query, spec, test.want)
+		}
+		if spec.Offset() < 0 {
+			t.Errorf("Parse(%s) offset = %d", test.query, spec.Offset())
+		}
+	}
+}
+
+type item struct {
+	Name    string    `json:"name"`
+	Size    int       `json:"size"`
+	Status  string    `json:"status"`
+	Created time.Time `json:"created"`
+}
+
+func TestApply(t *testing.T) {
+	day := func(n int) time.Time { return time.Date(2021, 1, n, 0, 0, 0, 0, time.UTC) }
+	items := []item{
+		{"c", 30, "failed", day(3)},
+		{"a", 10, "done", day(1)},
+		{"b", 20, "failed", day(2)},
+		{"d", 40, "done", day(4)},
+	}
+
+	tests := []struct {
+		name  string
+		spec  Spec
+		want  string
+		total int
+	}{
+		{"all", Spec{Page: 1, PerPage: 10}, "cabd", 4},
+		{"sorted", Spec{Page: 1, PerPage: 10, Sort: []Order{{"name", false}}}, "abcd", 4},
+		{"descending", Spec{Page: 1, PerPage: 10, Sort: []Order{{"size", true}}}, "dcba", 4},
+		{"second page", Spec{Page: 2, PerPage: 3, Sort: []Order{{"name", false}}}, "d", 4},
+		{"past the end", Spec{Page: 9, PerPage: 3}, "", 4},
+		{"negative offset", Spec{Page: -5, PerPage: 3}, "", 4},
+		{"equal", Spec{Page: 1, PerPage: 10, Filters: []Filter{{"status", Eq, "failed"}}}, "cb", 2},
+		{"numeric", Spec{Page: 1, PerPage: 10, Filters: []Filter{{"size", Gt, "9"}, {"size", Lt, "30"}}}, "ab", 2},
+		{"time", Spec{Page: 1, PerPage: 10, Filters: []Filter{{"created", Gte, "2021-01-03"}}}, "cd", 2},
+		{"in", Spec{Page: 1, PerPage: 10, Filters: []Filter{{"name", In, "a, d"}}}, "ad", 2},
+		{"contains", Spec{Page: 1, PerPage: 10, Filters: []Filter{{"status", Contains, "AIL"}}}, "cb", 2},
+		{"unknown field", Spec{Page: 1, PerPage: 10, Filters: []Filter{{"owner", Eq, "x"}}}, "", 0},
+	}
+
+	for _, test := range tests {
+		page, total := Apply(items, test.spec)
+
+		var names strings.Builder
+		for _, item := range page.([]item) {
+			names.WriteString(item.Name)
+		}
+
+		if names.String() != test.want || total != test.total {
+			t.Errorf("%s = %q (%d); want %q (%d)", test.name, names.String(), total, test.want, test.total)
+		}
+	}
+}
+
+func TestSQL(t *testing.T) {
+	columns := map[string]string{"name": "j.name", "size": "j.size"}
+
+	tests := []struct {
+		name  string
+		spec  Spec
+		query string
+		args  []interface{}
+		fail  bool
+	}{
+		{"plain", Spec{Page: 2, PerPage: 10}, " LIMIT ? OFFSET ?", []interface{}{10, 10}, false},
+		{"filtered", Spec{Page: 1, PerPage: 5, Filters: []Filter{{"size", Gte, "3"}, {"name", In, "a,b"}}, Sort: []Order{{"name", true}}},
+			" WHERE j.size >= ? AND j.name IN (?, ?) ORDER BY j.name DESC LIMIT ? OFFSET ?",
+			[]interface{}{"3", "a", "b", 5, 0}, false},
+		{"like", Spec{Page: 1, PerPage: 5, Filters: []Filter{{"name", Contains, "50%_!"}}},
+			" WHERE j.name LIKE ? ESCAPE '!' LIMIT ? OFFSET ?",
+			[]interface{}{"%50!%!_!!%", 5, 0}, false},
+		{"unmapped filter", Spec{Page: 1, PerPage: 5, Filters: []Filter{{"status", Eq, "x"}}}, "", nil, true},
+		{"unmapped sort", Spec{Page: 1, PerPage: 5, Sort: []Order{{"created", false}}}, "", nil, true},
+	}
+
+	for _, test := range tests {
+		query, args, err := test.spec.SQL(columns)
+
+		if test.fail {
+			if err == nil {
+				t.Errorf("%s: no error", test.name)
+			}
+			continue
+		}
+
+		if err != nil || query != test.query || !reflect.DeepEqual(args, test.args) {
+			t.Errorf("%s = %q %v, %v; want %q %v", test.name, query, args, err, test.query, test.args)
+		}
+	}
+}
+
+func TestWriteHeaders(t *testing.T) {
+	tests := []struct {
+		spec  Spec
+		total int
+		rels  []string
+	}{
+		{Spec{Page: 1, PerPage: 10}, 0, []string{"first", "last"}},
+		{Spec{Page: 1, PerPage: 10}, 25, []string{"first", "next", "last"}},
+		{Spec{Page: 2, PerPage: 10}, 25, []string{"first", "prev", "next", "last"}},
+		{Spec{Page: 3, PerPage: 10}, 25, []string{"first", "prev", "last"}},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "http://api.example/jobs?status=failed", nil)
+		w := httptest.NewRecorder()
+		WriteHeaders(w, r, test.spec, test.total)
+
+		if got := w.Header().Get("X-Total-Count"); got != strconv.Itoa(test.total) {
+			t.Errorf("X-Total-Count = %s; want %d", got, test.total)
+		}
+
+		link := w.Header().Get("Link")
+		for _, rel := range test.rels {
+			if !strings.Contains(link, `rel="`+rel+`"`) {
+				t.Errorf("page %d of %d: Link %s lacks %s", test.spec.Page, test.total, link, rel)
+			}
+		}
+		if strings.Count(link, "rel=") != len(test.rels) {
+			t.Errorf("page %d of %d: Link %s; want only %v", test.spec.Page, test.total, link, test.rels)
+		}
+		if !strings.Contains(link, "status=failed") {
+			t.Errorf("Link %s dropped the filter", link)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package paging
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Apply filters, sorts and pages an in-memory slice of structs, struct
+// pointers or maps and returns the page as a slice of the same type along
+// with the number of items matching the filters. Struct fields are looked
+// up by their json tag, then by name, ignoring case.
+func Apply(items interface{}, spec Spec) (interface{}, int) {
+	value := reflect.ValueOf(items)
+	if value.Kind() != reflect.Slice {
+		panic("paging: Apply expects a slice")
+	}
+
+	matched := reflect.MakeSlice(value.Type(), 0, value.Len())
+	for i := 0; i < value.Len(); i++ {
+		if keep(value.Index(i), spec.Filters) {
+			matched = reflect.Append(matched, value.Index(i))
+		}
+	}
+
+	if len(spec.Sort) > 0 {
+		sort.SliceStable(matched.Interface(), func(i, j int) bool {
+			for _, order := range spec.Sort {
+				left, _ := field(matched.Index(i), order.Field)
+				right, _ := field(matched.Index(j), order.Field)
+
+				result := compare(left, right)
+				if result == 0 {
+					continue
+				}
+				return (result < 0) != order.Desc
+			}
+			return false
+		})
+	}
+
+	total := matched.Len()
+	start, end := spec.Offset(), spec.Offset()+spec.Limit()
+
+	if start < 0 || start > total {
+		start = total
+	}
+	if end > total || end < start || spec.Limit() <= 0 {
+		end = total
+	}
+	return matched.Slice(start, end).Interface(), total
+}
+
+func keep(item reflect.Value, filters []Filter) bool {
+	for _, filter := range filters {
+		value, ok := field(item, filter.Field)
+		if !ok {
+			return false
+		}
+
+		switch filter.Op {
+		case In:
+			found := false
+			for _, candidate := range strings.Split(filter.Value, ",") {
+				if compareTo(value, strings.TrimSpace(candidate)) == 0 {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				return false
+			}
+
+		case Contains:
+			text := strings.ToLower(fmt.Sprint(value.Interface()))
+			if !strings.Contains(text, strings.ToLower(filter.Value)) {
+				return false
+			}
+
+		default:
+			result := compareTo(value, filter.Value)
+			if !holds(filter.Op, result) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func holds(op Op, result int) bool {
+	switch op {
+	case Eq:
+		return result == 0
+	case Ne:
+		return result != 0
+	case Lt:
+		return result < 0
+	case Lte:
+		return result <= 0
+	case Gt:
+		return result > 0
+	case Gte:
+		return result >= 0
+	}
+	return false
+}
+
+// field resolves name on a struct, struct pointer or string-keyed map.
+func field(item reflect.Value, name string) (reflect.Value, bool) {
+	for item.Kind() == reflect.Ptr || item.Kind() == reflect.Interface {
+		if item.IsNil() {
+			return reflect.Value{}, false
+		}
+		item = item.Elem()
+	}
+
+	switch item.Kind() {
+	case reflect.Map:
+		if item.Type().Key().Kind() != reflect.String {
+			return reflect.Value{}, false
+		}
+
+		value := item.MapIndex(reflect.ValueOf(name).Convert(item.Type().Key()))
+		for value.IsValid() && value.Kind() == reflect.Interface && !value.IsNil() {
+			value = value.Elem()
+		}
+		return value, value.IsValid()
+
+	case reflect.Struct:
+		kind := item.Type()
+
+		for i := 0; i < kind.NumField(); i++ {
+			declared := kind.Field(i)
+			if len(declared.PkgPath) > 0 {
+				continue
+			}
+
+			tag := strings.Split(declared.Tag.Get("json"), ",")[0]
+			if strings.EqualFold(tag, name) ||
+				(len(tag) == 0 && strings.EqualFold(declared.Name, name)) {
+				return item.Field(i), true
+			}
+		}
+	}
+	return reflect.Value{}, false
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// compareTo compares a field against a filter value parsed to the field's
+// type; values that can't be parsed compare as strings.
+func compareTo(value reflect.Value, text string) int {
+	if value.Type() == timeType {
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+			if parsed, err := time.Parse(layout, text); err == nil {
+				return compare(value, reflect.ValueOf(parsed))
+			}
+		}
+	}
+
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if parsed, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return compare(value, reflect.ValueOf(parsed))
+		}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if parsed, err := strconv.ParseUint(text, 10, 64); err == nil {
+			return compare(value, reflect.ValueOf(parsed))
+		}
+
+	case reflect.Float32, reflect.Float64:
+		if parsed, err := strconv.ParseFloat(text, 64); err == nil {
+			return compare(value, reflect.ValueOf(parsed))
+		}
+
+	case reflect.Bool:
+		if parsed, err := strconv.ParseBool(text); err == nil {
+			return compare(value, reflect.ValueOf(parsed))
+		}
+	}
+	return strings.Compare(fmt.Sprint(value.Interface()), text)
+}
+
+// compare orders two values of compatible kinds; invalid values sort
+// first.
+func compare(left, right reflect.Value) int {
+	switch {
+	case !left.IsValid() && !right.IsValid():
+		return 0
+	case !left.IsValid():
+		return -1
+	case !right.IsValid():
+		return 1
+	}
+
+	if left.Type() == timeType && right.Type() == timeType {
+		a, b := left.Interface().(time.Time), right.Interface().(time.Time)
+		switch {
+		case a.Before(b):
+			return -1
+		case a.After(b):
+			return 1
+		}
+		return 0
+	}
+
+	switch left.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		a, b := number(left), number(right)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+
+	case reflect.Bool:
+		a, b := left.Bool(), right.Kind() == reflect.Bool && right.Bool()
+		switch {
+		case a == b:
+			return 0
+		case !a:
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(fmt.Sprint(left.Interface()), fmt.Sprint(right.Interface()))
+}
+
+func number(value reflect.Value) float64 {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		return value.Float()
+	}
+	return 0
+}
@@ -0,0 +1,93 @@
+package paging
+
+import (
+	"fmt"
+	"strings"
+)
+
+var sqlOperators = map[Op]string{
+	Eq: "=", Ne: "<>", Lt: "<", Lte: "<=", Gt: ">", Gte: ">=",
+}
+
+// Where renders the filters as a SQL WHERE clause using "?" placeholders.
+// columns maps API field names to column expressions; since only mapped
+// columns are emitted, user input never reaches the SQL text. A filter on
+// an unmapped field is an error rather than silently dropped, which would
+// widen the result. The clause is empty when there is no filter.
+func (s Spec) Where(columns map[string]string) (string, []interface{}, error) {
+	var conditions []string
+	var args []interface{}
+
+	for _, filter := range s.Filters {
+		column, ok := columns[filter.Field]
+		if !ok {
+			return "", nil, fmt.Errorf("paging: no column for filter field %q", filter.Field)
+		}
+
+		switch filter.Op {
+		case In:
+			values := strings.Split(filter.Value, ",")
+			marks := strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")
+
+			conditions = append(conditions, column+" IN ("+marks+")")
+			for _, value := range values {
+				args = append(args, strings.TrimSpace(value))
+			}
+
+		case Contains:
+			// '!' rather than a backslash, which MySQL would read as
+			// escaping the closing quote.
+			escaped := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(filter.Value)
+
+			conditions = append(conditions, column+" LIKE ? ESCAPE '!'")
+			args = append(args, "%"+escaped+"%")
+
+		default:
+			conditions = append(conditions, column+" "+sqlOperators[filter.Op]+" ?")
+			args = append(args, filter.Value)
+		}
+	}
+
+	if len(conditions) == 0 {
+		return "", nil, nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args, nil
+}
+
+// OrderBy renders the sort as a SQL ORDER BY clause. Unmapped fields are
+// an error like in Where. It is empty when there is nothing to sort by.
+func (s Spec) OrderBy(columns map[string]string) (string, error) {
+	var orders []string
+
+	for _, order := range s.Sort {
+		column, ok := columns[order.Field]
+		if !ok {
+			return "", fmt.Errorf("paging: no column for sort field %q", order.Field)
+		}
+
+		if order.Desc {
+			column += " DESC"
+		}
+		orders = append(orders, column)
+	}
+
+	if len(orders) == 0 {
+		return "", nil
+	}
+	return " ORDER BY " + strings.Join(orders, ", "), nil
+}
+
+// SQL renders WHERE, ORDER BY, LIMIT and OFFSET, ready to be appended to
+// a SELECT statement. Use Where alone for the matching COUNT(*) query.
+func (s Spec) SQL(columns map[string]string) (string, []interface{}, error) {
+	where, args, err := s.Where(columns)
+	if err != nil {
+		return "", nil, err
+	}
+
+	orderBy, err := s.OrderBy(columns)
+	if err != nil {
+		return "", nil, err
+	}
+	return where + orderBy + " LIMIT ? OFFSET ?", append(args, s.Limit(), s.Offset()), nil
+}
@@ -0,0 +1,249 @@
+package persisted
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Options configures the middleware.
+type Options struct {
+	// Strict rejects every query that is not in the registry, whether it
+	// is sent by hash or as text. Without it, clients may register new
+	// queries by sending the text along with its hash.
+	Strict bool
+
+	// MaxBody bounds the request body; it defaults to 1 MiB.
+	MaxBody int64
+}
+
+type extension struct {
+	Version    int    `json:"version"`
+	SHA256Hash string `json:"sha256Hash"`
+}
+
+type request struct {
+	Query         string                     `json:"query,omitempty"`
+	OperationName string                     `json:"operationName,omitempty"`
+	Variables     json.RawMessage            `json:"variables,omitempty"`
+	Extensions    map[string]json.RawMessage `json:"extensions,omitempty"`
+}
+
+// Middleware resolves persisted queries before the GraphQL handler sees
+// the request, which then carries the full query text: in the JSON body
+// of a POST, or in the query parameter of a GET. Mutations sent by GET
+// are rejected, so a link or an image tag can't trigger one.
+func Middleware(registry Registry, opts Options) func(http.Handler) http.Handler {
+	if opts.MaxBody <= 0 {
+		opts.MaxBody = 1 << 20
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var payload request
+
+			switch r.Method {
+			case http.MethodPost:
+				raw, err := io.ReadAll(io.LimitReader(r.Body, opts.MaxBody+1))
+				if err != nil || int64(len(raw)) > opts.MaxBody {
+					failure(w, http.StatusRequestEntityTooLarge, "request body too large")
+					return
+				}
+
+				if err := json.Unmarshal(raw, &payload); err != nil {
+					// Not a single JSON operation (e.g. a batch); only
+					// strict mode needs to look inside.
+					if opts.Strict {
+						failure(w, http.StatusBadRequest, "PersistedQueryRequired")
+						return
+					}
+
+					r.Body = io.NopCloser(bytes.NewReader(raw))
+					next.ServeHTTP(w, r)
+					return
+				}
+
+			case http.MethodGet:
+				query := r.URL.Query()
+				payload.Query = query.Get("query")
+				payload.OperationName = query.Get("operationName")
+
+				if value := query.Get("variables"); len(value) > 0 {
+					payload.Variables = json.RawMessage(value)
+				}
+
+				if value := query.Get("extensions"); len(value) > 0 {
+					if err := json.Unmarshal([]byte(value), &payload.Extensions); err != nil {
+						failure(w, http.StatusBadRequest, "malformed extensions")
+						return
+					}
+				}
+
+			default:
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !resolve(w, registry, opts, &payload) {
+				return
+			}
+
+			forward := r.Clone(r.Context())
+
+			if r.Method == http.MethodGet {
+				if mutation(payload.Query) {
+					w.Header().Set("Allow", http.MethodPost)
+					failure(w, http.StatusMethodNotAllowed, "mutations require POST")
+					return
+				}
+
+				query := forward.URL.Query()
+				query.Set("query", payload.Query)
+				forward.URL.RawQuery = query.Encode()
+
+				next.ServeHTTP(w, forward)
+				return
+			}
+
+			raw, _ := json.Marshal(payload)
+			forward.URL.RawQuery = ""
+			forward.Header.Set("Content-Type", "application/json")
+			forward.Header.Del("Content-Length")
+			forward.ContentLength = int64(len(raw))
+			forward.Body = io.NopCloser(bytes.NewReader(raw))
+
+			next.ServeHTTP(w, forward)
+		})
+	}
+}
+
+// resolve fills payload.Query from the registry and reports whether the
+// request may proceed; otherwise an error response has been written.
+func resolve(w http.ResponseWriter, registry Registry, opts Options, payload *request) bool {
+	var persisted extension
+
+	if raw, ok := payload.Extensions["persistedQuery"]; ok {
+		if err := json.Unmarshal(raw, &persisted); err != nil || persisted.Version != 1 {
+			failure(w, http.StatusBadRequest, "unsupported persistedQuery extension")
+			return false
+		}
+	}
+
+	hash := strings.ToLower(persisted.SHA256Hash)
+
+	switch {
+	case len(hash) == 0 && len(payload.Query) == 0:
+		failure(w, http.StatusBadRequest, "missing query")
+		return false
+
+	case len(hash) == 0:
+		if opts.Strict {
+			if _, ok := registry.Get(Hash(payload.Query)); !ok {
+				failure(w, http.StatusForbidden, "PersistedQueryNotAllowed")
+				return false
+			}
+		}
+		return true
+
+	case len(payload.Query) == 0:
+		query, ok := registry.Get(hash)
+		if !ok {
+			// Clients react to this message by retrying with the text.
+			failure(w, http.StatusOK, "PersistedQueryNotFound")
+			return false
+		}
+
+		payload.Query = query
+		return true
+	}
+
+	if Hash(payload.Query) != hash {
+		failure(w, http.StatusBadRequest, "provided sha does not match query")
+		return false
+	}
+
+	if _, ok := registry.Get(hash); !ok {
+		if opts.Strict {
+			failure(w, http.StatusForbidden, "PersistedQueryNotAllowed")
+			return false
+		}
+		registry.Put(hash, payload.Query)
+	}
+	return true
+}
+
+// mutation reports whether a document defines a mutation. It only tracks
+// nesting, strings and comments to find the keyword of each top-level
+// definition; the GraphQL handler still does the real parsing.
+func mutation(document string) bool {
+	depth := 0
+	definition := true
+
+	for i := 0; i < len(document); i++ {
+		c := document[i]
+
+		switch {
+		case c == '#':
+			for i < len(document) && document[i] != '\n' {
+				i++
+			}
+
+		case strings.HasPrefix(document[i:], `"""`):
+			end := strings.Index(document[i+3:], `"""`)
+			for end >= 0 && document[i+3+end-1] == '\\' {
+				next := strings.Index(document[i+3+end+3:], `"""`)
+				if next < 0 {
+					end = -1
+					break
+				}
+				end += 3 + next
+			}
+			if end < 0 {
+				return false
+			}
+			i += 3 + end + 2
+
+		case c == '"':
+			for i++; i < len(document) && document[i] != '"'; i++ {
+				if document[i] == '\\' {
+					i++
+				}
+			}
+
+		case c == '{' || c == '(' || c == '[':
+			depth++
+
+		case c == '}' || c == ')' || c == ']':
+			depth--
+			if depth == 0 && c == '}' {
+				definition = true
+			}
+
+		case depth == 0 && (c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'):
+			start := i
+			for i+1 < len(document) && name(document[i+1]) {
+				i++
+			}
+			if definition && document[start:i+1] == "mutation" {
+				return true
+			}
+			definition = false
+		}
+	}
+	return false
+}
+
+func name(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+func failure(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]string{{"message": message}},
+	})
+}
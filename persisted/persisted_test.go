@@ -0,0 +1,204 @@
+package persisted
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	known = "{ jobs { id } }"
+	write = "mutation { restart(id: 1) }"
+)
+
+func TestMiddleware(t *testing.T) {
+	extension := func(query string) string {
+		return `{"persistedQuery":{"version":1,"sha256Hash":"` + Hash(query) + `"}}`
+	}
+	body := func(query, extensions string) string {
+		payload := map[string]interface{}{}
+		if len(query) > 0 {
+			payload["query"] = query
+		}
+		if len(extensions) > 0 {
+			payload["extensions"] = json.RawMessage(extensions)
+		}
+		raw, _ := json.Marshal(payload)
+		return string(raw)
+	}
+
+	other := "{ users { id } }"
+
+	tests := []struct {
+		name    string
+		strict  bool
+		method  string
+		body    string
+		query   url.Values
+		code    int
+		message string
+		forward string
+	}{
+		{"hash only", false, "POST", body("", extension(known)), nil, 200, "", known},
+		{"hash by GET", false, "GET", "", url.Values{"extensions": {extension(known)}}, 200, "", known},
+		{"text by GET", false, "GET", "", url.Values{"query": {other}}, 200, "", other},
+		{"mutation by GET", false, "GET", "", url.Values{"query": {"mutation { drop }"}}, 405, "mutations require POST", ""},
+		{"mutation hash by GET", false, "GET", "", url.Values{"extensions": {extension(write)}}, 405, "mutations require POST", ""},
+		{"mutation by POST", false, "POST", body(write, ""), nil, 200, "", write},
+		{"unknown hash", false, "POST", body("", extension(other)), nil, 200, "PersistedQueryNotFound", ""},
+		{"register", false, "POST", body(other, extension(other)), nil, 200, "", other},
+		{"wrong hash", false, "POST", body(known, extension(other)), nil, 400, "provided sha does not match query", ""},
+		{"plain text", false, "POST", body(other, ""), nil, 200, "", other},
+		{"missing query", false, "POST", body("", ""), nil, 400, "missing query", ""},
+		{"bad version", false, "POST", body("", `{"persistedQuery":{"version":2}}`), nil, 400, "unsupported persistedQuery extension", ""},
+		{"strict known text", true, "POST", body(known, ""), nil, 200, "", known},
+		{"strict unknown text", true, "POST", body(other, ""), nil, 403, "PersistedQueryNotAllowed", ""},
+		{"strict register", true, "POST", body(other, extension(other)), nil, 403, "PersistedQueryNotAllowed", ""},
+		{"strict batch", true, "POST", "[]", nil, 400, "PersistedQueryRequired", ""},
+		{"batch passes through", false, "POST", "[]", nil, 200, "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			registry := NewMemory()
+			registry.Add(known, write)
+
+			var forwarded string
+			handler := Middleware(registry, Options{Strict: test.strict})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != test.method {
+					t.Errorf("forwarded as %s", r.Method)
+				}
+				if r.Method == http.MethodGet {
+					forwarded = r.URL.Query().Get("query")
+					return
+				}
+
+				var payload request
+				raw, _ := io.ReadAll(r.Body)
+				json.Unmarshal(raw, &payload)
+				forwarded = payload.Query
+			}))
+
+			target := "/query"
+			if test.query != nil {
+				target += "?" + test.query.Encode()
+			}
+			r := httptest.NewRequest(test.method, target, strings.NewReader(test.body))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != test.code {
+				t.Errorf("code = %d; want %d", w.Code, test.code)
+			}
+			if len(test.message) > 0 && !strings.Contains(w.Body.String(), test.message) {
+				t.Errorf("body = %s; want %s", w.Body.String(), test.message)
+			}
+			if forwarded != test.forward {
+				t.Errorf("forwarded %q; want %q", forwarded, test.forward)
+			}
+		})
+	}
+}
+
+func TestRegisterThenHash(t *testing.T) {
+	registry := NewMemory()
+	handler := Middleware(registry, Options{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	raw, _ := json.Marshal(map[string]interface{}{
+		"query":      known,
+		"extensions": map[string]interface{}{"persistedQuery": map[string]interface{}{"version": 1, "sha256Hash": Hash(known)}},
+	})
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/query", strings.NewReader(string(raw))))
+
+	if query, ok := registry.Get(strings.ToUpper(Hash(known))); !ok || query != known {
+		t.Errorf("registered query = %q, %v", query, ok)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.graphql"), []byte(known), 0o644)
+	os.WriteFile(filepath.Join(dir, "b.txt"), []byte("ignored"), 0o644)
+
+	good, _ := json.Marshal(map[string]string{Hash("{ a }"): "{ a }"})
+	bad, _ := json.Marshal(map[string]string{Hash("{ a }"): "{ b }"})
+	os.WriteFile(filepath.Join(dir, "good.json"), good, 0o644)
+	os.WriteFile(filepath.Join(dir, "bad.json"), bad, 0o644)
+
+	tests := []struct {
+		name string
+		load func(m *Memory) error
+		size int
+		fail bool
+	}{
+		{"dir", func(m *Memory) error { return m.LoadDir(dir) }, 1, false},
+		{"manifest", func(m *Memory) error { return m.LoadManifest(filepath.Join(dir, "good.json")) }, 1, false},
+		{"tampered manifest", func(m *Memory) error { return m.LoadManifest(filepath.Join(dir, "bad.json")) }, 0, true},
+		{"missing manifest", func(m *Memory) error { return m.LoadManifest(filepath.Join(dir, "none.json")) }, 0, true},
+	}
+
+	for _, test := range tests {
+		m := NewMemory()
+		err := test.load(m)
+
+		if (err != nil) != test.fail {
+			t.Errorf("%s: error = %v", test.name, err)
+		}
+		if m.Len() != test.size {
+			t.Errorf("%s: %d queries; want %d", test.name, m.Len(), test.size)
+		}
+	}
+}
+
+func TestMutation(t *testing.T) {
+	tests := []struct {
+		document string
+		want     bool
+	}{
+		{"{ jobs { id } }", false},
+		{"query Jobs { jobs { id } }", false},
+		{"mutation { restart }", true},
+		{"  # comment\n mutation Restart($id: ID!) { restart(id: $id) }", true},
+		{"query mutation { jobs }", false},
+		{"{ jobs(filter: \"mutation\") { id } }", false},
+		{"query Q($f: F = {mutation: 1}) { jobs }", false},
+		{"query Q { a } mutation M { b }", true},
+		{"fragment F on Job { id } query { jobs { ...F } }", false},
+		{"query { a(s: \"\"\"block \\\"\"\" } mutation\"\"\") }", false},
+	}
+
+	for _, test := range tests {
+		if got := mutation(test.document); got != test.want {
+			t.Errorf("mutation(%q) = %v; want %v", test.document, got, test.want)
+		}
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	m := NewMemory()
+	m.Limit = 2
+	m.Add(known)
+
+	queries := []string{"{ a }", "{ b }", "{ c }"}
+	for _, query := range queries {
+		m.Put(Hash(query), query)
+	}
+
+	if _, ok := m.Get(Hash("{ a }")); ok {
+		t.Error("oldest learned query kept beyond the limit")
+	}
+	for _, query := range []string{known, "{ b }", "{ c }"} {
+		if _, ok := m.Get(Hash(query)); !ok {
+			t.Errorf("%q evicted", query)
+		}
+	}
+	if m.Len() != 3 {
+		t.Errorf("Len = %d; want 3", m.Len())
+	}
+}
@@ -0,0 +1,171 @@
+// Package persisted implements persisted GraphQL queries in front of a
+// /query handler. Clients send the SHA-256 hash of a query instead of its
+// text, following the automatic persisted queries wire format, and an
+// optional strict mode turns the registry into an allowlist.
+package persisted
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Hash returns the hex SHA-256 of a query, as clients compute it.
+func Hash(query string) string {
+	digest := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(digest[:])
+}
+
+// Registry maps query hashes to query text.
+type Registry interface {
+	Get(hash string) (string, bool)
+	Put(hash, query string)
+}
+
+// Memory is a Registry kept in process memory. Queries registered with
+// Add, LoadDir or LoadManifest stay for good; queries learned from clients
+// through Put are capped at Limit, dropping the oldest first, so clients
+// can't grow the registry without bound.
+type Memory struct {
+	// Limit bounds the learned queries; NewMemory sets it to 1000. Put
+	// keeps nothing when it is not positive.
+	Limit int
+
+	mu      sync.RWMutex
+	queries map[string]string
+	learned map[string]string
+	order   []string
+}
+
+// NewMemory creates an empty registry.
+func NewMemory() *Memory {
+	return &Memory{
+		Limit:   1000,
+		queries: make(map[string]string),
+		learned: make(map[string]string),
+	}
+}
+
+// Get implements Registry.
+func (m *Memory) Get(hash string) (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	hash = strings.ToLower(hash)
+	if query, ok := m.queries[hash]; ok {
+		return query, true
+	}
+	query, ok := m.learned[hash]
+	return query, ok
+}
+
+// Put implements Registry by learning a query, evicting the oldest
+// learned one beyond Limit.
+func (m *Memory) Put(hash, query string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hash = strings.ToLower(hash)
+	if _, ok := m.queries[hash]; ok {
+		return
+	}
+	if _, ok := m.learned[hash]; ok {
+		m.learned[hash] = query
+		return
+	}
+
+	m.learned[hash] = query
+	m.order = append(m.order, hash)
+
+	for len(m.order) > 0 && len(m.order) > m.Limit {
+		delete(m.learned, m.order[0])
+		m.order = m.order[1:]
+	}
+}
+
+// register adds a query that is never evicted.
+func (m *Memory) register(hash, query string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hash = strings.ToLower(hash)
+	m.queries[hash] = query
+
+	if _, ok := m.learned[hash]; ok {
+		delete(m.learned, hash)
+		for i, learned := range m.order {
+			if learned == hash {
+				m.order = append(m.order[:i], m.order[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// Add registers queries under their computed hashes.
+func (m *Memory) Add(queries ...string) {
+	for _, query := range queries {
+		m.register(Hash(query), query)
+	}
+}
+
+// Len returns the number of registered and learned queries.
+func (m *Memory) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.queries) + len(m.learned)
+}
+
+// LoadDir registers every *.graphql file of dir.
+func (m *Memory) LoadDir(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.graphql"))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		m.Add(string(raw))
+	}
+	return nil
+}
+
+// LoadManifest registers a JSON object mapping hashes to queries, as
+// produced by common client build tools. Hashes that don't match their
+// query are rejected so a tampered manifest can't smuggle queries in.
+func (m *Memory) LoadManifest(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var manifest map[string]string
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return err
+	}
+
+	for hash, query := range manifest {
+		if !strings.EqualFold(hash, Hash(query)) {
+			return &MismatchError{Hash: hash}
+		}
+		m.register(hash, query)
+	}
+	return nil
+}
+
+// MismatchError reports a hash that doesn't match its query.
+type MismatchError struct {
+	Hash string
+}
+
+func (e *MismatchError) Error() string {
+	return "persisted: hash " + e.Hash + " does not match its query"
+}
@@ -0,0 +1,111 @@
+// Package priority classifies requests and jobs as interactive, batch or
+// maintenance work, so bulk automation yields to people under
+// contention. The class travels in the X-Priority header or a job's
+// configuration and maps onto workerpool priorities and load-shedding
+// headroom.
+package priority
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"devops.io/cloud/workerpool"
+)
+
+// Header carries the class of an API request.
+const Header = "X-Priority"
+
+// Class is a priority class.
+type Class string
+
+// Classes from most to least urgent.
+const (
+	Interactive Class = "interactive"
+	Batch       Class = "batch"
+	Maintenance Class = "maintenance"
+)
+
+// Parse returns the class named by value, ignoring case.
+func Parse(value string) (Class, bool) {
+	switch class := Class(strings.ToLower(strings.TrimSpace(value))); class {
+	case Interactive, Batch, Maintenance:
+		return class, true
+	}
+	return "", false
+}
+
+// Pool returns the workerpool priority of jobs of the class.
+func (c Class) Pool() workerpool.Priority {
+	switch c {
+	case Batch:
+		return workerpool.Normal
+	case Maintenance:
+		return workerpool.Low
+	default:
+		return workerpool.High
+	}
+}
+
+// Share returns the fraction of a concurrency limit the class may fill,
+// keeping headroom for more urgent classes.
+func (c Class) Share() float64 {
+	switch c {
+	case Batch:
+		return 0.8
+	case Maintenance:
+		return 0.5
+	default:
+		return 1
+	}
+}
+
+type contextKey struct{}
+
+// WithClass returns a context carrying class.
+func WithClass(ctx context.Context, class Class) context.Context {
+	return context.WithValue(ctx, contextKey{}, class)
+}
+
+// FromContext returns the class carried by ctx, Interactive by default.
+func FromContext(ctx context.Context) Class {
+	if class, ok := ctx.Value(contextKey{}).(Class); ok {
+		return class
+	}
+	return Interactive
+}
+
+// FromRequest returns the class of r: the one stored by Middleware, else
+// the header's, else Interactive.
+func FromRequest(r *http.Request) Class {
+	if class, ok := r.Context().Value(contextKey{}).(Class); ok {
+		return class
+	}
+	if class, ok := Parse(r.Header.Get(Header)); ok {
+		return class
+	}
+	return Interactive
+}
+
+// Share is FromRequest(r).Share(), suitable for loadshed.Options.Share.
+func Share(r *http.Request) float64 {
+	return FromRequest(r).Share()
+}
+
+// Middleware stores the class of every request in its context, so jobs
+// submitted by the handler inherit it.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(WithClass(r.Context(), FromRequest(r))))
+	})
+}
+
+// Submit queues job on pool at the priority of the class carried by ctx.
+// Only the class is taken from ctx: the job must outlive the request
+// that submitted it.
+func Submit(ctx context.Context, pool *workerpool.Pool, job workerpool.Job) (*workerpool.Task, error) {
+	return pool.Submit(workerpool.Spec{
+		Priority: FromContext(ctx).Pool(),
+		Run:      job,
+	})
+}
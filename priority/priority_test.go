@@ -0,0 +1,134 @@
+package priority
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"devops.io/cloud/workerpool"
+)
+
+func TestClasses(t *testing.T) {
+	tests := []struct {
+		value string
+		class Class
+		ok    bool
+		pool  workerpool.Priority
+		share float64
+	}{
+		{"interactive", Interactive, true, workerpool.High, 1},
+		{" Batch ", Batch, true, workerpool.Normal, 0.8},
+		{"MAINTENANCE", Maintenance, true, workerpool.Low, 0.5},
+		{"urgent", "", false, workerpool.High, 1},
+		{"", "", false, workerpool.High, 1},
+	}
+
+	for _, test := range tests {
+		class, ok := Parse(test.value)
+		if class != test.class || ok != test.ok {
+			t.Errorf("Parse(%q) = %q, %v; want %q, %v", test.value, class, ok, test.class, test.ok)
+		}
+		if class.Pool() != test.pool || class.Share() != test.share {
+			t.Errorf("%q: pool %d share %v; want %d %v", test.value, class.Pool(), class.Share(), test.pool, test.share)
+		}
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		context Class
+		want    Class
+	}{
+		{"default", "", "", Interactive},
+		{"header", "batch", "", Batch},
+		{"invalid header", "urgent", "", Interactive},
+		{"context wins", "batch", Maintenance, Maintenance},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set(Header, test.header)
+		if len(test.context) > 0 {
+			r = r.WithContext(WithClass(r.Context(), test.context))
+		}
+
+		if got := FromRequest(r); got != test.want {
+			t.Errorf("%s: FromRequest = %q; want %q", test.name, got, test.want)
+		}
+		if got := Share(r); got != test.want.Share() {
+			t.Errorf("%s: Share = %v; want %v", test.name, got, test.want.Share())
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var got Class
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = FromContext(r.Context())
+	}))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(Header, "maintenance")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	if got != Maintenance {
+		t.Errorf("class in context = %q; want %q", got, Maintenance)
+	}
+	if FromContext(context.Background()) != Interactive {
+		t.Error("empty context isn't interactive")
+	}
+}
+
+func TestSubmit(t *testing.T) {
+	pool := workerpool.New(workerpool.Options{Workers: 1})
+	defer pool.Stop()
+
+	// Occupy the only worker so the next jobs queue up.
+	release := make(chan struct{})
+	pool.Go(func(context.Context) error {
+		<-release
+		return nil
+	})
+
+	var order []Class
+	tests := []Class{Maintenance, Batch, Interactive}
+	var tasks []*workerpool.Task
+
+	for _, class := range tests {
+		class := class
+
+		// The request context ends before the job runs; the job must not
+		// be cancelled with it.
+		ctx, cancel := context.WithCancel(WithClass(context.Background(), class))
+		task, err := Submit(ctx, pool, func(ctx context.Context) error {
+			order = append(order, class)
+			return ctx.Err()
+		})
+		cancel()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task.Priority() != class.Pool() {
+			t.Errorf("%s job priority = %d; want %d", class, task.Priority(), class.Pool())
+		}
+		tasks = append(tasks, task)
+	}
+
+	close(release)
+	for _, task := range tasks {
+		if err := task.Wait(); err != nil {
+			t.Errorf("job error = %v", err)
+		}
+	}
+
+	want := []Class{Interactive, Batch, Maintenance}
+	for i := range want {
+		if i >= len(order) || order[i] != want[i] {
+			t.Fatalf("run order = %v; want %v", order, want)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Memory is an in-process Queue. It is the default backend for a single
+// server instance and honors the same visibility semantics as the shared
+// backends.
+type Memory struct {
+	mu     sync.Mutex
+	queues map[string]*memoryQueue
+	wake   chan struct{}
+	closed bool
+}
+
+type memoryEntry struct {
+	message   Message
+	visibleAt time.Time
+	receipt   string
+}
+
+type memoryQueue struct {
+	pending  []*memoryEntry
+	inflight map[string]*memoryEntry
+}
+
+// NewMemory creates an empty in-memory queue.
+func NewMemory() *Memory {
+	return &Memory{
+		queues: make(map[string]*memoryQueue),
+		wake:   make(chan struct{}),
+	}
+}
+
+func (m *Memory) queue(name string) *memoryQueue {
+	item, ok := m.queues[name]
+	if !ok {
+		item = &memoryQueue{inflight: make(map[string]*memoryEntry)}
+		m.queues[name] = item
+	}
+	return item
+}
+
+// notify wakes every blocked Dequeue. It must be called with m.mu held.
+func (m *Memory) notify() {
+	close(m.wake)
+	m.wake = make(chan struct{})
+}
+
+// Enqueue implements Queue.
+func (m *Memory) Enqueue(ctx context.Context, queue string, body []byte) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return "", ErrClosed
+	}
+
+	now := time.Now()
+	entry := &memoryEntry{
+		message: Message{
+			ID:         newID(),
+			Queue:      queue,
+			Body:       append([]byte(nil), body...),
+			EnqueuedAt: now,
+		},
+		visibleAt: now,
+	}
+
+	target := m.queue(queue)
+	target.pending = append(target.pending, entry)
+	m.notify()
+
+	return entry.message.ID, nil
+}
+
+// Dequeue implements Queue.
+func (m *Memory) Dequeue(ctx context.Context, queue string, visibility time.Duration) (*Delivery, error) {
+	for {
+		m.mu.Lock()
+		if m.closed {
+			m.mu.Unlock()
+			return nil, ErrClosed
+		}
+
+		now := time.Now()
+		target := m.queue(queue)
+		target.reclaim(now)
+
+		if delivery := target.take(now, visibility); delivery != nil {
+			m.mu.Unlock()
+			return delivery, nil
+		}
+
+		wake := m.wake
+		next := target.nextEvent()
+		m.mu.Unlock()
+
+		var timer *time.Timer
+		var expired <-chan time.Time
+
+		if !next.IsZero() {
+			timer = time.NewTimer(next.Sub(now))
+			expired = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-wake:
+		case <-expired:
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Ack implements Queue.
+func (m *Memory) Ack(ctx context.Context, delivery *Delivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	target := m.queue(delivery.Queue)
+	if _, ok := target.inflight[delivery.Receipt]; !ok {
+		return ErrUnknownReceipt
+	}
+
+	delete(target.inflight, delivery.Receipt)
+	return nil
+}
+
+// Nack implements Queue.
+func (m *Memory) Nack(ctx context.Context, delivery *Delivery, delay time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	target := m.queue(delivery.Queue)
+	entry, ok := target.inflight[delivery.Receipt]
+	if !ok {
+		return ErrUnknownReceipt
+	}
+
+	delete(target.inflight, delivery.Receipt)
+	entry.receipt = ""
+	entry.visibleAt = time.Now().Add(delay)
+	target.pending = append(target.pending, entry)
+	m.notify()
+
+	return nil
+}
+
+// Extend implements Queue.
+func (m *Memory) Extend(ctx context.Context, delivery *Delivery, visibility time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.queue(delivery.Queue).inflight[delivery.Receipt]
+	if !ok {
+		return ErrUnknownReceipt
+	}
+
+	entry.visibleAt = time.Now().Add(visibility)
+	delivery.Deadline = entry.visibleAt
+	return nil
+}
+
+// Close implements Queue; blocked consumers return ErrClosed.
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.closed {
+		m.closed = true
+		m.notify()
+	}
+	return nil
+}
+
+// Len reports how many messages are waiting and in flight on a queue.
+func (m *Memory) Len(queue string) (pending, inflight int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	target := m.queue(queue)
+	return len(target.pending), len(target.inflight)
+}
+
+// reclaim moves deliveries whose visibility timeout expired back to the
+// pending list.
+func (q *memoryQueue) reclaim(now time.Time) {
+	for receipt, entry := range q.inflight {
+		if !now.Before(entry.visibleAt) {
+			delete(q.inflight, receipt)
+			entry.receipt = ""
+			q.pending = append(q.pending, entry)
+		}
+	}
+}
+
+func (q *memoryQueue) take(now time.Time, visibility time.Duration) *Delivery {
+	for i, entry := range q.pending {
+		if now.Before(entry.visibleAt) {
+			continue
+		}
+
+		q.pending = append(q.pending[:i], q.pending[i+1:]...)
+
+		entry.message.Attempts++
+		entry.receipt = newID()
+		entry.visibleAt = now.Add(visibility)
+		q.inflight[entry.receipt] = entry
+
+		return &Delivery{
+			Message:  entry.message,
+			Receipt:  entry.receipt,
+			Deadline: entry.visibleAt,
+		}
+	}
+	return nil
+}
+
+// nextEvent returns when a delayed or in-flight message becomes visible,
+// or the zero time when nothing is scheduled.
+func (q *memoryQueue) nextEvent() time.Time {
+	var next time.Time
+
+	consider := func(at time.Time) {
+		if next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+
+	for _, entry := range q.pending {
+		consider(entry.visibleAt)
+	}
+
+	for _, entry := range q.inflight {
+		consider(entry.visibleAt)
+	}
+	return next
+}
+
+func newID() string {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(raw)
+}
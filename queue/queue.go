@@ -0,0 +1,89 @@
+// Package queue defines the job queue shared by server instances.
+// Delivery is at-least-once: a dequeued message stays invisible for its
+// visibility timeout and is handed out again unless it is acknowledged in
+// time, so consumers must tolerate duplicates.
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrClosed is returned once the queue has been closed.
+	ErrClosed = errors.New("queue: closed")
+
+	// ErrUnknownReceipt is returned when acknowledging a delivery whose
+	// visibility timeout already expired or that was already settled.
+	ErrUnknownReceipt = errors.New("queue: unknown or expired receipt")
+)
+
+// Message is a unit of work stored in a queue.
+type Message struct {
+	ID         string
+	Queue      string
+	Body       []byte
+	Attempts   int
+	EnqueuedAt time.Time
+}
+
+// Delivery is a message handed to a consumer. Receipt identifies this
+// particular delivery and is required to settle it.
+type Delivery struct {
+	Message
+	Receipt  string
+	Deadline time.Time
+}
+
+// Queue is implemented by every backend.
+type Queue interface {
+	// Enqueue stores body on the named queue and returns the message ID.
+	Enqueue(ctx context.Context, queue string, body []byte) (string, error)
+
+	// Dequeue blocks until a message is available or ctx is done. The
+	// message stays invisible to other consumers for visibility.
+	Dequeue(ctx context.Context, queue string, visibility time.Duration) (*Delivery, error)
+
+	// Ack removes a delivered message permanently.
+	Ack(ctx context.Context, delivery *Delivery) error
+
+	// Nack makes a delivered message visible again after delay.
+	Nack(ctx context.Context, delivery *Delivery, delay time.Duration) error
+
+	// Extend pushes the visibility deadline of a delivery that needs
+	// more time.
+	Extend(ctx context.Context, delivery *Delivery, visibility time.Duration) error
+
+	Close() error
+}
+
+// Handler processes a delivered message.
+type Handler func(ctx context.Context, message Message) error
+
+// Consume dequeues from queue until ctx is done, acknowledging messages
+// handled without error and releasing failed ones after retryDelay.
+func Consume(ctx context.Context, backend Queue, queue string, visibility, retryDelay time.Duration, handler Handler) error {
+	for {
+		delivery, err := backend.Dequeue(ctx, queue, visibility)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		if err := handler(ctx, delivery.Message); err != nil {
+			err = backend.Nack(ctx, delivery, retryDelay)
+			if err != nil && !errors.Is(err, ErrUnknownReceipt) {
+				return err
+			}
+			continue
+		}
+
+		err = backend.Ack(ctx, delivery)
+		if err != nil && !errors.Is(err, ErrUnknownReceipt) {
+			return err
+		}
+	}
+}
@@ -0,0 +1,484 @@
+package queue
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis implements the subset of Redis used by the Redis backend,
+// including WATCH semantics, so the backend can be tested without a
+// server.
+type fakeRedis struct {
+	listener net.Listener
+	mu       sync.Mutex
+	hashes   map[string]map[string]string
+	sets     map[string]map[string]float64
+	versions map[string]int
+
+	// slowExec delays EXEC replies after the commands ran.
+	slowExec time.Duration
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeRedis{
+		listener: listener,
+		hashes:   make(map[string]map[string]string),
+		sets:     make(map[string]map[string]float64),
+		versions: make(map[string]int),
+	}
+	t.Cleanup(func() {
+		listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	watched := map[string]int{}
+	var queued [][]string
+	multi := false
+
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+
+		name := strings.ToUpper(args[0])
+		var reply string
+
+		switch {
+		case name == "WATCH":
+			f.mu.Lock()
+			for _, key := range args[1:] {
+				watched[key] = f.versions[key]
+			}
+			f.mu.Unlock()
+			reply = "+OK\r\n"
+
+		case name == "UNWATCH":
+			watched = map[string]int{}
+			reply = "+OK\r\n"
+
+		case name == "MULTI":
+			multi = true
+			reply = "+OK\r\n"
+
+		case name == "DISCARD":
+			multi, queued, watched = false, nil, map[string]int{}
+			reply = "+OK\r\n"
+
+		case name == "EXEC":
+			f.mu.Lock()
+			conflict := false
+			for key, version := range watched {
+				if f.versions[key] != version {
+					conflict = true
+				}
+			}
+
+			if conflict {
+				reply = "*-1\r\n"
+			} else {
+				reply = fmt.Sprintf("*%d\r\n", len(queued))
+				for _, command := range queued {
+					reply += f.run(command)
+				}
+			}
+			delay := f.slowExec
+			f.mu.Unlock()
+			time.Sleep(delay)
+			multi, queued, watched = false, nil, map[string]int{}
+
+		case multi:
+			queued = append(queued, args)
+			reply = "+QUEUED\r\n"
+
+		default:
+			f.mu.Lock()
+			reply = f.run(args)
+			f.mu.Unlock()
+		}
+
+		if _, err := conn.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	count, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, count)
+
+	for i := range args {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		raw := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, raw); err != nil {
+			return nil, err
+		}
+		args[i] = string(raw[:size])
+	}
+	return args, nil
+}
+
+func bulk(value string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+}
+
+// run executes one command; it must be called with f.mu held.
+func (f *fakeRedis) run(args []string) string {
+	key := ""
+	if len(args) > 1 {
+		key = args[1]
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "HSET":
+		if f.hashes[key] == nil {
+			f.hashes[key] = map[string]string{}
+		}
+		for i := 2; i+1 < len(args); i += 2 {
+			f.hashes[key][args[i]] = args[i+1]
+		}
+		f.versions[key]++
+		return ":1\r\n"
+
+	case "HGET":
+		value, ok := f.hashes[key][args[2]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return bulk(value)
+
+	case "HGETALL":
+		fields := f.hashes[key]
+		reply := fmt.Sprintf("*%d\r\n", 2*len(fields))
+		for field, value := range fields {
+			reply += bulk(field) + bulk(value)
+		}
+		return reply
+
+	case "DEL":
+		delete(f.hashes, key)
+		delete(f.sets, key)
+		f.versions[key]++
+		return ":1\r\n"
+
+	case "ZADD":
+		if f.sets[key] == nil {
+			f.sets[key] = map[string]float64{}
+		}
+		score, _ := strconv.ParseFloat(args[2], 64)
+		f.sets[key][args[3]] = score
+		f.versions[key]++
+		return ":1\r\n"
+
+	case "ZREM":
+		delete(f.sets[key], args[2])
+		f.versions[key]++
+		return ":1\r\n"
+
+	case "ZRANGE":
+		type member struct {
+			name  string
+			score float64
+		}
+
+		var members []member
+		for name, score := range f.sets[key] {
+			members = append(members, member{name, score})
+		}
+		sort.Slice(members, func(i, j int) bool {
+			if members[i].score != members[j].score {
+				return members[i].score < members[j].score
+			}
+			return members[i].name < members[j].name
+		})
+
+		if len(members) == 0 {
+			return "*0\r\n"
+		}
+		return "*2\r\n" + bulk(members[0].name) + bulk(strconv.FormatFloat(members[0].score, 'f', -1, 64))
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func TestBackends(t *testing.T) {
+	backends := []struct {
+		name string
+		open func(t *testing.T) Queue
+	}{
+		{"memory", func(t *testing.T) Queue {
+			return NewMemory()
+		}},
+		{"redis", func(t *testing.T) Queue {
+			return NewRedis(RedisOptions{
+				Addr: newFakeRedis(t).listener.Addr().String(),
+				Poll: 5 * time.Millisecond,
+			})
+		}},
+	}
+
+	scenarios := []struct {
+		name string
+		run  func(t *testing.T, q Queue)
+	}{
+		{"ack removes the message", testAck},
+		{"nack redelivers after the delay", testNack},
+		{"expired visibility redelivers", testExpiry},
+		{"extend keeps the message hidden", testExtend},
+		{"dequeue blocks until enqueue", testBlocking},
+		{"concurrent consumers each get one message", testConcurrent},
+	}
+
+	for _, backend := range backends {
+		for _, scenario := range scenarios {
+			t.Run(backend.name+"/"+scenario.name, func(t *testing.T) {
+				q := backend.open(t)
+				defer q.Close()
+
+				scenario.run(t, q)
+			})
+		}
+	}
+}
+
+func dequeue(t *testing.T, q Queue, visibility time.Duration) *Delivery {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	delivery, err := q.Dequeue(ctx, "jobs", visibility)
+	if err != nil {
+		t.Fatalf("Dequeue = %v", err)
+	}
+	return delivery
+}
+
+func empty(t *testing.T, q Queue, wait time.Duration) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), wait)
+	defer cancel()
+
+	if delivery, err := q.Dequeue(ctx, "jobs", time.Minute); err == nil {
+		t.Fatalf("Dequeue returned %q; want nothing", delivery.Body)
+	}
+}
+
+func testAck(t *testing.T, q Queue) {
+	ctx := context.Background()
+	id, _ := q.Enqueue(ctx, "jobs", []byte("build"))
+
+	delivery := dequeue(t, q, time.Minute)
+	if delivery.ID != id || string(delivery.Body) != "build" || delivery.Attempts != 1 {
+		t.Fatalf("delivery = %+v", delivery.Message)
+	}
+
+	if err := q.Ack(ctx, delivery); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Ack(ctx, delivery); !errors.Is(err, ErrUnknownReceipt) {
+		t.Errorf("second Ack = %v", err)
+	}
+	empty(t, q, 30*time.Millisecond)
+}
+
+func testNack(t *testing.T, q Queue) {
+	ctx := context.Background()
+	q.Enqueue(ctx, "jobs", []byte("deploy"))
+
+	first := dequeue(t, q, time.Minute)
+	if err := q.Nack(ctx, first, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	empty(t, q, 20*time.Millisecond)
+
+	second := dequeue(t, q, time.Minute)
+	if second.ID != first.ID || second.Attempts != 2 || second.Receipt == first.Receipt {
+		t.Errorf("redelivery = %+v", second)
+	}
+	if err := q.Ack(ctx, first); !errors.Is(err, ErrUnknownReceipt) {
+		t.Errorf("Ack with a stale receipt = %v", err)
+	}
+}
+
+func testExpiry(t *testing.T, q Queue) {
+	ctx := context.Background()
+	q.Enqueue(ctx, "jobs", []byte("test"))
+
+	first := dequeue(t, q, 30*time.Millisecond)
+	second := dequeue(t, q, time.Minute)
+
+	if second.ID != first.ID || second.Attempts != 2 {
+		t.Errorf("redelivery = %+v", second.Message)
+	}
+	if err := q.Ack(ctx, first); !errors.Is(err, ErrUnknownReceipt) {
+		t.Errorf("Ack of the expired delivery = %v", err)
+	}
+	if err := q.Ack(ctx, second); err != nil {
+		t.Errorf("Ack = %v", err)
+	}
+}
+
+func testExtend(t *testing.T, q Queue) {
+	ctx := context.Background()
+	q.Enqueue(ctx, "jobs", []byte("long"))
+
+	delivery := dequeue(t, q, 30*time.Millisecond)
+	if err := q.Extend(ctx, delivery, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if time.Until(delivery.Deadline) < 30*time.Second {
+		t.Errorf("Deadline = %v", delivery.Deadline)
+	}
+	empty(t, q, 60*time.Millisecond)
+}
+
+func testBlocking(t *testing.T, q Queue) {
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		q.Enqueue(context.Background(), "jobs", []byte("late"))
+	}()
+
+	if delivery := dequeue(t, q, time.Minute); string(delivery.Body) != "late" {
+		t.Errorf("Body = %q", delivery.Body)
+	}
+}
+
+func TestRedisClaimOutlivesContext(t *testing.T) {
+	f := newFakeRedis(t)
+	q := NewRedis(RedisOptions{Addr: f.listener.Addr().String()})
+	defer q.Close()
+
+	if _, err := q.Enqueue(context.Background(), "jobs", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+
+	f.mu.Lock()
+	f.slowExec = 100 * time.Millisecond
+	f.mu.Unlock()
+
+	// The claim commits while the caller's deadline passes; its delivery
+	// must still be handed out rather than hidden for the visibility.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	delivery, err := q.Dequeue(ctx, "jobs", time.Minute)
+	if err != nil || string(delivery.Body) != "x" {
+		t.Fatalf("Dequeue = %v, %v", delivery, err)
+	}
+}
+
+func testConcurrent(t *testing.T, q Queue) {
+	const count = 20
+
+	for i := 0; i < count; i++ {
+		q.Enqueue(context.Background(), "jobs", []byte(strconv.Itoa(i)))
+	}
+
+	// Workers stop once every message was delivered, not on the first
+	// empty poll, so a slow run can't end before the queue drained.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	seen := map[string]int{}
+	delivered := 0
+	var group sync.WaitGroup
+
+	for worker := 0; worker < 4; worker++ {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+
+			for {
+				delivery, err := q.Dequeue(ctx, "jobs", time.Minute)
+				if err != nil {
+					return
+				}
+
+				mu.Lock()
+				seen[string(delivery.Body)]++
+				if delivered++; delivered == count {
+					cancel()
+				}
+				mu.Unlock()
+				q.Ack(context.Background(), delivery)
+			}
+		}()
+	}
+	group.Wait()
+
+	if len(seen) != count {
+		t.Errorf("delivered %d distinct messages; want %d", len(seen), count)
+	}
+	for body, times := range seen {
+		if times != 1 {
+			t.Errorf("message %s delivered %d times", body, times)
+		}
+	}
+}
+
+func TestConsume(t *testing.T) {
+	q := NewMemory()
+	defer q.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q.Enqueue(ctx, "jobs", []byte("flaky"))
+
+	attempts := 0
+	err := Consume(ctx, q, "jobs", time.Minute, time.Millisecond, func(ctx context.Context, message Message) error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("transient")
+		}
+		cancel()
+		return nil
+	})
+
+	if err != nil || attempts != 3 {
+		t.Errorf("Consume = %v after %d attempts", err, attempts)
+	}
+	if pending, inflight := q.Len("jobs"); pending != 0 || inflight != 0 {
+		t.Errorf("Len = %d, %d", pending, inflight)
+	}
+}
@@ -0,0 +1,322 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// RedisOptions configures a Redis backend.
+type RedisOptions struct {
+	// Addr is the host:port of the server; it defaults to
+	// "localhost:6379".
+	Addr     string
+	Password string
+	DB       int
+
+	// Prefix namespaces every key; it defaults to "queue".
+	Prefix string
+
+	// Timeout bounds dialing and every command; it defaults to 5
+	// seconds.
+	Timeout time.Duration
+
+	// Poll is the longest a blocked Dequeue waits before looking for new
+	// messages again; it defaults to 200 milliseconds.
+	Poll time.Duration
+
+	// MaxIdle is how many idle connections are kept; it defaults to 8.
+	MaxIdle int
+}
+
+// Redis is a Queue shared by every server instance connected to the same
+// Redis server. Each queue is a sorted set of message IDs scored by the
+// time they become visible, so pending, delayed and in-flight messages
+// live in one place and expired deliveries need no separate reclaiming.
+// Message bodies and delivery state are kept in one hash per message.
+// Updates run in WATCH/MULTI/EXEC transactions and are retried on
+// conflict.
+type Redis struct {
+	pool *redisPool
+	opts RedisOptions
+}
+
+// NewRedis creates a Redis backend. Connections are opened on demand.
+func NewRedis(opts RedisOptions) *Redis {
+	if len(opts.Addr) == 0 {
+		opts.Addr = "localhost:6379"
+	}
+	if len(opts.Prefix) == 0 {
+		opts.Prefix = "queue"
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	if opts.Poll <= 0 {
+		opts.Poll = 200 * time.Millisecond
+	}
+	if opts.MaxIdle <= 0 {
+		opts.MaxIdle = 8
+	}
+
+	return &Redis{pool: &redisPool{opts: opts}, opts: opts}
+}
+
+func (r *Redis) schedule(queue string) string {
+	return r.opts.Prefix + ":" + queue + ":schedule"
+}
+
+func (r *Redis) message(queue, id string) string {
+	return r.opts.Prefix + ":" + queue + ":message:" + id
+}
+
+// Enqueue implements Queue.
+func (r *Redis) Enqueue(ctx context.Context, queue string, body []byte) (string, error) {
+	id := newID()
+	now := millis(time.Now())
+
+	_, err := r.transaction(ctx, nil, func(c *redisConn) ([][]string, error) {
+		return [][]string{
+			{"HSET", r.message(queue, id), "body", string(body), "attempts", "0", "enqueued", now, "receipt", ""},
+			{"ZADD", r.schedule(queue), now, id},
+		}, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Dequeue implements Queue.
+func (r *Redis) Dequeue(ctx context.Context, queue string, visibility time.Duration) (*Delivery, error) {
+	schedule := r.schedule(queue)
+
+	for {
+		var delivery *Delivery
+		wait := r.opts.Poll
+
+		_, err := r.transaction(ctx, []string{schedule}, func(c *redisConn) ([][]string, error) {
+			delivery = nil
+
+			reply, err := c.do(r.opts.Timeout, "ZRANGE", schedule, "0", "0", "WITHSCORES")
+			if err != nil {
+				return nil, err
+			}
+
+			head, _ := reply.([]interface{})
+			if len(head) < 2 {
+				return nil, nil
+			}
+
+			id, _ := head[0].(string)
+			score, _ := head[1].(string)
+			visibleAt, err := strconv.ParseFloat(score, 64)
+			if err != nil {
+				return nil, err
+			}
+
+			now := time.Now()
+			if until := time.Duration(int64(visibleAt)-now.UnixNano()/1e6) * time.Millisecond; until > 0 {
+				if until < wait {
+					wait = until
+				}
+				return nil, nil
+			}
+
+			reply, err = c.do(r.opts.Timeout, "HGETALL", r.message(queue, id))
+			if err != nil {
+				return nil, err
+			}
+
+			fields := hash(reply)
+			attempts, _ := strconv.Atoi(fields["attempts"])
+			enqueued, _ := strconv.ParseInt(fields["enqueued"], 10, 64)
+
+			delivery = &Delivery{
+				Message: Message{
+					ID:         id,
+					Queue:      queue,
+					Body:       []byte(fields["body"]),
+					Attempts:   attempts + 1,
+					EnqueuedAt: time.Unix(0, enqueued*1e6),
+				},
+				Receipt:  newID(),
+				Deadline: now.Add(visibility),
+			}
+
+			return [][]string{
+				{"ZADD", schedule, millis(delivery.Deadline), id},
+				{"HSET", r.message(queue, id), "attempts", strconv.Itoa(delivery.Attempts), "receipt", delivery.Receipt},
+			}, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if delivery != nil {
+			return delivery, nil
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Ack implements Queue.
+func (r *Redis) Ack(ctx context.Context, delivery *Delivery) error {
+	return r.settle(ctx, delivery, func() [][]string {
+		return [][]string{
+			{"ZREM", r.schedule(delivery.Queue), delivery.ID},
+			{"DEL", r.message(delivery.Queue, delivery.ID)},
+		}
+	})
+}
+
+// Nack implements Queue.
+func (r *Redis) Nack(ctx context.Context, delivery *Delivery, delay time.Duration) error {
+	return r.settle(ctx, delivery, func() [][]string {
+		return [][]string{
+			{"ZADD", r.schedule(delivery.Queue), millis(time.Now().Add(delay)), delivery.ID},
+			{"HSET", r.message(delivery.Queue, delivery.ID), "receipt", ""},
+		}
+	})
+}
+
+// Extend implements Queue.
+func (r *Redis) Extend(ctx context.Context, delivery *Delivery, visibility time.Duration) error {
+	deadline := time.Now().Add(visibility)
+
+	err := r.settle(ctx, delivery, func() [][]string {
+		return [][]string{
+			{"ZADD", r.schedule(delivery.Queue), millis(deadline), delivery.ID},
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	delivery.Deadline = deadline
+	return nil
+}
+
+// settle runs the commands of update once it checked that delivery still
+// holds the message.
+func (r *Redis) settle(ctx context.Context, delivery *Delivery, update func() [][]string) error {
+	key := r.message(delivery.Queue, delivery.ID)
+
+	_, err := r.transaction(ctx, []string{key}, func(c *redisConn) ([][]string, error) {
+		reply, err := c.do(r.opts.Timeout, "HGET", key, "receipt")
+		if err != nil {
+			return nil, err
+		}
+
+		if receipt, _ := reply.(string); len(receipt) == 0 || receipt != delivery.Receipt {
+			return nil, ErrUnknownReceipt
+		}
+		return update(), nil
+	})
+	return err
+}
+
+// Close implements Queue. Calls in progress finish; later ones return
+// ErrClosed.
+func (r *Redis) Close() error {
+	r.pool.close()
+	return nil
+}
+
+// transaction watches keys, lets prepare read what it needs and queues the
+// commands prepare returns in MULTI/EXEC. It starts over when a watched
+// key changed in the meantime. prepare returning no commands ends the
+// transaction without running anything. ctx is only checked before each
+// attempt; one that started runs to completion so its outcome is known.
+func (r *Redis) transaction(ctx context.Context, keys []string, prepare func(c *redisConn) ([][]string, error)) ([]interface{}, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		c, err := r.pool.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		replies, err := r.attempt(c, keys, prepare)
+		r.pool.put(c, err)
+
+		if err != errConflict {
+			return replies, err
+		}
+	}
+}
+
+var errConflict = errors.New("queue: redis: transaction conflict")
+
+func (r *Redis) attempt(c *redisConn, keys []string, prepare func(c *redisConn) ([][]string, error)) ([]interface{}, error) {
+	if len(keys) > 0 {
+		if _, err := c.do(r.opts.Timeout, append([]string{"WATCH"}, keys...)...); err != nil {
+			return nil, err
+		}
+	}
+
+	commands, err := prepare(c)
+	if err != nil || len(commands) == 0 {
+		if len(keys) > 0 {
+			if _, unwatch := c.do(r.opts.Timeout, "UNWATCH"); unwatch != nil {
+				return nil, unwatch
+			}
+		}
+		return nil, err
+	}
+
+	if _, err := c.do(r.opts.Timeout, "MULTI"); err != nil {
+		return nil, err
+	}
+
+	for _, command := range commands {
+		if _, err := c.do(r.opts.Timeout, command...); err != nil {
+			c.do(r.opts.Timeout, "DISCARD")
+			return nil, err
+		}
+	}
+
+	reply, err := c.do(r.opts.Timeout, "EXEC")
+	if err != nil {
+		return nil, err
+	}
+
+	replies, ok := reply.([]interface{})
+	if !ok {
+		return nil, errConflict
+	}
+
+	for _, item := range replies {
+		if err, ok := item.(error); ok {
+			return nil, err
+		}
+	}
+	return replies, nil
+}
+
+// hash turns an HGETALL reply into a map.
+func hash(reply interface{}) map[string]string {
+	items, _ := reply.([]interface{})
+	fields := make(map[string]string, len(items)/2)
+
+	for i := 0; i+1 < len(items); i += 2 {
+		key, _ := items[i].(string)
+		value, _ := items[i+1].(string)
+		fields[key] = value
+	}
+	return fields
+}
+
+func millis(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano()/1e6, 10)
+}
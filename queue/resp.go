@@ -0,0 +1,184 @@
+package queue
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// redisError is an error reply sent by the server.
+type redisError string
+
+func (e redisError) Error() string {
+	return "queue: redis: " + string(e)
+}
+
+// redisConn is one connection speaking RESP, the Redis protocol.
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+// do sends one command and reads its reply: a string for simple and bulk
+// strings, int64 for integers, []interface{} for arrays and nil for null
+// replies. Error replies are returned as redisError.
+//
+// Only timeout bounds the round trip, never the caller's context: giving
+// up on an EXEC already sent could leave a message claimed by a delivery
+// nobody received, hidden until its visibility ran out.
+func (c *redisConn) do(timeout time.Duration, args ...string) (interface{}, error) {
+	c.conn.SetDeadline(time.Now().Add(timeout))
+
+	fmt.Fprintf(c.writer, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.writer, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	if err := c.writer.Flush(); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+func (c *redisConn) read() (interface{}, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("queue: redis: malformed reply")
+	}
+	kind, line := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return line, nil
+
+	case '-':
+		return nil, redisError(line)
+
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+
+	case '$':
+		size, err := strconv.Atoi(line)
+		if err != nil || size < 0 {
+			return nil, err
+		}
+
+		raw := make([]byte, size+2)
+		if _, err := io.ReadFull(c.reader, raw); err != nil {
+			return nil, err
+		}
+		return string(raw[:size]), nil
+
+	case '*':
+		count, err := strconv.Atoi(line)
+		if err != nil || count < 0 {
+			return nil, err
+		}
+
+		items := make([]interface{}, count)
+		for i := range items {
+			// Errors nested in an EXEC reply belong to single commands
+			// and don't break the connection.
+			item, err := c.read()
+			if _, ok := err.(redisError); err != nil && !ok {
+				return nil, err
+			}
+			if err != nil {
+				item = err
+			}
+			items[i] = item
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("queue: redis: unexpected reply type %q", kind)
+}
+
+// redisPool keeps idle connections for reuse.
+type redisPool struct {
+	opts   RedisOptions
+	mu     sync.Mutex
+	idle   []*redisConn
+	closed bool
+}
+
+func (p *redisPool) get(ctx context.Context) (*redisConn, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrClosed
+	}
+
+	if count := len(p.idle); count > 0 {
+		c := p.idle[count-1]
+		p.idle = p.idle[:count-1]
+		p.mu.Unlock()
+		return c, nil
+	}
+	p.mu.Unlock()
+
+	dialer := net.Dialer{Timeout: p.opts.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", p.opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &redisConn{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		writer: bufio.NewWriter(conn),
+	}
+
+	if len(p.opts.Password) > 0 {
+		if _, err := c.do(p.opts.Timeout, "AUTH", p.opts.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if p.opts.DB != 0 {
+		if _, err := c.do(p.opts.Timeout, "SELECT", strconv.Itoa(p.opts.DB)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// put returns c to the pool unless err broke the connection.
+func (p *redisPool) put(c *redisConn, err error) {
+	if _, ok := err.(redisError); err != nil && !ok {
+		c.conn.Close()
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed || len(p.idle) >= p.opts.MaxIdle {
+		c.conn.Close()
+		return
+	}
+	p.idle = append(p.idle, c)
+}
+
+func (p *redisPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	for _, c := range p.idle {
+		c.conn.Close()
+	}
+	p.idle = nil
+}
@@ -0,0 +1,66 @@
+package quota
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Middleware counts a request against the Requests limits of the
+// principal returned by identify. Requests over quota get 429; every
+// counted response carries X-RateLimit-Limit, X-RateLimit-Remaining and
+// X-RateLimit-Reset (Unix seconds) for the most constrained limit.
+// Requests without a principal are not counted.
+func Middleware(tracker *Tracker, identify func(*http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal := identify(r)
+			if len(principal) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			usage, ok := tracker.Consume(principal, Requests, 1)
+			if usage.Limit > 0 {
+				w.Header().Set("X-RateLimit-Limit", strconv.FormatInt(usage.Limit, 10))
+				w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(usage.Remaining(), 10))
+				w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(usage.Reset.Unix(), 10))
+			}
+
+			if !ok {
+				retry := time.Until(usage.Reset) / time.Second
+				w.Header().Set("Retry-After", strconv.FormatInt(int64(retry)+1, 10))
+				http.Error(w, "quota exceeded", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Handler serves the usage report as JSON, optionally narrowed with
+// ?principal=, for billing and chargeback; ?history=1 serves the totals
+// of ended windows instead. It must be mounted behind whatever restricts
+// access to administrators.
+func Handler(tracker *Tracker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+
+		report := tracker.Report(query.Get("principal"))
+		if len(query.Get("history")) > 0 {
+			report = tracker.History(query.Get("principal"))
+		}
+		if report == nil {
+			report = []Usage{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	})
+}
@@ -0,0 +1,370 @@
+// Package quota accounts usage per principal (an API key or a tenant)
+// and enforces daily and monthly limits on it. Requests are counted by
+// Middleware; other metrics such as job minutes are recorded by the code
+// that consumes them.
+package quota
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Metrics accounted out of the box.
+const (
+	Requests   = "requests"
+	JobMinutes = "job-minutes"
+)
+
+// Period is the window a limit applies to. Windows are aligned on UTC
+// calendar days and months.
+type Period string
+
+// Supported periods.
+const (
+	Daily   Period = "daily"
+	Monthly Period = "monthly"
+)
+
+// periods are the windows every metric is counted in.
+var periods = []Period{Daily, Monthly}
+
+// Start returns the beginning of the window containing t.
+func (p Period) Start(t time.Time) time.Time {
+	t = t.UTC()
+	if p == Monthly {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// End returns the end of the window containing t.
+func (p Period) End(t time.Time) time.Time {
+	if p == Monthly {
+		return p.Start(t).AddDate(0, 1, 0)
+	}
+	return p.Start(t).AddDate(0, 0, 1)
+}
+
+// Limit caps a metric over a period.
+type Limit struct {
+	Metric string
+	Period Period
+	Max    int64
+}
+
+// Usage is the consumption of a metric in one window.
+type Usage struct {
+	Principal string    `json:"principal"`
+	Metric    string    `json:"metric"`
+	Period    Period    `json:"period"`
+	Start     time.Time `json:"start"`
+	Reset     time.Time `json:"reset"`
+	Used      int64     `json:"used"`
+	Limit     int64     `json:"limit,omitempty"`
+}
+
+// Remaining returns how much of the limit is left; it is meaningless
+// for unlimited usage.
+func (u Usage) Remaining() int64 {
+	if u.Used >= u.Limit {
+		return 0
+	}
+	return u.Limit - u.Used
+}
+
+// Options configures a Tracker.
+type Options struct {
+	// Default limits apply to principals without an override.
+	Default []Limit
+
+	// Overrides replace the default limits of specific principals.
+	Overrides map[string][]Limit
+
+	// Now returns the current time; it defaults to time.Now.
+	Now func() time.Time
+
+	// History is how many closed windows are kept per principal, metric
+	// and period for History; it defaults to 12.
+	History int
+
+	// OnClose receives the final usage of every window that ends, e.g.
+	// to persist it for billing. Windows close at the first access after
+	// they end, or when Rotate runs.
+	OnClose func(Usage)
+}
+
+type key struct {
+	principal string
+	metric    string
+	period    Period
+}
+
+type counter struct {
+	start   time.Time
+	used    int64
+	history []Usage
+}
+
+// Tracker counts usage in memory and checks it against limits.
+type Tracker struct {
+	opts     Options
+	mu       sync.Mutex
+	counters map[key]*counter
+
+	// closing holds windows closed under the lock until unlock hands
+	// them to OnClose.
+	closing []Usage
+}
+
+// New creates a tracker.
+func New(opts Options) *Tracker {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.History <= 0 {
+		opts.History = 12
+	}
+
+	return &Tracker{opts: opts, counters: make(map[key]*counter)}
+}
+
+// Limits returns the limits of principal.
+func (t *Tracker) Limits(principal string) []Limit {
+	if limits, ok := t.opts.Overrides[principal]; ok {
+		return limits
+	}
+	return t.opts.Default
+}
+
+// Consume records amount of metric for principal unless that would
+// exceed one of its limits. It reports the usage of the most constrained
+// limit of the metric and whether the amount was accepted.
+func (t *Tracker) Consume(principal, metric string, amount int64) (Usage, bool) {
+	t.mu.Lock()
+	defer t.unlock()
+
+	now := t.opts.Now()
+	limits := t.matching(principal, metric)
+
+	for _, limit := range limits {
+		if t.counter(principal, metric, limit.Period, now).used+amount > limit.Max {
+			return t.usage(principal, limit, now), false
+		}
+	}
+
+	for _, period := range periods {
+		t.counter(principal, metric, period, now).used += amount
+	}
+
+	if len(limits) == 0 {
+		return t.usage(principal, Limit{Metric: metric, Period: Daily}, now), true
+	}
+	return t.tightest(principal, limits, now), true
+}
+
+// Record adds amount of metric for principal without enforcing limits,
+// for usage that has already happened such as finished job minutes.
+func (t *Tracker) Record(principal, metric string, amount int64) {
+	t.mu.Lock()
+	defer t.unlock()
+
+	now := t.opts.Now()
+	for _, period := range periods {
+		t.counter(principal, metric, period, now).used += amount
+	}
+}
+
+// Exceeded reports whether principal has used up a limit of metric,
+// e.g. before a job is allowed to start.
+func (t *Tracker) Exceeded(principal, metric string) bool {
+	t.mu.Lock()
+	defer t.unlock()
+
+	now := t.opts.Now()
+	for _, limit := range t.matching(principal, metric) {
+		if t.counter(principal, metric, limit.Period, now).used >= limit.Max {
+			return true
+		}
+	}
+	return false
+}
+
+// Report returns the current usage of every principal, or of the given
+// one, sorted by principal, metric and period. It doesn't change any
+// counter; windows that ended are reported by History instead.
+func (t *Tracker) Report(principal string) []Usage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.opts.Now()
+	var report []Usage
+
+	for k, c := range t.counters {
+		if len(principal) > 0 && k.principal != principal {
+			continue
+		}
+
+		usage := t.window(k, c.start)
+		if start := k.period.Start(now); !c.start.Equal(start) {
+			usage = t.window(k, start)
+			usage.Used = 0
+		}
+		report = append(report, usage)
+	}
+
+	sortUsage(report)
+	return report
+}
+
+// History returns the totals of the windows that ended, for every
+// principal or the given one, sorted by principal, metric, period and
+// start. It keeps up to Options.History windows per metric and period.
+func (t *Tracker) History(principal string) []Usage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.opts.Now()
+	var history []Usage
+
+	for k, c := range t.counters {
+		if len(principal) > 0 && k.principal != principal {
+			continue
+		}
+
+		history = append(history, c.history...)
+		if !c.start.Equal(k.period.Start(now)) {
+			// Ended but not rotated yet.
+			history = append(history, t.window(k, c.start))
+		}
+	}
+
+	sortUsage(history)
+	return history
+}
+
+// Rotate closes every window that has ended, so OnClose sees it even
+// when the principal makes no further request. Call it periodically
+// when OnClose is set.
+func (t *Tracker) Rotate() {
+	t.mu.Lock()
+	defer t.unlock()
+
+	now := t.opts.Now()
+	for k := range t.counters {
+		t.counter(k.principal, k.metric, k.period, now)
+	}
+}
+
+// unlock releases the tracker and hands the windows closed meanwhile to
+// OnClose, outside the lock so it may call back into the tracker.
+func (t *Tracker) unlock() {
+	closing := t.closing
+	t.closing = nil
+	t.mu.Unlock()
+
+	if t.opts.OnClose == nil {
+		return
+	}
+	for _, usage := range closing {
+		t.opts.OnClose(usage)
+	}
+}
+
+func sortUsage(items []Usage) {
+	sort.Slice(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if a.Principal != b.Principal {
+			return a.Principal < b.Principal
+		}
+		if a.Metric != b.Metric {
+			return a.Metric < b.Metric
+		}
+		if a.Period != b.Period {
+			return a.Period < b.Period
+		}
+		return a.Start.Before(b.Start)
+	})
+}
+
+func (t *Tracker) matching(principal, metric string) []Limit {
+	var result []Limit
+	for _, limit := range t.Limits(principal) {
+		if limit.Metric == metric {
+			result = append(result, limit)
+		}
+	}
+	return result
+}
+
+// counter returns the counter of the current window. When the window
+// rolled over, the totals of the ended one are kept in its history and
+// queued for OnClose before the counter restarts.
+func (t *Tracker) counter(principal, metric string, period Period, now time.Time) *counter {
+	k := key{principal: principal, metric: metric, period: period}
+	start := period.Start(now)
+
+	c, ok := t.counters[k]
+	if !ok {
+		c = &counter{start: start}
+		t.counters[k] = c
+	} else if !c.start.Equal(start) {
+		closed := t.window(k, c.start)
+		t.closing = append(t.closing, closed)
+
+		c.history = append(c.history, closed)
+		if len(c.history) > t.opts.History {
+			c.history = c.history[len(c.history)-t.opts.History:]
+		}
+
+		c.start = start
+		c.used = 0
+	}
+	return c
+}
+
+// window returns the usage of k in the window beginning at start, as
+// counted so far.
+func (t *Tracker) window(k key, start time.Time) Usage {
+	usage := Usage{
+		Principal: k.principal,
+		Metric:    k.metric,
+		Period:    k.period,
+		Start:     start,
+		Reset:     k.period.End(start),
+	}
+
+	if c, ok := t.counters[k]; ok && c.start.Equal(start) {
+		usage.Used = c.used
+	}
+
+	for _, limit := range t.matching(k.principal, k.metric) {
+		if limit.Period == k.period {
+			usage.Limit = limit.Max
+		}
+	}
+	return usage
+}
+
+func (t *Tracker) usage(principal string, limit Limit, now time.Time) Usage {
+	return Usage{
+		Principal: principal,
+		Metric:    limit.Metric,
+		Period:    limit.Period,
+		Start:     limit.Period.Start(now),
+		Reset:     limit.Period.End(now),
+		Used:      t.counter(principal, limit.Metric, limit.Period, now).used,
+		Limit:     limit.Max,
+	}
+}
+
+// tightest returns the usage of the limit with the least room left.
+func (t *Tracker) tightest(principal string, limits []Limit, now time.Time) Usage {
+	best := t.usage(principal, limits[0], now)
+	for _, limit := range limits[1:] {
+		if candidate := t.usage(principal, limit, now); candidate.Remaining() < best.Remaining() {
+			best = candidate
+		}
+	}
+	return best
+}
@@ -0,0 +1,268 @@
+package quota
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// clock is a settable Now.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+func TestPeriods(t *testing.T) {
+	at := time.Date(2021, 3, 31, 22, 0, 0, 0, time.FixedZone("x", -3*3600))
+
+	tests := []struct {
+		period Period
+		start  time.Time
+		end    time.Time
+	}{
+		{Daily, time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 4, 2, 0, 0, 0, 0, time.UTC)},
+		{Monthly, time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		if start := test.period.Start(at); !start.Equal(test.start) {
+			t.Errorf("%s start = %s; want %s", test.period, start, test.start)
+		}
+		if end := test.period.End(at); !end.Equal(test.end) {
+			t.Errorf("%s end = %s; want %s", test.period, end, test.end)
+		}
+	}
+}
+
+func TestConsume(t *testing.T) {
+	tests := []struct {
+		name      string
+		limits    []Limit
+		amounts   []int64
+		accepted  []bool
+		remaining int64
+	}{
+		{"unlimited", nil, []int64{5, 5}, []bool{true, true}, 0},
+		{"within", []Limit{{Requests, Daily, 3}}, []int64{1, 1, 1}, []bool{true, true, true}, 0},
+		{"over", []Limit{{Requests, Daily, 2}}, []int64{1, 1, 1}, []bool{true, true, false}, 0},
+		{"too large", []Limit{{Requests, Daily, 5}}, []int64{6, 5}, []bool{false, true}, 0},
+		{"tightest", []Limit{{Requests, Daily, 10}, {Requests, Monthly, 4}}, []int64{3}, []bool{true}, 1},
+	}
+
+	for _, test := range tests {
+		tracker := New(Options{Default: test.limits})
+
+		var usage Usage
+		for i, amount := range test.amounts {
+			var ok bool
+			usage, ok = tracker.Consume("alice", Requests, amount)
+			if ok != test.accepted[i] {
+				t.Errorf("%s: consume %d #%d accepted = %v", test.name, amount, i, ok)
+			}
+		}
+
+		if usage.Limit > 0 && usage.Remaining() != test.remaining {
+			t.Errorf("%s: remaining = %d; want %d", test.name, usage.Remaining(), test.remaining)
+		}
+	}
+}
+
+func TestOverridesAndExceeded(t *testing.T) {
+	tracker := New(Options{
+		Default:   []Limit{{JobMinutes, Monthly, 100}},
+		Overrides: map[string][]Limit{"big": {{JobMinutes, Monthly, 1000}}},
+	})
+
+	tracker.Record("small", JobMinutes, 150)
+	tracker.Record("big", JobMinutes, 150)
+
+	tests := []struct {
+		principal string
+		want      bool
+	}{
+		{"small", true},
+		{"big", false},
+		{"idle", false},
+	}
+
+	for _, test := range tests {
+		if got := tracker.Exceeded(test.principal, JobMinutes); got != test.want {
+			t.Errorf("Exceeded(%s) = %v; want %v", test.principal, got, test.want)
+		}
+	}
+}
+
+func TestRollover(t *testing.T) {
+	now := &clock{now: time.Date(2021, 1, 31, 12, 0, 0, 0, time.UTC)}
+
+	var mu sync.Mutex
+	var closed []Usage
+
+	tracker := New(Options{
+		Default: []Limit{{Requests, Daily, 10}},
+		Now:     now.Now,
+		History: 1,
+		OnClose: func(usage Usage) {
+			mu.Lock()
+			closed = append(closed, usage)
+			mu.Unlock()
+		},
+	})
+
+	tracker.Consume("alice", Requests, 4)
+	now.set(time.Date(2021, 2, 1, 12, 0, 0, 0, time.UTC))
+
+	// Reading the report must neither reset nor close anything.
+	for i := 0; i < 2; i++ {
+		for _, usage := range tracker.Report("alice") {
+			if usage.Used != 0 || !usage.Start.Equal(usage.Period.Start(now.Now())) {
+				t.Errorf("report after rollover = %+v", usage)
+			}
+		}
+	}
+	if len(closed) != 0 {
+		t.Errorf("Report closed %d windows", len(closed))
+	}
+
+	history := tracker.History("alice")
+	if len(history) != 2 || history[0].Used != 4 || history[1].Used != 4 {
+		t.Fatalf("history before rotation = %+v", history)
+	}
+
+	tracker.Rotate()
+
+	tests := []struct {
+		period Period
+		start  time.Time
+		limit  int64
+	}{
+		{Daily, time.Date(2021, 1, 31, 0, 0, 0, 0, time.UTC), 10},
+		{Monthly, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), 0},
+	}
+
+	mu.Lock()
+	if len(closed) != len(tests) {
+		t.Fatalf("OnClose got %d windows; want %d", len(closed), len(tests))
+	}
+	sortUsage(closed)
+	for i, test := range tests {
+		got := closed[i]
+		if got.Period != test.period || !got.Start.Equal(test.start) || got.Used != 4 || got.Limit != test.limit {
+			t.Errorf("closed %s window = %+v", test.period, got)
+		}
+	}
+	mu.Unlock()
+
+	if history := tracker.History("alice"); len(history) != 2 {
+		t.Errorf("history after rotation = %+v", history)
+	}
+
+	// History keeps one window per counter.
+	tracker.Consume("alice", Requests, 1)
+	now.set(time.Date(2021, 2, 2, 12, 0, 0, 0, time.UTC))
+	tracker.Rotate()
+
+	for _, usage := range tracker.History("alice") {
+		if usage.Period == Daily && usage.Used != 1 {
+			t.Errorf("daily history = %+v; want only the last window", usage)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	tracker := New(Options{Default: []Limit{{Requests, Daily, 2}}})
+	handler := Middleware(tracker, func(r *http.Request) string {
+		return r.Header.Get("X-Principal")
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		principal string
+		code      int
+		remaining string
+	}{
+		{"alice", 200, "1"},
+		{"alice", 200, "0"},
+		{"alice", 429, "0"},
+		{"bob", 200, "1"},
+		{"", 200, ""},
+	}
+
+	for i, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Principal", test.principal)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != test.code {
+			t.Errorf("request %d: code = %d; want %d", i, w.Code, test.code)
+		}
+		if got := w.Header().Get("X-RateLimit-Remaining"); got != test.remaining {
+			t.Errorf("request %d: remaining = %q; want %q", i, got, test.remaining)
+		}
+		if w.Code == 429 {
+			if seconds, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || seconds <= 0 {
+				t.Errorf("request %d: Retry-After = %q", i, w.Header().Get("Retry-After"))
+			}
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	now := &clock{now: time.Date(2021, 1, 31, 12, 0, 0, 0, time.UTC)}
+	tracker := New(Options{Now: now.Now})
+	tracker.Record("alice", Requests, 3)
+	tracker.Record("bob", Requests, 1)
+	now.set(time.Date(2021, 2, 1, 12, 0, 0, 0, time.UTC))
+	tracker.Record("alice", Requests, 2)
+
+	tests := []struct {
+		query string
+		code  int
+		items int
+		used  int64
+	}{
+		{"", 200, 4, 4},
+		{"?principal=alice", 200, 2, 4},
+		{"?principal=alice&history=1", 200, 2, 6},
+		{"?principal=nobody", 200, 0, 0},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		Handler(tracker).ServeHTTP(w, httptest.NewRequest("GET", "/"+test.query, nil))
+
+		var report []Usage
+		json.Unmarshal(w.Body.Bytes(), &report)
+
+		var used int64
+		for _, usage := range report {
+			used += usage.Used
+		}
+		if w.Code != test.code || len(report) != test.items || used != test.used {
+			t.Errorf("%q = %d, %d items using %d; want %d, %d items using %d",
+				test.query, w.Code, len(report), used, test.code, test.items, test.used)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	Handler(tracker).ServeHTTP(w, httptest.NewRequest("POST", "/", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST = %d", w.Code)
+	}
+}
@@ -0,0 +1,226 @@
+// Package redact masks secret values in captured command output before it
+// is stored or streamed to clients.
+package redact
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// Mask replaces every redacted value.
+const Mask = "***"
+
+// minSecretLength avoids masking trivially short values such as "1" or
+// "no", which would destroy unrelated output.
+const minSecretLength = 4
+
+// ErrCanaryLeaked is returned by Verify when a canary secret appears in
+// output without being masked.
+var ErrCanaryLeaked = errors.New("redact: canary secret leaked")
+
+// Redactor holds the secret values and patterns to mask.
+type Redactor struct {
+	mu       sync.RWMutex
+	secrets  []string
+	patterns []*regexp.Regexp
+	canaries []string
+}
+
+// New creates a Redactor masking the given secret values.
+func New(secrets ...string) *Redactor {
+	r := &Redactor{}
+	r.AddSecret(secrets...)
+	return r
+}
+
+// AddSecret registers literal values to mask. Values shorter than four
+// bytes are ignored.
+func (r *Redactor) AddSecret(secrets ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, secret := range secrets {
+		if len(secret) >= minSecretLength {
+			r.secrets = append(r.secrets, secret)
+		}
+	}
+
+	// Longer secrets go first so a secret containing another one is
+	// masked as a whole.
+	sort.Slice(r.secrets, func(i, j int) bool {
+		return len(r.secrets[i]) > len(r.secrets[j])
+	})
+}
+
+// AddPattern registers a regular expression whose matches are masked.
+func (r *Redactor) AddPattern(expr string) error {
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return fmt.Errorf("redact: %v", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.patterns = append(r.patterns, pattern)
+	return nil
+}
+
+// Canary generates a random secret, registers it for masking and remembers
+// it so Verify can prove that masking works end to end.
+func (r *Redactor) Canary() string {
+	raw := make([]byte, 12)
+	if _, err := rand.Read(raw); err != nil {
+		panic(err)
+	}
+
+	canary := "canary-" + hex.EncodeToString(raw)
+	r.AddSecret(canary)
+
+	r.mu.Lock()
+	r.canaries = append(r.canaries, canary)
+	r.mu.Unlock()
+
+	return canary
+}
+
+// Verify returns ErrCanaryLeaked when any canary created by Canary is
+// present in output.
+func (r *Redactor) Verify(output []byte) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, canary := range r.canaries {
+		if bytes.Contains(output, []byte(canary)) {
+			return ErrCanaryLeaked
+		}
+	}
+	return nil
+}
+
+// Redact returns a copy of data with every secret and pattern match masked.
+func (r *Redactor) Redact(data []byte) []byte {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	data = append([]byte(nil), data...)
+	for _, secret := range r.secrets {
+		data = bytes.ReplaceAll(data, []byte(secret), []byte(Mask))
+	}
+
+	for _, pattern := range r.patterns {
+		data = pattern.ReplaceAllLiteral(data, []byte(Mask))
+	}
+	return data
+}
+
+// safeCut returns the largest offset not after cut such that data[:offset]
+// doesn't end with the beginning of a secret, so a secret split at the
+// offset is never written partially.
+func (r *Redactor) safeCut(data []byte, cut int) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	safe := cut
+	for _, secret := range r.secrets {
+		for size := len(secret) - 1; size > 0; size-- {
+			if size <= cut && bytes.HasSuffix(data[:cut], []byte(secret[:size])) {
+				if cut-size < safe {
+					safe = cut - size
+				}
+				break
+			}
+		}
+	}
+	return safe
+}
+
+// String is the string form of Redact.
+func (r *Redactor) String(text string) string {
+	return string(r.Redact([]byte(text)))
+}
+
+// maxPending bounds the partial line a Writer holds back. Longer lines
+// are written in pieces, cut where no secret can be split; patterns
+// spanning a cut may be missed.
+const maxPending = 64 << 10
+
+// Writer masks output line by line so that secrets split across several
+// Write calls are still caught. Partial lines are held back until a newline
+// arrives, until they exceed maxPending, or until Close is called.
+type Writer struct {
+	mu       sync.Mutex
+	redactor *Redactor
+	out      io.Writer
+	pending  []byte
+}
+
+// NewWriter wraps out with a redacting writer.
+func (r *Redactor) NewWriter(out io.Writer) *Writer {
+	return &Writer{redactor: r, out: out}
+}
+
+// Write implements io.Writer.
+func (w *Writer) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pending = append(w.pending, data...)
+
+	end := bytes.LastIndexByte(w.pending, '\n')
+	if end < 0 {
+		if len(w.pending) > maxPending {
+			return len(data), w.spill()
+		}
+		return len(data), nil
+	}
+
+	// data is buffered at this point, so it counts as written even if
+	// out fails; the lines stay pending for the next Write or Close.
+	lines := w.pending[:end+1]
+	if _, err := w.out.Write(w.redactor.Redact(lines)); err != nil {
+		return len(data), err
+	}
+
+	w.pending = append(w.pending[:0], w.pending[end+1:]...)
+	if len(w.pending) > maxPending {
+		return len(data), w.spill()
+	}
+	return len(data), nil
+}
+
+// spill writes most of an overlong partial line, keeping back only a
+// tail that might be the beginning of a secret.
+func (w *Writer) spill() error {
+	redacted := w.redactor.Redact(w.pending)
+	cut := w.redactor.safeCut(redacted, len(redacted))
+
+	if _, err := w.out.Write(redacted[:cut]); err != nil {
+		return err
+	}
+
+	w.pending = append(w.pending[:0], redacted[cut:]...)
+	return nil
+}
+
+// Close flushes the pending partial line. It does not close the wrapped
+// writer.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.pending) == 0 {
+		return nil
+	}
+
+	_, err := w.out.Write(w.redactor.Redact(w.pending))
+	w.pending = w.pending[:0]
+	return err
+}
@@ -0,0 +1,135 @@
+package redact
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name     string
+		secrets  []string
+		patterns []string
+		input    string
+		want     string
+	}{
+		{"nothing registered", nil, nil, "token=abcd", "token=abcd"},
+		{"literal", []string{"hunter2"}, nil, "pw=hunter2 again hunter2", "pw=*** again ***"},
+		{"short values ignored", []string{"no"}, nil, "no way", "no way"},
+		{"longest first", []string{"abcd", "abcdefgh"}, nil, "x abcdefgh y", "x *** y"},
+		{"pattern", nil, []string{`ghp_[A-Za-z0-9]+`}, "token ghp_XYZ123 used", "token *** used"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := New(test.secrets...)
+			for _, pattern := range test.patterns {
+				if err := r.AddPattern(pattern); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			input := []byte(test.input)
+			output := r.Redact(input)
+
+			if string(output) != test.want {
+				t.Fatalf("Redact = %q; want %q", output, test.want)
+			}
+
+			// The result must be a copy even when nothing matched.
+			if len(output) > 0 {
+				output[0] = '!'
+				if input[0] == '!' {
+					t.Fatal("Redact returned its input slice")
+				}
+			}
+		})
+	}
+}
+
+func TestWriterSplitWrites(t *testing.T) {
+	r := New("supersecret")
+
+	var out bytes.Buffer
+	w := r.NewWriter(&out)
+
+	for _, chunk := range []string{"line one super", "secret\nand super", "sec", "ret tail"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	if want := "line one ***\nand *** tail"; out.String() != want {
+		t.Fatalf("output = %q; want %q", out.String(), want)
+	}
+}
+
+// flaky fails its first Write.
+type flaky struct {
+	bytes.Buffer
+	failed bool
+}
+
+func (f *flaky) Write(data []byte) (int, error) {
+	if !f.failed {
+		f.failed = true
+		return 0, errors.New("disk full")
+	}
+	return f.Buffer.Write(data)
+}
+
+func TestWriterFailureKeepsLines(t *testing.T) {
+	out := &flaky{}
+	w := New("supersecret").NewWriter(out)
+
+	if n, err := w.Write([]byte("first supersecret\n")); err == nil || n != 18 {
+		t.Fatalf("Write = %d, %v; want 18 bytes accepted and the error", n, err)
+	}
+	w.Write([]byte("second\n"))
+	w.Close()
+
+	if want := "first ***\nsecond\n"; out.String() != want {
+		t.Errorf("output = %q; want %q", out.String(), want)
+	}
+}
+
+func TestWriterBoundsPartialLine(t *testing.T) {
+	secret := "supersecret"
+	r := New(secret)
+
+	var out bytes.Buffer
+	w := r.NewWriter(&out)
+
+	// A secret straddles the point where the overlong line is spilled.
+	filler := strings.Repeat("x", maxPending-5)
+	w.Write([]byte(filler + "super"))
+	w.Write([]byte("sec"))
+	w.Write([]byte("ret" + strings.Repeat("y", maxPending)))
+
+	if len(w.pending) > maxPending {
+		t.Fatalf("pending grew to %d bytes", len(w.pending))
+	}
+	w.Close()
+
+	if strings.Contains(out.String(), "supers") || strings.Contains(out.String(), "ecret") {
+		t.Fatal("secret leaked partially across a spill")
+	}
+	if !strings.Contains(out.String(), "x***y") {
+		t.Fatal("secret spanning a spill was not masked")
+	}
+}
+
+func TestCanary(t *testing.T) {
+	r := New()
+	canary := r.Canary()
+
+	if err := r.Verify(r.Redact([]byte("leak " + canary))); err != nil {
+		t.Fatalf("Verify after Redact = %v", err)
+	}
+	if err := r.Verify([]byte("leak " + canary)); err != ErrCanaryLeaked {
+		t.Fatalf("Verify = %v; want ErrCanaryLeaked", err)
+	}
+}
@@ -0,0 +1,44 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"devops.io/cloud/queue"
+)
+
+// Consume works like queue.Consume, with failures handled by policy: a
+// message is released with the policy's backoff while it may still be
+// retried, and otherwise acknowledged and kept in letters.
+func Consume(ctx context.Context, backend queue.Queue, name string, visibility time.Duration, policy Policy, letters *DeadLetters, handler queue.Handler) error {
+	for {
+		delivery, err := backend.Dequeue(ctx, name, visibility)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		failure := handler(ctx, delivery.Message)
+
+		switch {
+		case failure == nil:
+			err = backend.Ack(ctx, delivery)
+
+		case policy.Retry(delivery.Attempts, failure):
+			err = backend.Nack(ctx, delivery, policy.Backoff(delivery.Attempts))
+
+		default:
+			// Recorded before the ack: a crash in between delivers the
+			// message again rather than losing it.
+			letters.Add(delivery.Message, failure)
+			err = backend.Ack(ctx, delivery)
+		}
+
+		if err != nil && !errors.Is(err, queue.ErrUnknownReceipt) {
+			return err
+		}
+	}
+}
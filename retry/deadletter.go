@@ -0,0 +1,142 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"devops.io/cloud/queue"
+)
+
+// ErrUnknownLetter is returned for dead letters that don't exist.
+var ErrUnknownLetter = errors.New("retry: unknown dead letter")
+
+// Letter is a message that failed for good.
+type Letter struct {
+	ID       string    `json:"id"`
+	Queue    string    `json:"queue"`
+	Body     []byte    `json:"body"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// DeadLetters keeps failed messages in memory. Once Limit letters are
+// kept, the oldest is dropped for each new one.
+type DeadLetters struct {
+	mu      sync.Mutex
+	limit   int
+	letters map[string]*Letter
+	order   []string
+}
+
+// NewDeadLetters creates an empty list holding at most limit letters; it
+// defaults to 10000.
+func NewDeadLetters(limit int) *DeadLetters {
+	if limit <= 0 {
+		limit = 10000
+	}
+
+	return &DeadLetters{limit: limit, letters: make(map[string]*Letter)}
+}
+
+// Add records a failed message, replacing an earlier letter of the same
+// message.
+func (d *DeadLetters) Add(message queue.Message, cause error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.letters[message.ID]; ok {
+		d.remove(message.ID)
+	}
+
+	for len(d.order) >= d.limit {
+		delete(d.letters, d.order[0])
+		d.order = d.order[1:]
+	}
+
+	d.letters[message.ID] = &Letter{
+		ID:       message.ID,
+		Queue:    message.Queue,
+		Body:     append([]byte(nil), message.Body...),
+		Attempts: message.Attempts,
+		Error:    cause.Error(),
+		FailedAt: time.Now().UTC(),
+	}
+	d.order = append(d.order, message.ID)
+}
+
+// List returns the letters of a queue, or of every queue when name is
+// empty, newest first.
+func (d *DeadLetters) List(name string) []Letter {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	letters := []Letter{}
+	for _, letter := range d.letters {
+		if len(name) == 0 || letter.Queue == name {
+			letters = append(letters, *letter)
+		}
+	}
+
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i].FailedAt.After(letters[j].FailedAt)
+	})
+	return letters
+}
+
+// Get returns one letter.
+func (d *DeadLetters) Get(id string) (Letter, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	letter, ok := d.letters[id]
+	if !ok {
+		return Letter{}, false
+	}
+	return *letter, true
+}
+
+// Discard drops a letter.
+func (d *DeadLetters) Discard(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.letters[id]; !ok {
+		return ErrUnknownLetter
+	}
+
+	d.remove(id)
+	return nil
+}
+
+// Requeue enqueues the body of a letter on its queue again and drops the
+// letter. It returns the ID of the new message.
+func (d *DeadLetters) Requeue(ctx context.Context, backend queue.Queue, id string) (string, error) {
+	letter, ok := d.Get(id)
+	if !ok {
+		return "", ErrUnknownLetter
+	}
+
+	message, err := backend.Enqueue(ctx, letter.Queue, letter.Body)
+	if err != nil {
+		return "", err
+	}
+
+	d.Discard(id)
+	return message, nil
+}
+
+// remove must be called with d.mu held.
+func (d *DeadLetters) remove(id string) {
+	delete(d.letters, id)
+
+	for i, item := range d.order {
+		if item == id {
+			d.order = append(d.order[:i], d.order[i+1:]...)
+			break
+		}
+	}
+}
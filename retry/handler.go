@@ -0,0 +1,66 @@
+package retry
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"devops.io/cloud/queue"
+)
+
+// Handler exposes the dead letters. It must be mounted with its prefix
+// stripped:
+//
+//	GET    /letters?queue=NAME        failed messages, newest first
+//	GET    /letters/{id}              one failed message
+//	POST   /letters/{id}/requeue      enqueue the message again
+//	DELETE /letters/{id}              drop the message
+func Handler(letters *DeadLetters, backend queue.Queue) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if parts[0] != "letters" {
+			http.NotFound(w, r)
+			return
+		}
+
+		switch {
+		case len(parts) == 1 && r.Method == http.MethodGet:
+			reply(w, http.StatusOK, letters.List(r.URL.Query().Get("queue")))
+
+		case len(parts) == 2 && r.Method == http.MethodGet:
+			letter, ok := letters.Get(parts[1])
+			if !ok {
+				http.Error(w, "dead letter not found", http.StatusNotFound)
+				return
+			}
+			reply(w, http.StatusOK, letter)
+
+		case len(parts) == 2 && r.Method == http.MethodDelete:
+			if err := letters.Discard(parts[1]); err != nil {
+				http.Error(w, "dead letter not found", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		case len(parts) == 3 && parts[2] == "requeue" && r.Method == http.MethodPost:
+			id, err := letters.Requeue(r.Context(), backend, parts[1])
+			if err == ErrUnknownLetter {
+				http.Error(w, "dead letter not found", http.StatusNotFound)
+				return
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			reply(w, http.StatusAccepted, map[string]string{"id": id})
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func reply(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(value)
+}
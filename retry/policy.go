@@ -0,0 +1,107 @@
+// Package retry runs queued jobs under a retry policy and keeps the
+// messages that failed for good in a dead-letter list, where they can be
+// inspected and queued again.
+package retry
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// Policy decides whether and when a failed job runs again.
+type Policy struct {
+	// MaxAttempts bounds the number of runs, the first included; it
+	// defaults to 5.
+	MaxAttempts int
+
+	// Base is the delay after the first failure; it doubles with every
+	// further attempt up to Max. They default to one second and five
+	// minutes.
+	Base time.Duration
+	Max  time.Duration
+
+	// Jitter randomizes each delay by up to this fraction of it, so jobs
+	// failing together don't retry together; it defaults to 0.2. Use a
+	// negative value to disable it.
+	Jitter float64
+
+	// RetryOn classifies errors; it defaults to retrying every error
+	// that isn't marked Permanent.
+	RetryOn func(err error) bool
+}
+
+func (p Policy) defaults() Policy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 5
+	}
+	if p.Base <= 0 {
+		p.Base = time.Second
+	}
+	if p.Max <= 0 {
+		p.Max = 5 * time.Minute
+	}
+	if p.Jitter == 0 {
+		p.Jitter = 0.2
+	} else if p.Jitter < 0 {
+		p.Jitter = 0
+	}
+	if p.RetryOn == nil {
+		p.RetryOn = func(err error) bool {
+			return !IsPermanent(err)
+		}
+	}
+	return p
+}
+
+// Retry reports whether a job that failed with err on its attempt-th run
+// runs again.
+func (p Policy) Retry(attempt int, err error) bool {
+	p = p.defaults()
+	return attempt < p.MaxAttempts && p.RetryOn(err)
+}
+
+// Backoff returns the delay before the run following attempt.
+func (p Policy) Backoff(attempt int) time.Duration {
+	p = p.defaults()
+
+	delay := p.Base
+	for i := 1; i < attempt && delay < p.Max; i++ {
+		delay *= 2
+	}
+	if delay > p.Max {
+		delay = p.Max
+	}
+
+	if p.Jitter > 0 {
+		spread := float64(delay) * p.Jitter
+		delay += time.Duration(spread * (2*rand.Float64() - 1))
+	}
+	return delay
+}
+
+type permanent struct {
+	err error
+}
+
+func (p *permanent) Error() string {
+	return p.err.Error()
+}
+
+func (p *permanent) Unwrap() error {
+	return p.err
+}
+
+// Permanent marks err as not worth retrying, e.g. a malformed payload.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanent{err}
+}
+
+// IsPermanent reports whether err was marked by Permanent.
+func IsPermanent(err error) bool {
+	var marked *permanent
+	return errors.As(err, &marked)
+}
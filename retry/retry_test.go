@@ -0,0 +1,213 @@
+package retry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"devops.io/cloud/queue"
+)
+
+var errFlaky = errors.New("flaky")
+
+func TestPolicy(t *testing.T) {
+	policy := Policy{MaxAttempts: 3, Base: time.Second, Max: 5 * time.Second, Jitter: -1}
+
+	tests := []struct {
+		name    string
+		attempt int
+		err     error
+		retry   bool
+		backoff time.Duration
+	}{
+		{"first failure", 1, errFlaky, true, time.Second},
+		{"doubles", 2, errFlaky, true, 2 * time.Second},
+		{"out of attempts", 3, errFlaky, false, 4 * time.Second},
+		{"capped", 10, errFlaky, false, 5 * time.Second},
+		{"permanent", 1, Permanent(errFlaky), false, time.Second},
+		{"wrapped permanent", 1, fmt.Errorf("step: %w", Permanent(errFlaky)), false, time.Second},
+	}
+
+	for _, test := range tests {
+		if got := policy.Retry(test.attempt, test.err); got != test.retry {
+			t.Errorf("%s: Retry = %v; want %v", test.name, got, test.retry)
+		}
+		if got := policy.Backoff(test.attempt); got != test.backoff {
+			t.Errorf("%s: Backoff = %v; want %v", test.name, got, test.backoff)
+		}
+	}
+
+	if !errors.Is(Permanent(errFlaky), errFlaky) {
+		t.Error("Permanent hides the wrapped error")
+	}
+}
+
+func TestJitter(t *testing.T) {
+	policy := Policy{Base: time.Second, Jitter: 0.5}
+
+	for i := 0; i < 100; i++ {
+		if got := policy.Backoff(1); got < 500*time.Millisecond || got > 1500*time.Millisecond {
+			t.Fatalf("Backoff = %v; want within 50%% of 1s", got)
+		}
+	}
+}
+
+func TestConsume(t *testing.T) {
+	tests := []struct {
+		name     string
+		fail     func(attempt int) error
+		runs     int
+		attempts int
+	}{
+		{"succeeds", func(int) error { return nil }, 1, 0},
+		{"recovers", func(attempt int) error {
+			if attempt < 3 {
+				return errFlaky
+			}
+			return nil
+		}, 3, 0},
+		{"exhausts attempts", func(int) error { return errFlaky }, 4, 4},
+		{"permanent", func(int) error { return Permanent(errFlaky) }, 1, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend := queue.NewMemory()
+			defer backend.Close()
+
+			letters := NewDeadLetters(0)
+			policy := Policy{MaxAttempts: 4, Base: time.Millisecond, Max: time.Millisecond}
+
+			id, err := backend.Enqueue(context.Background(), "jobs", []byte("payload"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var mu sync.Mutex
+			runs := 0
+			done := make(chan struct{})
+
+			handler := func(ctx context.Context, message queue.Message) error {
+				mu.Lock()
+				defer mu.Unlock()
+
+				runs++
+				err := test.fail(message.Attempts)
+				if err == nil || !policy.Retry(message.Attempts, err) {
+					close(done)
+				}
+				return err
+			}
+
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				Consume(ctx, backend, "jobs", time.Minute, policy, letters, handler)
+			}()
+
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("message never settled")
+			}
+
+			// Consume settles the message before it waits for the next.
+			cancel()
+			<-stopped
+
+			mu.Lock()
+			if runs != test.runs {
+				t.Errorf("runs = %d; want %d", runs, test.runs)
+			}
+			mu.Unlock()
+
+			letter, ok := letters.Get(id)
+			if ok != (test.attempts > 0) {
+				t.Fatalf("dead letter kept = %v; want %v", ok, test.attempts > 0)
+			}
+			if ok && (letter.Attempts != test.attempts || letter.Error != errFlaky.Error() || string(letter.Body) != "payload") {
+				t.Errorf("letter = %+v", letter)
+			}
+		})
+	}
+}
+
+func TestDeadLettersLimit(t *testing.T) {
+	letters := NewDeadLetters(2)
+
+	for i := 1; i <= 3; i++ {
+		letters.Add(queue.Message{ID: strconv.Itoa(i), Queue: "jobs"}, errFlaky)
+	}
+
+	if _, ok := letters.Get("1"); ok {
+		t.Error("oldest letter kept past the limit")
+	}
+	if got := len(letters.List("")); got != 2 {
+		t.Errorf("kept %d letters; want 2", got)
+	}
+	if got := len(letters.List("other")); got != 0 {
+		t.Errorf("other queue has %d letters; want 0", got)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	backend := queue.NewMemory()
+	defer backend.Close()
+
+	letters := NewDeadLetters(0)
+	letters.Add(queue.Message{ID: "a", Queue: "jobs", Body: []byte("one")}, errFlaky)
+	letters.Add(queue.Message{ID: "b", Queue: "jobs", Body: []byte("two")}, errFlaky)
+
+	handler := Handler(letters, backend)
+
+	tests := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{http.MethodGet, "/letters?queue=jobs", http.StatusOK},
+		{http.MethodGet, "/letters/a", http.StatusOK},
+		{http.MethodGet, "/letters/missing", http.StatusNotFound},
+		{http.MethodPost, "/letters/a/requeue", http.StatusAccepted},
+		{http.MethodPost, "/letters/a/requeue", http.StatusNotFound},
+		{http.MethodDelete, "/letters/b", http.StatusNoContent},
+		{http.MethodDelete, "/letters/b", http.StatusNotFound},
+		{http.MethodPut, "/letters/b", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/other", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(test.method, test.path, nil))
+
+		if recorder.Code != test.code {
+			t.Errorf("%s %s = %d; want %d", test.method, test.path, recorder.Code, test.code)
+		}
+	}
+
+	var listed []Letter
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/letters", nil))
+	json.NewDecoder(recorder.Body).Decode(&listed)
+	if len(listed) != 0 {
+		t.Errorf("listed %d letters after requeue and delete; want 0", len(listed))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	delivery, err := backend.Dequeue(ctx, "jobs", time.Minute)
+	if err != nil || string(delivery.Body) != "one" {
+		t.Errorf("requeued message = %v, %v; want body %q", delivery, err, "one")
+	}
+}
@@ -0,0 +1,287 @@
+// Package session lets a browser UI authenticate once and then call the
+// APIs with a cookie instead of an API key embedded in JavaScript.
+// Sessions live in a server-side Store; the cookie only carries a signed
+// random ID. Expiry slides with activity up to an absolute limit, and all
+// sessions of a subject can be revoked at once.
+package session
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrNoSession is returned when a request carries no valid session.
+var ErrNoSession = errors.New("session: no session")
+
+// Session is the server-side state of a login.
+type Session struct {
+	ID       string
+	Subject  string
+	Values   map[string]string
+	Created  time.Time
+	LastSeen time.Time
+
+	// Expires is when the session ends unless it is used again; it is
+	// never later than Deadline.
+	Expires  time.Time
+	Deadline time.Time
+}
+
+func (s *Session) expired(now time.Time) bool {
+	return !now.Before(s.Expires)
+}
+
+func (s *Session) clone() *Session {
+	copied := *s
+	copied.Values = make(map[string]string, len(s.Values))
+
+	for key, value := range s.Values {
+		copied.Values[key] = value
+	}
+	return &copied
+}
+
+// Options configures a Manager.
+type Options struct {
+	// Key signs session cookies; it must be at least 32 bytes.
+	Key []byte
+
+	// Cookie is the cookie name; it defaults to "session".
+	Cookie string
+	Path   string
+	Domain string
+
+	// Insecure allows the cookie over plain HTTP for local development.
+	Insecure bool
+
+	// SameSite defaults to Lax, which keeps cross-site forms from
+	// riding on the session.
+	SameSite http.SameSite
+
+	// Idle is how long an unused session lives; it defaults to 30
+	// minutes and is extended by every request.
+	Idle time.Duration
+
+	// MaxAge bounds a session whatever its activity; it defaults to 12
+	// hours.
+	MaxAge time.Duration
+}
+
+// Manager issues, loads and revokes sessions.
+type Manager struct {
+	store Store
+	opts  Options
+}
+
+// New creates a manager around store.
+func New(store Store, opts Options) (*Manager, error) {
+	if len(opts.Key) < 32 {
+		return nil, errors.New("session: key must be at least 32 bytes")
+	}
+	if len(opts.Cookie) == 0 {
+		opts.Cookie = "session"
+	}
+	if len(opts.Path) == 0 {
+		opts.Path = "/"
+	}
+	if opts.SameSite == 0 {
+		opts.SameSite = http.SameSiteLaxMode
+	}
+	if opts.Idle <= 0 {
+		opts.Idle = 30 * time.Minute
+	}
+	if opts.MaxAge <= 0 {
+		opts.MaxAge = 12 * time.Hour
+	}
+
+	return &Manager{store: store, opts: opts}, nil
+}
+
+// Start logs subject in: it replaces any session the request carries,
+// so a login always gets a fresh ID, and sets the cookie.
+func (m *Manager) Start(w http.ResponseWriter, r *http.Request, subject string, values map[string]string) (*Session, error) {
+	if previous, err := m.Load(r); err == nil {
+		m.store.Delete(previous.ID)
+	}
+
+	id, err := random()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	s := &Session{
+		ID:       id,
+		Subject:  subject,
+		Values:   values,
+		Created:  now,
+		LastSeen: now,
+		Deadline: now.Add(m.opts.MaxAge),
+	}
+
+	s.Expires = m.expiry(s, now)
+	if err := m.store.Save(s); err != nil {
+		return nil, err
+	}
+
+	m.setCookie(w, s)
+	return s, nil
+}
+
+// Load returns the session of r without touching its expiry.
+func (m *Manager) Load(r *http.Request) (*Session, error) {
+	cookie, err := r.Cookie(m.opts.Cookie)
+	if err != nil {
+		return nil, ErrNoSession
+	}
+
+	id, ok := m.verify(cookie.Value)
+	if !ok {
+		return nil, ErrNoSession
+	}
+	return m.store.Get(id)
+}
+
+// Touch slides the expiry of s and refreshes the cookie. Writes are
+// skipped while the session was seen less than a minute ago. It returns
+// ErrNoSession when s was destroyed since it was loaded.
+func (m *Manager) Touch(w http.ResponseWriter, s *Session) error {
+	now := time.Now()
+	if now.Sub(s.LastSeen) < time.Minute {
+		return nil
+	}
+
+	expires := m.expiry(s, now)
+	if err := m.store.Touch(s.ID, now, expires); err != nil {
+		return err
+	}
+
+	s.LastSeen = now
+	s.Expires = expires
+
+	m.setCookie(w, s)
+	return nil
+}
+
+// Destroy logs the request's session out and clears the cookie.
+func (m *Manager) Destroy(w http.ResponseWriter, r *http.Request) error {
+	m.clearCookie(w)
+
+	s, err := m.Load(r)
+	if err != nil {
+		return nil
+	}
+	return m.store.Delete(s.ID)
+}
+
+// DestroyAll logs subject out everywhere, e.g. after a password change
+// or when an account is disabled.
+func (m *Manager) DestroyAll(subject string) (int, error) {
+	return m.store.DeleteSubject(subject)
+}
+
+type contextKey struct{}
+
+// Middleware loads the session of every request into its context and
+// slides its expiry. Requests without a valid session pass through; use
+// Require to reject them.
+func (m *Manager) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := m.Load(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if err := m.Touch(w, s); err == ErrNoSession {
+			// Logged out between Load and Touch.
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, s)))
+	})
+}
+
+// Require answers 401 to requests that Middleware found no session for.
+func Require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if From(r.Context()) == nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// From returns the session stored by Middleware, or nil.
+func From(ctx context.Context) *Session {
+	s, _ := ctx.Value(contextKey{}).(*Session)
+	return s
+}
+
+func (m *Manager) expiry(s *Session, now time.Time) time.Time {
+	expires := now.Add(m.opts.Idle)
+	if expires.After(s.Deadline) {
+		expires = s.Deadline
+	}
+	return expires
+}
+
+func (m *Manager) setCookie(w http.ResponseWriter, s *Session) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.opts.Cookie,
+		Value:    m.sign(s.ID),
+		Path:     m.opts.Path,
+		Domain:   m.opts.Domain,
+		Expires:  s.Expires,
+		MaxAge:   int(time.Until(s.Expires).Seconds()),
+		Secure:   !m.opts.Insecure,
+		HttpOnly: true,
+		SameSite: m.opts.SameSite,
+	})
+}
+
+func (m *Manager) clearCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.opts.Cookie,
+		Path:     m.opts.Path,
+		Domain:   m.opts.Domain,
+		MaxAge:   -1,
+		Secure:   !m.opts.Insecure,
+		HttpOnly: true,
+		SameSite: m.opts.SameSite,
+	})
+}
+
+// sign appends an HMAC to id so forged cookies are rejected without a
+// store lookup.
+func (m *Manager) sign(id string) string {
+	mac := hmac.New(sha256.New, m.opts.Key)
+	mac.Write([]byte(id))
+	return id + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (m *Manager) verify(value string) (string, bool) {
+	dot := strings.LastIndexByte(value, '.')
+	if dot < 0 {
+		return "", false
+	}
+
+	id := value[:dot]
+	return id, hmac.Equal([]byte(m.sign(id)), []byte(value))
+}
+
+func random() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
@@ -0,0 +1,242 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var key = []byte(strings.Repeat("k", 32))
+
+func manager(t *testing.T, store Store, opts Options) *Manager {
+	opts.Key = key
+	m, err := New(store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// login starts a session and returns a request carrying its cookie.
+func login(t *testing.T, m *Manager, subject string) (*Session, *http.Request) {
+	w := httptest.NewRecorder()
+	s, err := m.Start(w, httptest.NewRequest("POST", "/login", nil), subject, map[string]string{"role": "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	return s, r
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(NewMemory(), Options{Key: []byte("short")}); err == nil {
+		t.Error("short key accepted")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	m := manager(t, NewMemory(), Options{})
+	s, r := login(t, m, "alice")
+
+	tampered := httptest.NewRequest("GET", "/", nil)
+	tampered.AddCookie(&http.Cookie{Name: "session", Value: s.ID + ".forged"})
+
+	unsigned := httptest.NewRequest("GET", "/", nil)
+	unsigned.AddCookie(&http.Cookie{Name: "session", Value: s.ID})
+
+	tests := []struct {
+		name    string
+		request *http.Request
+		ok      bool
+	}{
+		{"valid", r, true},
+		{"no cookie", httptest.NewRequest("GET", "/", nil), false},
+		{"forged signature", tampered, false},
+		{"unsigned", unsigned, false},
+	}
+
+	for _, test := range tests {
+		loaded, err := m.Load(test.request)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: error = %v", test.name, err)
+		}
+		if test.ok && (loaded.Subject != "alice" || loaded.Values["role"] != "admin") {
+			t.Errorf("%s: loaded %+v", test.name, loaded)
+		}
+	}
+}
+
+func TestStartReplacesSession(t *testing.T) {
+	store := NewMemory()
+	m := manager(t, store, Options{})
+	first, r := login(t, m, "alice")
+
+	second, err := m.Start(httptest.NewRecorder(), r, "alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if second.ID == first.ID {
+		t.Error("login kept the session ID")
+	}
+	if _, err := store.Get(first.ID); err != ErrNoSession {
+		t.Error("previous session survived the login")
+	}
+}
+
+func TestTouch(t *testing.T) {
+	tests := []struct {
+		name      string
+		seenAgo   time.Duration
+		destroy   bool
+		err       error
+		refreshed bool
+	}{
+		{"recent", time.Second, false, nil, false},
+		{"stale", 5 * time.Minute, false, nil, true},
+		{"destroyed", 5 * time.Minute, true, ErrNoSession, false},
+	}
+
+	for _, test := range tests {
+		store := NewMemory()
+		m := manager(t, store, Options{})
+		s, _ := login(t, m, "alice")
+
+		s.LastSeen = s.LastSeen.Add(-test.seenAgo)
+		if test.destroy {
+			m.DestroyAll("alice")
+		}
+
+		w := httptest.NewRecorder()
+		if err := m.Touch(w, s); err != test.err {
+			t.Errorf("%s: Touch = %v; want %v", test.name, err, test.err)
+		}
+
+		if refreshed := len(w.Result().Cookies()) > 0; refreshed != test.refreshed {
+			t.Errorf("%s: cookie refreshed = %v; want %v", test.name, refreshed, test.refreshed)
+		}
+		if _, err := store.Get(s.ID); (err == nil) == test.destroy {
+			t.Errorf("%s: stored session error = %v", test.name, err)
+		}
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	tests := []struct {
+		name   string
+		idle   time.Duration
+		maxAge time.Duration
+		wait   time.Duration
+		alive  bool
+	}{
+		{"alive", time.Hour, 2 * time.Hour, 0, true},
+		{"idle", 10 * time.Millisecond, time.Hour, 20 * time.Millisecond, false},
+		{"deadline", time.Hour, 10 * time.Millisecond, 20 * time.Millisecond, false},
+	}
+
+	for _, test := range tests {
+		m := manager(t, NewMemory(), Options{Idle: test.idle, MaxAge: test.maxAge})
+		s, r := login(t, m, "alice")
+
+		if s.Expires.After(s.Deadline) {
+			t.Errorf("%s: expires after the deadline", test.name)
+		}
+
+		time.Sleep(test.wait)
+		if _, err := m.Load(r); (err == nil) != test.alive {
+			t.Errorf("%s: Load error = %v; want alive %v", test.name, err, test.alive)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	m := manager(t, NewMemory(), Options{})
+	_, alice := login(t, m, "alice")
+	_, revoked := login(t, m, "bob")
+	m.DestroyAll("bob")
+
+	handler := m.Middleware(Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(From(r.Context()).Subject))
+	})))
+
+	tests := []struct {
+		name    string
+		request *http.Request
+		code    int
+		body    string
+	}{
+		{"logged in", alice, http.StatusOK, "alice"},
+		{"revoked", revoked, http.StatusUnauthorized, ""},
+		{"anonymous", httptest.NewRequest("GET", "/", nil), http.StatusUnauthorized, ""},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, test.request)
+
+		if w.Code != test.code || (len(test.body) > 0 && w.Body.String() != test.body) {
+			t.Errorf("%s: %d %q; want %d %q", test.name, w.Code, w.Body.String(), test.code, test.body)
+		}
+	}
+}
+
+func TestDestroy(t *testing.T) {
+	store := NewMemory()
+	m := manager(t, store, Options{})
+	s, r := login(t, m, "alice")
+	login(t, m, "alice")
+	login(t, m, "bob")
+
+	w := httptest.NewRecorder()
+	if err := m.Destroy(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("cookie not cleared: %+v", cookies)
+	}
+	if _, err := store.Get(s.ID); err != ErrNoSession {
+		t.Error("session survived Destroy")
+	}
+
+	tests := []struct {
+		subject string
+		want    int
+	}{
+		{"alice", 1},
+		{"alice", 0},
+		{"bob", 1},
+	}
+
+	for _, test := range tests {
+		if count, _ := m.DestroyAll(test.subject); count != test.want {
+			t.Errorf("DestroyAll(%s) = %d; want %d", test.subject, count, test.want)
+		}
+	}
+}
+
+func TestMemoryPurgesOnSave(t *testing.T) {
+	store := NewMemory()
+	past := time.Now().Add(-time.Hour)
+
+	store.Save(&Session{ID: "old", Subject: "alice", Expires: past, Deadline: past})
+	store.purged = time.Now().Add(-2 * purgeInterval)
+	store.Save(&Session{ID: "new", Subject: "bob", Expires: time.Now().Add(time.Hour)})
+
+	if _, ok := store.sessions["old"]; ok {
+		t.Error("expired session kept")
+	}
+	if _, ok := store.subjects["alice"]; ok {
+		t.Error("subject index of an expired session kept")
+	}
+	if _, ok := store.sessions["new"]; !ok {
+		t.Error("live session purged")
+	}
+}
@@ -0,0 +1,155 @@
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+// Store keeps sessions server side; the cookie only carries the ID.
+type Store interface {
+	// Get returns the session with id, or ErrNoSession once it expired.
+	Get(id string) (*Session, error)
+
+	// Save creates or replaces a session.
+	Save(s *Session) error
+
+	// Touch updates the activity times of an existing session. Unlike
+	// Save it never creates one, and returns ErrNoSession when id is
+	// gone, so a session destroyed concurrently stays destroyed.
+	Touch(id string, lastSeen, expires time.Time) error
+
+	// Delete removes a session; unknown IDs are not an error.
+	Delete(id string) error
+
+	// DeleteSubject removes every session of a subject and returns how
+	// many there were.
+	DeleteSubject(subject string) (int, error)
+}
+
+// purgeInterval is how often Memory drops expired sessions on its own.
+const purgeInterval = time.Minute
+
+// Memory is a Store kept in process memory, suitable for a single
+// instance. Expired sessions are dropped when read, and all of them at
+// most once per minute when a session is saved.
+type Memory struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+	subjects map[string]map[string]bool
+	purged   time.Time
+}
+
+// NewMemory creates an empty store.
+func NewMemory() *Memory {
+	return &Memory{
+		sessions: make(map[string]*Session),
+		subjects: make(map[string]map[string]bool),
+	}
+}
+
+// Get implements Store.
+func (m *Memory) Get(id string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, ErrNoSession
+	}
+
+	if s.expired(time.Now()) {
+		m.remove(id)
+		return nil, ErrNoSession
+	}
+	return s.clone(), nil
+}
+
+// Save implements Store.
+func (m *Memory) Save(s *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if now := time.Now(); now.Sub(m.purged) >= purgeInterval {
+		m.purge(now)
+	}
+
+	m.sessions[s.ID] = s.clone()
+	if m.subjects[s.Subject] == nil {
+		m.subjects[s.Subject] = make(map[string]bool)
+	}
+	m.subjects[s.Subject][s.ID] = true
+	return nil
+}
+
+// Touch implements Store.
+func (m *Memory) Touch(id string, lastSeen, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok {
+		return ErrNoSession
+	}
+
+	if s.expired(time.Now()) {
+		m.remove(id)
+		return ErrNoSession
+	}
+
+	s.LastSeen = lastSeen
+	s.Expires = expires
+	return nil
+}
+
+// Delete implements Store.
+func (m *Memory) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.remove(id)
+	return nil
+}
+
+// DeleteSubject implements Store.
+func (m *Memory) DeleteSubject(subject string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	for id := range m.subjects[subject] {
+		m.remove(id)
+		count++
+	}
+	return count, nil
+}
+
+// Purge drops expired sessions.
+func (m *Memory) Purge() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.purge(time.Now())
+}
+
+func (m *Memory) purge(now time.Time) {
+	m.purged = now
+	for id, s := range m.sessions {
+		if s.expired(now) {
+			m.remove(id)
+		}
+	}
+}
+
+func (m *Memory) remove(id string) {
+	s, ok := m.sessions[id]
+	if !ok {
+		return
+	}
+
+	delete(m.sessions, id)
+	delete(m.subjects[s.Subject], id)
+
+	if len(m.subjects[s.Subject]) == 0 {
+		delete(m.subjects, s.Subject)
+	}
+}
@@ -0,0 +1,194 @@
+// Package stream writes large list responses incrementally. Items are
+// encoded one at a time and flushed periodically, so the response goes
+// out with chunked transfer encoding and memory stays bounded by a
+// single item, however long the list.
+package stream
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// ErrClosed is returned by Add once the list has been closed.
+var ErrClosed = errors.New("stream: list is closed")
+
+// Options configures a List.
+type Options struct {
+	// Key wraps the items in an object, as in {"items": [...]}; empty
+	// writes a bare array.
+	Key string
+
+	// NDJSON writes one item per line instead of a JSON array, for
+	// clients that process items as they arrive.
+	NDJSON bool
+
+	// FlushEvery flushes after that many items; it defaults to 100.
+	FlushEvery int
+}
+
+// List is a JSON list being written to a response.
+type List struct {
+	w       http.ResponseWriter
+	sent    *counter
+	out     *bufio.Writer
+	item    bytes.Buffer
+	encoder *json.Encoder
+	opts    Options
+	count   int
+	started bool
+	closed  bool
+}
+
+// counter counts the bytes that reached the response. The buffer flushes
+// on its own once full, so only this tells whether anything was sent.
+type counter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *counter) Write(data []byte) (int, error) {
+	n, err := c.w.Write(data)
+	c.n += int64(n)
+	return n, err
+}
+
+// NewList prepares a list response on w; nothing is written until the
+// first Add or Close, so the handler may still set headers or fail.
+func NewList(w http.ResponseWriter, opts Options) *List {
+	if opts.FlushEvery <= 0 {
+		opts.FlushEvery = 100
+	}
+
+	sent := &counter{w: w}
+	l := &List{w: w, sent: sent, out: bufio.NewWriterSize(sent, 32<<10), opts: opts}
+	l.encoder = json.NewEncoder(&l.item)
+	return l
+}
+
+// Add encodes one item. An item that fails to encode writes nothing.
+func (l *List) Add(item interface{}) error {
+	if l.closed {
+		return ErrClosed
+	}
+
+	// Encoder writes a trailing newline, which is harmless inside an
+	// array and required between NDJSON items.
+	l.item.Reset()
+	if err := l.encoder.Encode(item); err != nil {
+		return err
+	}
+
+	l.start()
+	if l.count > 0 && !l.opts.NDJSON {
+		l.out.WriteByte(',')
+	}
+	if _, err := l.out.Write(l.item.Bytes()); err != nil {
+		return err
+	}
+
+	l.count++
+	if l.count%l.opts.FlushEvery == 0 {
+		return l.flush()
+	}
+	return nil
+}
+
+// Count returns the number of items written so far.
+func (l *List) Count() int {
+	return l.count
+}
+
+// Sent reports whether any byte reached the client, after which the
+// status can't change anymore and failures must Abort.
+func (l *List) Sent() bool {
+	return l.sent.n > 0
+}
+
+// Close terminates the list and flushes it.
+func (l *List) Close() error {
+	if l.closed {
+		return nil
+	}
+
+	l.start()
+	l.closed = true
+
+	if !l.opts.NDJSON {
+		l.out.WriteByte(']')
+		if len(l.opts.Key) > 0 {
+			l.out.WriteByte('}')
+		}
+		l.out.WriteByte('\n')
+	}
+	return l.flush()
+}
+
+// Abort ends the response abruptly after a failure mid-stream. The
+// status has already been sent, so breaking the connection is the only
+// way to tell the client the list is incomplete.
+func (l *List) Abort() {
+	panic(http.ErrAbortHandler)
+}
+
+func (l *List) start() {
+	if l.started {
+		return
+	}
+	l.started = true
+
+	if l.opts.NDJSON {
+		l.w.Header().Set("Content-Type", "application/x-ndjson")
+		return
+	}
+
+	l.w.Header().Set("Content-Type", "application/json")
+	if len(l.opts.Key) > 0 {
+		key, _ := json.Marshal(l.opts.Key)
+		l.out.WriteByte('{')
+		l.out.Write(key)
+		l.out.WriteByte(':')
+	}
+	l.out.WriteByte('[')
+}
+
+func (l *List) flush() error {
+	if err := l.out.Flush(); err != nil {
+		return err
+	}
+
+	if flusher, ok := l.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// Write streams every item produced by next until it reports no more
+// items. A failure, of next or of encoding an item, is returned with the
+// buffered items discarded while nothing was sent yet, so the handler
+// can still answer with an error status; once bytes went out it aborts
+// the response.
+func Write(w http.ResponseWriter, opts Options, next func() (interface{}, bool, error)) error {
+	list := NewList(w, opts)
+
+	for {
+		item, ok, err := next()
+		if err == nil && !ok {
+			return list.Close()
+		}
+
+		if err == nil {
+			err = list.Add(item)
+		}
+
+		if err != nil {
+			if list.Sent() {
+				list.Abort()
+			}
+			return err
+		}
+	}
+}
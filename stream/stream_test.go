@@ -0,0 +1,152 @@
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// items returns a next function producing n numbers, then failing with
+// err when it is set.
+func items(n int, err error) func() (interface{}, bool, error) {
+	i := 0
+	return func() (interface{}, bool, error) {
+		if i == n {
+			if err != nil {
+				return nil, false, err
+			}
+			return nil, false, nil
+		}
+		i++
+		return map[string]int{"n": i}, true, nil
+	}
+}
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		n    int
+		ct   string
+		want string
+	}{
+		{"empty array", Options{}, 0, "application/json", "[]\n"},
+		{"array", Options{FlushEvery: 1}, 2, "application/json", `[{"n":1}` + "\n" + `,{"n":2}` + "\n]\n"},
+		{"keyed", Options{Key: "items"}, 1, "application/json", `{"items":[{"n":1}` + "\n]}\n"},
+		{"ndjson", Options{NDJSON: true}, 2, "application/x-ndjson", `{"n":1}` + "\n" + `{"n":2}` + "\n"},
+		{"empty ndjson", Options{NDJSON: true}, 0, "application/x-ndjson", ""},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		if err := Write(w, test.opts, items(test.n, nil)); err != nil {
+			t.Errorf("%s: Write = %v", test.name, err)
+			continue
+		}
+
+		if w.Body.String() != test.want {
+			t.Errorf("%s: body %q; want %q", test.name, w.Body.String(), test.want)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != test.ct {
+			t.Errorf("%s: Content-Type %q; want %q", test.name, ct, test.ct)
+		}
+		if !test.opts.NDJSON && !json.Valid(w.Body.Bytes()) {
+			t.Errorf("%s: invalid JSON %q", test.name, w.Body.String())
+		}
+	}
+}
+
+func TestWriteFailure(t *testing.T) {
+	failure := errors.New("cursor lost")
+	large := strings.Repeat("x", 1024)
+
+	tests := []struct {
+		name  string
+		next  func() (interface{}, bool, error)
+		abort bool
+	}{
+		{"before anything", items(0, failure), false},
+		{"buffered only", items(5, failure), false},
+		{"after an explicit flush", items(100, failure), true},
+		{"after the buffer filled", func() func() (interface{}, bool, error) {
+			i := 0
+			return func() (interface{}, bool, error) {
+				if i == 64 {
+					return nil, false, failure
+				}
+				i++
+				return large, true, nil
+			}
+		}(), true},
+		{"item fails to encode", func() func() (interface{}, bool, error) {
+			sent := false
+			return func() (interface{}, bool, error) {
+				if sent {
+					return make(chan int), true, nil
+				}
+				sent = true
+				return 1, true, nil
+			}
+		}(), false},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+
+		aborted, err := func() (aborted bool, err error) {
+			defer func() {
+				if recover() == http.ErrAbortHandler {
+					aborted = true
+				}
+			}()
+			return false, Write(w, Options{FlushEvery: 100}, test.next)
+		}()
+
+		if aborted != test.abort {
+			t.Errorf("%s: aborted = %v; want %v", test.name, aborted, test.abort)
+		}
+		if !aborted {
+			if err == nil {
+				t.Errorf("%s: no error", test.name)
+			}
+			if w.Body.Len() > 0 {
+				t.Errorf("%s: %d bytes sent before the error", test.name, w.Body.Len())
+			}
+		}
+	}
+}
+
+func TestAddAfterEncodeFailure(t *testing.T) {
+	w := httptest.NewRecorder()
+	list := NewList(w, Options{})
+
+	steps := []struct {
+		item interface{}
+		fail bool
+	}{
+		{1, false},
+		{make(chan int), true},
+		{2, false},
+	}
+
+	for i, step := range steps {
+		if err := list.Add(step.item); (err != nil) != step.fail {
+			t.Errorf("Add #%d = %v", i, err)
+		}
+	}
+
+	if err := list.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := list.Add(3); err != ErrClosed {
+		t.Errorf("Add after Close = %v", err)
+	}
+
+	var got []int
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || len(got) != 2 || list.Count() != 2 {
+		t.Errorf("body %q (%v), count %d", w.Body.String(), err, list.Count())
+	}
+}
@@ -0,0 +1,78 @@
+package upload
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Dir stores uploads in a local directory. Files are written to a
+// temporary name and linked into place on Commit, so readers never see a
+// partial upload and an existing file is never overwritten.
+type Dir struct {
+	Path string
+
+	// Name chooses the final file name. By default the client's file name
+	// is prefixed with a random identifier, so uploads never collide.
+	Name func(meta Meta) string
+}
+
+// Create implements Sink.
+func (d Dir) Create(meta Meta) (Object, error) {
+	if err := os.MkdirAll(d.Path, 0755); err != nil {
+		return nil, err
+	}
+
+	var name string
+	if d.Name != nil {
+		name = filepath.Base(d.Name(meta))
+	} else {
+		name = filepath.Base(meta.Filename)
+
+		raw := make([]byte, 8)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		name = hex.EncodeToString(raw) + "-" + name
+	}
+
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		return nil, fmt.Errorf("upload: invalid file name %q", name)
+	}
+
+	temp, err := os.CreateTemp(d.Path, ".upload-*")
+	if err != nil {
+		return nil, err
+	}
+
+	return &dirObject{File: temp, target: filepath.Join(d.Path, name)}, nil
+}
+
+type dirObject struct {
+	*os.File
+	target string
+}
+
+func (o *dirObject) Commit() (string, error) {
+	defer os.Remove(o.File.Name())
+
+	if err := o.File.Close(); err != nil {
+		return "", err
+	}
+
+	// Unlike a rename, a hard link fails when the target exists.
+	if err := os.Link(o.File.Name(), o.target); err != nil {
+		if os.IsExist(err) {
+			return "", fmt.Errorf("%w: %s", ErrExists, filepath.Base(o.target))
+		}
+		return "", err
+	}
+	return o.target, nil
+}
+
+func (o *dirObject) Abort() error {
+	o.File.Close()
+	return os.Remove(o.File.Name())
+}
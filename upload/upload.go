@@ -0,0 +1,283 @@
+// Package upload streams multipart request bodies into a Sink without
+// buffering whole files in memory, enforcing size limits and content-type
+// allowlists along the way.
+package upload
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"devops.io/cloud/compress"
+)
+
+var (
+	// ErrTooLarge is returned when a file or the whole body exceeds its
+	// configured limit.
+	ErrTooLarge = errors.New("upload: payload too large")
+
+	// ErrContentType is returned when a file's content type is not in the
+	// allowlist.
+	ErrContentType = errors.New("upload: content type not allowed")
+
+	// ErrNotMultipart is returned when the request is not multipart/form-data.
+	ErrNotMultipart = errors.New("upload: request is not multipart")
+
+	// ErrExists is returned by Dir when the target file already exists.
+	ErrExists = errors.New("upload: file already exists")
+)
+
+// Meta describes a file part before its content is read. ContentType is
+// sniffed from the content; Declared is what the client claimed and is
+// informational only.
+type Meta struct {
+	Field       string
+	Filename    string
+	ContentType string
+	Declared    string
+}
+
+// File describes a stored file.
+type File struct {
+	Meta
+	Size   int64
+	SHA256 string
+	Path   string
+}
+
+// Progress reports how far an upload has gone. Total is -1 when the
+// client didn't send a Content-Length.
+type Progress struct {
+	Filename string
+	Received int64
+	Total    int64
+}
+
+// Object is a destination being written by Receive. Commit is called once
+// the part was read completely; Abort is called on any failure.
+type Object interface {
+	io.Writer
+	Commit() (string, error)
+	Abort() error
+}
+
+// Sink creates destinations for uploaded files, e.g. a local directory or
+// an object storage bucket.
+type Sink interface {
+	Create(meta Meta) (Object, error)
+}
+
+// Options configures Receive.
+type Options struct {
+	// MaxBodyBytes caps the entire request body; zero disables the cap.
+	MaxBodyBytes int64
+
+	// MaxFileBytes caps every single file; zero disables the cap.
+	MaxFileBytes int64
+
+	// AllowedTypes lists accepted media types, matched against the type
+	// sniffed from the content as http.DetectContentType reports it (so
+	// JSON and CSV are "text/plain"). Entries ending in "/*" match a
+	// whole family, e.g. "image/*". Empty allows everything.
+	AllowedTypes []string
+
+	// Progress, if set, is called after every chunk written to the sink.
+	Progress func(Progress)
+}
+
+// Receive reads every file part of a multipart request into sink. Regular
+// form fields are returned in values. On error every file stored so far is
+// kept, the failing one is aborted. Bodies sent with "Content-Encoding:
+// gzip" are decompressed; MaxBodyBytes then caps both the compressed and
+// the decompressed size.
+func Receive(w http.ResponseWriter, r *http.Request, sink Sink, opts Options) ([]File, map[string]string, error) {
+	if opts.MaxBodyBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, opts.MaxBodyBytes)
+	}
+
+	if err := compress.Decode(r, opts.MaxBodyBytes, compress.Options{Kind: "upload"}); err != nil {
+		return nil, nil, err
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, ErrNotMultipart
+	}
+
+	total := r.ContentLength
+	if total <= 0 {
+		total = -1
+	}
+
+	files := []File{}
+	values := map[string]string{}
+	received := int64(0)
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return files, values, nil
+		} else if err != nil {
+			return files, values, translate(err)
+		}
+
+		if len(part.FileName()) == 0 {
+			value, err := readValue(part, 64<<10)
+			part.Close()
+
+			if err != nil {
+				return files, values, err
+			}
+
+			values[part.FormName()] = value
+			continue
+		}
+
+		file, err := store(part, sink, opts, func(n int64) {
+			received += n
+			if opts.Progress != nil {
+				opts.Progress(Progress{
+					Filename: part.FileName(),
+					Received: received,
+					Total:    total,
+				})
+			}
+		})
+		part.Close()
+
+		if err != nil {
+			return files, values, err
+		}
+		files = append(files, file)
+	}
+}
+
+func readValue(part io.Reader, limit int64) (string, error) {
+	raw, err := io.ReadAll(io.LimitReader(part, limit+1))
+	if err != nil {
+		return "", translate(err)
+	}
+
+	if int64(len(raw)) > limit {
+		return "", ErrTooLarge
+	}
+	return string(raw), nil
+}
+
+func store(part *multipart.Part, sink Sink, opts Options, advance func(int64)) (File, error) {
+	buffered := bufio.NewReader(part)
+	head, _ := buffered.Peek(512)
+
+	meta := Meta{
+		Field:       part.FormName(),
+		Filename:    filepath.Base(part.FileName()),
+		ContentType: sniff(head),
+		Declared:    part.Header.Get("Content-Type"),
+	}
+
+	if !allowed(meta.ContentType, opts.AllowedTypes) {
+		return File{}, fmt.Errorf("%w: %s", ErrContentType, meta.ContentType)
+	}
+
+	object, err := sink.Create(meta)
+	if err != nil {
+		return File{}, err
+	}
+
+	digest := sha256.New()
+	source := io.Reader(buffered)
+
+	if opts.MaxFileBytes > 0 {
+		source = io.LimitReader(source, opts.MaxFileBytes+1)
+	}
+
+	size, err := io.Copy(io.MultiWriter(object, digest, counter(advance)), source)
+	if err == nil && opts.MaxFileBytes > 0 && size > opts.MaxFileBytes {
+		err = ErrTooLarge
+	}
+
+	if err != nil {
+		object.Abort()
+		return File{}, translate(err)
+	}
+
+	path, err := object.Commit()
+	if err != nil {
+		return File{}, err
+	}
+
+	return File{
+		Meta:   meta,
+		Size:   size,
+		SHA256: hex.EncodeToString(digest.Sum(nil)),
+		Path:   path,
+	}, nil
+}
+
+// counter reports the size of every chunk copied through it.
+type counter func(int64)
+
+func (c counter) Write(data []byte) (int, error) {
+	c(int64(len(data)))
+	return len(data), nil
+}
+
+// sniff returns the media type of a file from its first bytes. The type
+// declared by the client is never trusted, since a single header would
+// otherwise bypass the allowlist.
+func sniff(head []byte) string {
+	mediatype, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	return mediatype
+}
+
+func allowed(mediatype string, allowlist []string) bool {
+	if len(allowlist) == 0 {
+		return true
+	}
+
+	for _, item := range allowlist {
+		if item == mediatype {
+			return true
+		}
+
+		if strings.HasSuffix(item, "/*") &&
+			strings.HasPrefix(mediatype, strings.TrimSuffix(item, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func translate(err error) error {
+	// http.MaxBytesReader only exposes its failure through this message.
+	if err.Error() == "http: request body too large" || errors.Is(err, compress.ErrTooLarge) {
+		return ErrTooLarge
+	}
+	return err
+}
+
+// Status maps an error returned by Receive to the HTTP status code that
+// should be reported to the client.
+func Status(err error) int {
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.Is(err, ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrContentType), errors.Is(err, compress.ErrUnsupported):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, ErrNotMultipart), errors.Is(err, compress.ErrCorrupt):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrExists):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,196 @@
+package upload
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type part struct {
+	field    string
+	filename string
+	declared string
+	content  string
+}
+
+func request(t *testing.T, parts ...part) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	for _, p := range parts {
+		header := textproto.MIMEHeader{}
+		if len(p.filename) > 0 {
+			header.Set("Content-Disposition", `form-data; name="`+p.field+`"; filename="`+p.filename+`"`)
+		} else {
+			header.Set("Content-Disposition", `form-data; name="`+p.field+`"`)
+		}
+		if len(p.declared) > 0 {
+			header.Set("Content-Type", p.declared)
+		}
+
+		w, err := writer.CreatePart(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(p.content))
+	}
+	writer.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	return r
+}
+
+const png = "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+
+func TestReceive(t *testing.T) {
+	tests := []struct {
+		name   string
+		parts  []part
+		opts   Options
+		status int
+		types  []string
+	}{
+		{
+			name:   "sniffed type allowed",
+			parts:  []part{{field: "file", filename: "a.png", content: png}},
+			opts:   Options{AllowedTypes: []string{"image/*"}},
+			status: http.StatusOK,
+			types:  []string{"image/png"},
+		},
+		{
+			name:   "declared type ignored",
+			parts:  []part{{field: "file", filename: "a.png", declared: "image/png", content: "<html><script>alert(1)</script>"}},
+			opts:   Options{AllowedTypes: []string{"image/*"}},
+			status: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:   "text allowed by exact type",
+			parts:  []part{{field: "file", filename: "a.json", declared: "application/json", content: `{"a": 1}`}},
+			opts:   Options{AllowedTypes: []string{"text/plain"}},
+			status: http.StatusOK,
+			types:  []string{"text/plain"},
+		},
+		{
+			name:   "file too large",
+			parts:  []part{{field: "file", filename: "a.txt", content: strings.Repeat("x", 100)}},
+			opts:   Options{MaxFileBytes: 10},
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name: "values and several files",
+			parts: []part{
+				{field: "note", content: "hello"},
+				{field: "file", filename: "a.txt", content: "first"},
+				{field: "file", filename: "a.txt", content: "second"},
+			},
+			status: http.StatusOK,
+			types:  []string{"text/plain", "text/plain"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			files, _, err := Receive(httptest.NewRecorder(), request(t, test.parts...), Dir{Path: dir}, test.opts)
+
+			if status := Status(err); status != test.status {
+				t.Fatalf("Status = %d (%v); want %d", status, err, test.status)
+			}
+			if err != nil {
+				return
+			}
+
+			if len(files) != len(test.types) {
+				t.Fatalf("got %d files; want %d", len(files), len(test.types))
+			}
+
+			for i, file := range files {
+				if file.ContentType != test.types[i] {
+					t.Errorf("file %d type = %q; want %q", i, file.ContentType, test.types[i])
+				}
+				if filepath.Dir(file.Path) != dir || filepath.Base(file.Path) == file.Filename {
+					t.Errorf("file %d stored as %q; want a unique name in %q", i, file.Path, dir)
+				}
+			}
+		})
+	}
+}
+
+func TestDirRefusesOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "report.txt"), []byte("original"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	sink := Dir{Path: dir, Name: func(meta Meta) string {
+		return meta.Filename
+	}}
+
+	_, _, err := Receive(httptest.NewRecorder(), request(t, part{field: "file", filename: "report.txt", content: "replaced"}), sink, Options{})
+	if !errors.Is(err, ErrExists) || Status(err) != http.StatusConflict {
+		t.Fatalf("Receive = %v; want ErrExists", err)
+	}
+
+	content, _ := os.ReadFile(filepath.Join(dir, "report.txt"))
+	if string(content) != "original" {
+		t.Errorf("file content = %q; want it untouched", content)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d entries; want the temporary file removed", len(entries))
+	}
+}
+
+func TestReceiveGzip(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding string
+		corrupt  bool
+		limit    int64
+		status   int
+	}{
+		{name: "gzip body", encoding: "gzip", status: http.StatusOK},
+		{name: "decompressed size capped", encoding: "gzip", limit: 2048, status: http.StatusRequestEntityTooLarge},
+		{name: "corrupt body", encoding: "gzip", corrupt: true, status: http.StatusBadRequest},
+		{name: "unsupported encoding", encoding: "br", status: http.StatusUnsupportedMediaType},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plain := request(t, part{field: "file", filename: "log.txt", content: strings.Repeat("line\n", 1000)})
+			raw, _ := io.ReadAll(plain.Body)
+
+			var body bytes.Buffer
+			if test.corrupt || test.encoding != "gzip" {
+				body.Write(raw)
+			} else {
+				writer := gzip.NewWriter(&body)
+				writer.Write(raw)
+				writer.Close()
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/upload", &body)
+			r.Header.Set("Content-Type", plain.Header.Get("Content-Type"))
+			r.Header.Set("Content-Encoding", test.encoding)
+
+			files, _, err := Receive(httptest.NewRecorder(), r, Dir{Path: t.TempDir()}, Options{MaxBodyBytes: test.limit})
+			if status := Status(err); status != test.status {
+				t.Fatalf("Status = %d (%v); want %d", status, err, test.status)
+			}
+			if err == nil && (len(files) != 1 || files[0].Size != 5000) {
+				t.Errorf("files = %+v", files)
+			}
+		})
+	}
+}
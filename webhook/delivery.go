@@ -0,0 +1,99 @@
+package webhook
+
+import (
+	"sync"
+	"time"
+)
+
+// Attempt records one HTTP request of a delivery.
+type Attempt struct {
+	Time     time.Time     `json:"time"`
+	Status   int           `json:"status,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Delivery is the outcome of posting one event to one subscriber.
+type Delivery struct {
+	ID         string    `json:"id"`
+	Subscriber string    `json:"subscriber"`
+	Event      uint64    `json:"event"`
+	Topic      string    `json:"topic"`
+	Payload    []byte    `json:"-"`
+	Attempts   []Attempt `json:"attempts"`
+	Succeeded  bool      `json:"succeeded"`
+	Finished   bool      `json:"finished"`
+	Redelivery bool      `json:"redelivery,omitempty"`
+}
+
+// journal keeps the most recent deliveries in memory.
+type journal struct {
+	mu    sync.RWMutex
+	size  int
+	order []string
+	items map[string]*Delivery
+}
+
+func newJournal(size int) *journal {
+	if size <= 0 {
+		size = 1000
+	}
+	return &journal{size: size, items: make(map[string]*Delivery)}
+}
+
+func (j *journal) add(delivery *Delivery) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if len(j.order) >= j.size {
+		delete(j.items, j.order[0])
+		j.order = j.order[1:]
+	}
+
+	j.order = append(j.order, delivery.ID)
+	j.items[delivery.ID] = delivery
+}
+
+// update changes delivery under the journal lock. It applies even when
+// the delivery was already evicted, so its sender keeps consistent state.
+func (j *journal) update(delivery *Delivery, change func(delivery *Delivery)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	change(delivery)
+}
+
+// get returns a copy of a delivery so callers never race with updates.
+func (j *journal) get(id string) (Delivery, bool) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	delivery, ok := j.items[id]
+	if !ok {
+		return Delivery{}, false
+	}
+
+	copied := *delivery
+	copied.Attempts = append([]Attempt(nil), delivery.Attempts...)
+	return copied, true
+}
+
+// list returns copies of the deliveries to subscriber (all when empty),
+// newest first.
+func (j *journal) list(subscriber string) []Delivery {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	result := []Delivery{}
+	for i := len(j.order) - 1; i >= 0; i-- {
+		delivery := j.items[j.order[i]]
+		if len(subscriber) > 0 && delivery.Subscriber != subscriber {
+			continue
+		}
+
+		copied := *delivery
+		copied.Attempts = append([]Attempt(nil), delivery.Attempts...)
+		result = append(result, copied)
+	}
+	return result
+}
@@ -0,0 +1,371 @@
+// Package webhook delivers internal bus events to registered subscriber
+// URLs. Every request is signed with the subscriber's secret, failed
+// deliveries are retried with exponential backoff, and the most recent
+// deliveries are kept for inspection and redelivery.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	mrand "math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"devops.io/cloud/eventbus"
+	"devops.io/cloud/httpclient"
+	"devops.io/cloud/workerpool"
+)
+
+// ErrUnknownDelivery is returned by Redeliver for unknown delivery IDs.
+var ErrUnknownDelivery = errors.New("webhook: unknown delivery")
+
+// Subscriber is a registered receiver.
+type Subscriber struct {
+	ID     string   `json:"id"`
+	URL    string   `json:"url"`
+	Secret string   `json:"-"`
+	Topics []string `json:"topics"`
+}
+
+func (s Subscriber) matches(topic eventbus.Topic) bool {
+	if len(s.Topics) == 0 {
+		return true
+	}
+
+	for _, pattern := range s.Topics {
+		if eventbus.Match(pattern, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// Options configures a Dispatcher.
+type Options struct {
+	Client *http.Client
+
+	// Workers bounds concurrent deliveries; it defaults to 4.
+	Workers int
+
+	// MaxAttempts per delivery; it defaults to 5.
+	MaxAttempts int
+
+	// Backoff is the delay before the first retry, doubled on every
+	// further attempt up to MaxBackoff. Defaults are 1s and 5m.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// History is how many deliveries are kept; it defaults to 1000.
+	History int
+}
+
+// Dispatcher posts bus events to subscribers.
+type Dispatcher struct {
+	opts        Options
+	pool        *workerpool.Pool
+	journal     *journal
+	mu          sync.RWMutex
+	subscribers map[string]Subscriber
+	sub         *eventbus.Subscription
+
+	// retries holds the timers of deliveries waiting for their next
+	// attempt, so workers never sleep through a backoff.
+	retries map[*Delivery]*time.Timer
+	stopped bool
+}
+
+// New creates a dispatcher. Call Start to attach it to a bus.
+func New(opts Options) *Dispatcher {
+	if opts.Client == nil {
+		// Deliveries are retried by the dispatcher itself, so the client
+		// only contributes the timeout and the per-host breaker.
+		opts.Client = httpclient.New(httpclient.Options{MaxAttempts: 1})
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 4
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = time.Second
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 5 * time.Minute
+	}
+
+	return &Dispatcher{
+		opts:        opts,
+		pool:        workerpool.New(workerpool.Options{Workers: opts.Workers}),
+		journal:     newJournal(opts.History),
+		subscribers: make(map[string]Subscriber),
+		retries:     make(map[*Delivery]*time.Timer),
+	}
+}
+
+// AddSubscriber registers or replaces a subscriber.
+func (d *Dispatcher) AddSubscriber(subscriber Subscriber) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.subscribers[subscriber.ID] = subscriber
+}
+
+// RemoveSubscriber unregisters a subscriber.
+func (d *Dispatcher) RemoveSubscriber(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.subscribers, id)
+}
+
+// Subscribers lists the registered subscribers.
+func (d *Dispatcher) Subscribers() []Subscriber {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	result := make([]Subscriber, 0, len(d.subscribers))
+	for _, subscriber := range d.subscribers {
+		result = append(result, subscriber)
+	}
+	return result
+}
+
+// Start subscribes to every event of bus. Event fan-out happens on the
+// subscription's goroutine and HTTP requests on the worker pool, so
+// publishers are never slowed down by slow receivers.
+func (d *Dispatcher) Start(bus *eventbus.Bus) {
+	d.sub = bus.Subscribe(d.dispatch, eventbus.Options{
+		Async:        true,
+		Buffer:       256,
+		Backpressure: eventbus.Block,
+	})
+}
+
+// Stop detaches from the bus and waits for running deliveries until ctx
+// expires. Deliveries waiting for a retry are finished as failed.
+func (d *Dispatcher) Stop(ctx context.Context) error {
+	if d.sub != nil {
+		d.sub.Unsubscribe()
+		<-d.sub.Done()
+	}
+
+	d.mu.Lock()
+	d.stopped = true
+	retries := d.retries
+	d.retries = make(map[*Delivery]*time.Timer)
+	d.mu.Unlock()
+
+	for delivery, timer := range retries {
+		if timer.Stop() {
+			d.fail(delivery, "dispatcher stopped before the next attempt")
+		}
+	}
+	return d.pool.Drain(ctx)
+}
+
+func (d *Dispatcher) dispatch(event eventbus.Event) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	d.mu.RLock()
+	var deliveries []*Delivery
+	for _, subscriber := range d.subscribers {
+		if !subscriber.matches(event.Topic) {
+			continue
+		}
+
+		deliveries = append(deliveries, &Delivery{
+			ID:         newID(),
+			Subscriber: subscriber.ID,
+			Event:      event.ID,
+			Topic:      string(event.Topic),
+			Payload:    payload,
+		})
+	}
+	d.mu.RUnlock()
+
+	// enqueue records a rejected delivery as failed in the journal, so
+	// its error needs no further handling here.
+	for _, delivery := range deliveries {
+		d.enqueue(delivery)
+	}
+}
+
+// enqueue journals delivery and queues its first attempt. When the pool
+// refuses it, the delivery is finished as failed and the error returned.
+func (d *Dispatcher) enqueue(delivery *Delivery) error {
+	d.journal.add(delivery)
+	return d.submit(delivery, 1)
+}
+
+// submit queues one attempt of delivery. The job holds the delivery
+// itself, not its ID, since the journal may evict it meanwhile.
+func (d *Dispatcher) submit(delivery *Delivery, attempt int) error {
+	_, err := d.pool.Go(func(ctx context.Context) error {
+		d.attempt(ctx, delivery, attempt)
+		return nil
+	})
+
+	if err != nil {
+		d.fail(delivery, fmt.Sprintf("not queued: %v", err))
+	}
+	return err
+}
+
+// fail finishes delivery with an attempt recording reason.
+func (d *Dispatcher) fail(delivery *Delivery, reason string) {
+	d.journal.update(delivery, func(item *Delivery) {
+		item.Finished = true
+		item.Attempts = append(item.Attempts, Attempt{Time: time.Now(), Error: reason})
+	})
+}
+
+// Redeliver posts the payload of an earlier delivery again as a new
+// delivery and returns its ID.
+func (d *Dispatcher) Redeliver(id string) (string, error) {
+	previous, ok := d.journal.get(id)
+	if !ok {
+		return "", ErrUnknownDelivery
+	}
+
+	delivery := &Delivery{
+		ID:         newID(),
+		Subscriber: previous.Subscriber,
+		Event:      previous.Event,
+		Topic:      previous.Topic,
+		Payload:    previous.Payload,
+		Redelivery: true,
+	}
+	return delivery.ID, d.enqueue(delivery)
+}
+
+// Delivery returns a delivery by ID.
+func (d *Dispatcher) Delivery(id string) (Delivery, bool) {
+	return d.journal.get(id)
+}
+
+// Deliveries returns recent deliveries, newest first, optionally only
+// those to one subscriber.
+func (d *Dispatcher) Deliveries(subscriber string) []Delivery {
+	return d.journal.list(subscriber)
+}
+
+// attempt posts delivery once and, if it should be retried, schedules the
+// next attempt after the backoff instead of holding the worker.
+func (d *Dispatcher) attempt(ctx context.Context, delivery *Delivery, attempt int) {
+	d.mu.RLock()
+	subscriber, ok := d.subscribers[delivery.Subscriber]
+	d.mu.RUnlock()
+
+	if !ok {
+		d.fail(delivery, "subscriber removed")
+		return
+	}
+
+	result, retry := d.post(ctx, subscriber, delivery)
+	retry = retry && attempt < d.opts.MaxAttempts
+
+	d.journal.update(delivery, func(item *Delivery) {
+		item.Attempts = append(item.Attempts, result)
+		item.Succeeded = !retry && len(result.Error) == 0
+		item.Finished = !retry
+	})
+
+	if !retry {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.stopped {
+		d.fail(delivery, "dispatcher stopped before the next attempt")
+		return
+	}
+
+	d.retries[delivery] = time.AfterFunc(d.backoff(attempt), func() {
+		d.mu.Lock()
+		delete(d.retries, delivery)
+		d.mu.Unlock()
+
+		d.submit(delivery, attempt+1)
+	})
+}
+
+// post performs one attempt and reports whether it should be retried.
+func (d *Dispatcher) post(ctx context.Context, subscriber Subscriber, delivery *Delivery) (Attempt, bool) {
+	started := time.Now()
+	result := Attempt{Time: started}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, subscriber.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		result.Error = err.Error()
+		return result, false
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "automation-webhook/1")
+	request.Header.Set("X-Automation-Event", delivery.Topic)
+	request.Header.Set("X-Automation-Delivery", delivery.ID)
+	if len(subscriber.Secret) > 0 {
+		request.Header.Set(SignatureHeader, Sign([]byte(subscriber.Secret), delivery.Payload))
+	}
+
+	response, err := d.opts.Client.Do(request)
+	result.Duration = time.Since(started)
+
+	if err != nil {
+		result.Error = err.Error()
+		return result, true
+	}
+	defer response.Body.Close()
+
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+	result.Status = response.StatusCode
+
+	switch {
+	case response.StatusCode/100 == 2:
+		return result, false
+
+	case response.StatusCode == http.StatusTooManyRequests,
+		response.StatusCode == http.StatusRequestTimeout,
+		response.StatusCode >= 500:
+		result.Error = response.Status
+		return result, true
+	}
+
+	result.Error = response.Status
+	return result, false
+}
+
+// backoff doubles the delay per attempt and adds up to 20% jitter so
+// receivers recovering from an outage aren't hit by synchronized retries.
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	delay := d.opts.Backoff
+	for i := 1; i < attempt && delay < d.opts.MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > d.opts.MaxBackoff {
+		delay = d.opts.MaxBackoff
+	}
+	return delay + time.Duration(mrand.Int63n(int64(delay)/5+1))
+}
+
+func newID() string {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		panic(fmt.Sprintf("webhook: %v", err))
+	}
+	return hex.EncodeToString(raw)
+}
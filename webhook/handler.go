@@ -0,0 +1,56 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Handler exposes the delivery log. It must be mounted with its prefix
+// stripped:
+//
+//	GET  /deliveries?subscriber=ID       recent deliveries, newest first
+//	GET  /deliveries/{id}                one delivery with its attempts
+//	POST /deliveries/{id}/redeliver      post the same payload again
+func (d *Dispatcher) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if parts[0] != "deliveries" {
+			http.NotFound(w, r)
+			return
+		}
+
+		switch {
+		case len(parts) == 1 && r.Method == http.MethodGet:
+			reply(w, http.StatusOK, d.Deliveries(r.URL.Query().Get("subscriber")))
+
+		case len(parts) == 2 && r.Method == http.MethodGet:
+			delivery, ok := d.Delivery(parts[1])
+			if !ok {
+				http.Error(w, "delivery not found", http.StatusNotFound)
+				return
+			}
+			reply(w, http.StatusOK, delivery)
+
+		case len(parts) == 3 && parts[2] == "redeliver" && r.Method == http.MethodPost:
+			id, err := d.Redeliver(parts[1])
+			if err == ErrUnknownDelivery {
+				http.Error(w, "delivery not found", http.StatusNotFound)
+				return
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			reply(w, http.StatusAccepted, map[string]string{"id": id})
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func reply(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(value)
+}
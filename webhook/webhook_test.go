@@ -0,0 +1,248 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"devops.io/cloud/eventbus"
+)
+
+func TestVerify(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"id":1}`)
+
+	tests := []struct {
+		name      string
+		body      []byte
+		signature string
+		want      bool
+	}{
+		{"valid", body, Sign(secret, body), true},
+		{"other body", []byte(`{"id":2}`), Sign(secret, body), false},
+		{"other secret", body, Sign([]byte("other"), body), false},
+		{"missing prefix", body, strings.TrimPrefix(Sign(secret, body), "sha256="), false},
+		{"empty", body, "", false},
+	}
+
+	for _, test := range tests {
+		if got := Verify(secret, test.body, test.signature); got != test.want {
+			t.Errorf("%s: Verify = %v; want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// receiver answers the statuses in turn, repeating the last one.
+func receiver(t *testing.T, secret string, statuses ...int) (*httptest.Server, *int32) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&calls, 1)) - 1
+		if n >= len(statuses) {
+			n = len(statuses) - 1
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		if len(secret) > 0 && !Verify([]byte(secret), body, r.Header.Get(SignatureHeader)) {
+			t.Errorf("request %d has a bad signature", n)
+		}
+		w.WriteHeader(statuses[n])
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+// finished waits until delivery is finished and returns a copy of it.
+func finished(t *testing.T, d *Dispatcher, delivery *Delivery) Delivery {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		d.journal.mu.RLock()
+		copied := *delivery
+		copied.Attempts = append([]Attempt(nil), delivery.Attempts...)
+		d.journal.mu.RUnlock()
+
+		if copied.Finished {
+			return copied
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatalf("delivery %s never finished", delivery.ID)
+	return Delivery{}
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name        string
+		statuses    []int
+		maxAttempts int
+		attempts    int
+		succeeded   bool
+	}{
+		{"first attempt", []int{200}, 5, 1, true},
+		{"after server errors", []int{500, 503, 204}, 5, 3, true},
+		{"rate limited", []int{429, 200}, 5, 2, true},
+		{"client error is final", []int{400}, 5, 1, false},
+		{"gives up", []int{500}, 3, 3, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, calls := receiver(t, "secret", test.statuses...)
+
+			d := New(Options{MaxAttempts: test.maxAttempts, Backoff: time.Millisecond, MaxBackoff: time.Millisecond})
+			d.AddSubscriber(Subscriber{ID: "s", URL: server.URL, Secret: "secret"})
+			defer d.Stop(context.Background())
+
+			delivery := &Delivery{ID: newID(), Subscriber: "s", Payload: []byte(`{"id":1}`)}
+			if err := d.enqueue(delivery); err != nil {
+				t.Fatal(err)
+			}
+
+			result := finished(t, d, delivery)
+			if len(result.Attempts) != test.attempts || result.Succeeded != test.succeeded {
+				t.Errorf("attempts = %d, succeeded = %v; want %d, %v",
+					len(result.Attempts), result.Succeeded, test.attempts, test.succeeded)
+			}
+			if got := int(atomic.LoadInt32(calls)); got != test.attempts {
+				t.Errorf("receiver called %d times; want %d", got, test.attempts)
+			}
+		})
+	}
+}
+
+func TestEvictedDeliveryKeepsRetrying(t *testing.T) {
+	server, _ := receiver(t, "", 500, 500, 200)
+
+	d := New(Options{History: 1, Backoff: time.Millisecond, MaxBackoff: time.Millisecond})
+	d.AddSubscriber(Subscriber{ID: "s", URL: server.URL})
+	defer d.Stop(context.Background())
+
+	delivery := &Delivery{ID: newID(), Subscriber: "s", Payload: []byte("{}")}
+	d.enqueue(delivery)
+	d.journal.add(&Delivery{ID: newID(), Subscriber: "other", Finished: true})
+
+	if _, ok := d.Delivery(delivery.ID); ok {
+		t.Fatal("delivery still journaled")
+	}
+	if result := finished(t, d, delivery); !result.Succeeded || len(result.Attempts) != 3 {
+		t.Errorf("evicted delivery: succeeded = %v after %d attempts", result.Succeeded, len(result.Attempts))
+	}
+}
+
+func TestStop(t *testing.T) {
+	server, calls := receiver(t, "", 500)
+
+	d := New(Options{Backoff: time.Hour})
+	d.AddSubscriber(Subscriber{ID: "s", URL: server.URL})
+
+	delivery := &Delivery{ID: newID(), Subscriber: "s", Payload: []byte("{}")}
+	d.enqueue(delivery)
+
+	for atomic.LoadInt32(calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// A pending retry must neither hold a worker nor delay Stop.
+	if err := d.Stop(ctx); err != nil {
+		t.Fatalf("Stop = %v", err)
+	}
+
+	result := finished(t, d, delivery)
+	if result.Succeeded || len(result.Attempts) != 2 {
+		t.Errorf("stopped delivery: succeeded = %v after %d attempts", result.Succeeded, len(result.Attempts))
+	}
+
+	rejected := &Delivery{ID: newID(), Subscriber: "s"}
+	if err := d.enqueue(rejected); err == nil {
+		t.Error("enqueue after Stop succeeded")
+	}
+	if result := finished(t, d, rejected); result.Succeeded || len(result.Attempts) != 1 {
+		t.Errorf("rejected delivery = %+v", result)
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	server, calls := receiver(t, "", 200)
+
+	d := New(Options{})
+	d.AddSubscriber(Subscriber{ID: "all", URL: server.URL})
+	d.AddSubscriber(Subscriber{ID: "jobs", URL: server.URL, Topics: []string{"job.*"}})
+	d.AddSubscriber(Subscriber{ID: "users", URL: server.URL, Topics: []string{"user.*"}})
+
+	d.dispatch(eventbus.Event{ID: 1, Topic: "job.done"})
+	if err := d.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		subscriber string
+		want       int
+	}{
+		{"all", 1},
+		{"jobs", 1},
+		{"users", 0},
+	}
+
+	for _, test := range tests {
+		if got := len(d.Deliveries(test.subscriber)); got != test.want {
+			t.Errorf("deliveries to %s = %d; want %d", test.subscriber, got, test.want)
+		}
+	}
+	if got := atomic.LoadInt32(calls); got != 2 {
+		t.Errorf("receiver called %d times; want 2", got)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	server, _ := receiver(t, "", 200)
+
+	d := New(Options{})
+	d.AddSubscriber(Subscriber{ID: "s", URL: server.URL})
+	defer d.Stop(context.Background())
+
+	delivery := &Delivery{ID: newID(), Subscriber: "s", Payload: []byte("{}")}
+	d.enqueue(delivery)
+	finished(t, d, delivery)
+
+	tests := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{http.MethodGet, "/deliveries", http.StatusOK},
+		{http.MethodGet, "/deliveries?subscriber=s", http.StatusOK},
+		{http.MethodGet, "/deliveries/" + delivery.ID, http.StatusOK},
+		{http.MethodGet, "/deliveries/missing", http.StatusNotFound},
+		{http.MethodPost, "/deliveries/" + delivery.ID + "/redeliver", http.StatusAccepted},
+		{http.MethodPost, "/deliveries/missing/redeliver", http.StatusNotFound},
+		{http.MethodDelete, "/deliveries", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/other", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		d.Handler().ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+
+		if w.Code != test.code {
+			t.Errorf("%s %s = %d; want %d", test.method, test.path, w.Code, test.code)
+		}
+	}
+
+	redeliveries := 0
+	for _, item := range d.Deliveries("s") {
+		if item.Redelivery {
+			redeliveries++
+		}
+	}
+	if redeliveries != 1 {
+		t.Errorf("redeliveries = %d; want 1", redeliveries)
+	}
+}
@@ -0,0 +1,32 @@
+package workerpool
+
+import "devops.io/cloud/config"
+
+// Configure registers the defaults and validation rules of a pool whose
+// keys live under prefix, e.g. "scheduler":
+//
+//	<prefix>.workers     concurrent jobs
+//	<prefix>.queue_size  waiting jobs, 0 for unbounded
+func Configure(cfg *config.Config, prefix string) {
+	cfg.SetDefault(prefix+".workers", 1)
+	cfg.SetDefault(prefix+".queue_size", 0)
+
+	cfg.AddRule(
+		config.IsInt(prefix+".workers", 1, 1<<16),
+		config.IsInt(prefix+".queue_size", 0, 1<<30),
+	)
+}
+
+// FromConfig starts a pool from the keys registered by Configure.
+func FromConfig(cfg *config.Config, prefix string) (*Pool, error) {
+	workers, err := cfg.Int(prefix + ".workers")
+	if err != nil {
+		return nil, err
+	}
+
+	queueSize, err := cfg.Int(prefix + ".queue_size")
+	if err != nil {
+		return nil, err
+	}
+	return New(Options{Workers: workers, QueueSize: queueSize}), nil
+}
@@ -0,0 +1,245 @@
+// Package workerpool runs background work on a bounded set of goroutines
+// so long-running jobs never block HTTP handler goroutines. Queued jobs are
+// picked by priority, then in submission order.
+package workerpool
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrClosed is returned by Submit once Drain or Stop has been called.
+	ErrClosed = errors.New("workerpool: pool is closed")
+
+	// ErrQueueFull is returned by Submit when the queue is at capacity.
+	ErrQueueFull = errors.New("workerpool: queue is full")
+)
+
+// Priority orders queued jobs; larger values run first.
+type Priority int
+
+const (
+	Low    Priority = -10
+	Normal Priority = 0
+	High   Priority = 10
+)
+
+// Job is a unit of work. The context is cancelled when the job's timeout
+// expires, when its Task is cancelled or when the pool is stopped.
+type Job func(ctx context.Context) error
+
+// Options configures a Pool.
+type Options struct {
+	// Workers is the number of concurrent jobs; it defaults to 1.
+	Workers int
+
+	// QueueSize bounds the number of waiting jobs; zero means unbounded.
+	QueueSize int
+}
+
+// Pool is a bounded worker pool.
+type Pool struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   taskQueue
+	limit   int
+	seq     uint64
+	running map[*Task]struct{}
+	closed  bool
+	workers sync.WaitGroup
+}
+
+// New starts a pool with opts.Workers goroutines.
+func New(opts Options) *Pool {
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+
+	pool := &Pool{
+		limit:   opts.QueueSize,
+		running: make(map[*Task]struct{}),
+	}
+	pool.cond = sync.NewCond(&pool.mu)
+
+	pool.workers.Add(opts.Workers)
+	for i := 0; i < opts.Workers; i++ {
+		go pool.work()
+	}
+	return pool
+}
+
+// Spec describes a job to submit.
+type Spec struct {
+	// Context is the parent of the job's context; it defaults to
+	// context.Background().
+	Context context.Context
+
+	Priority Priority
+
+	// Timeout bounds the job's run time once started; zero disables it.
+	Timeout time.Duration
+
+	Run Job
+}
+
+// Submit queues a job for execution.
+func (p *Pool) Submit(spec Spec) (*Task, error) {
+	parent := spec.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	task := &Task{
+		spec:    spec,
+		ctx:     ctx,
+		cancel:  cancel,
+		started: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		cancel()
+		return nil, ErrClosed
+	} else if p.limit > 0 && len(p.queue) >= p.limit {
+		cancel()
+		return nil, ErrQueueFull
+	}
+
+	p.seq++
+	task.seq = p.seq
+	heap.Push(&p.queue, task)
+	p.cond.Signal()
+
+	go p.watch(task)
+	return task, nil
+}
+
+// watch takes task out of the queue as soon as it is cancelled, freeing
+// its slot and finishing it without waiting for a worker.
+func (p *Pool) watch(task *Task) {
+	select {
+	case <-task.started:
+		return
+	case <-task.ctx.Done():
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if task.index < 0 {
+		// A worker picked it first and reports the cancellation.
+		return
+	}
+
+	heap.Remove(&p.queue, task.index)
+	task.err = task.ctx.Err()
+	close(task.done)
+}
+
+// Go is a shorthand submitting job at Normal priority.
+func (p *Pool) Go(job Job) (*Task, error) {
+	return p.Submit(Spec{Run: job})
+}
+
+// Queued returns the number of jobs waiting for a worker.
+func (p *Pool) Queued() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.queue)
+}
+
+// Running returns the number of jobs currently executing.
+func (p *Pool) Running() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.running)
+}
+
+// Drain stops accepting jobs and waits until every queued and running job
+// has finished. If ctx expires first, the remaining jobs are cancelled and
+// ctx.Err() is returned once the workers have exited.
+func (p *Pool) Drain(ctx context.Context) error {
+	p.close()
+
+	finished := make(chan struct{})
+	go func() {
+		p.workers.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+
+	case <-ctx.Done():
+		p.cancelAll()
+		<-finished
+		return ctx.Err()
+	}
+}
+
+// Stop cancels every queued and running job and waits for the workers to
+// exit.
+func (p *Pool) Stop() {
+	p.close()
+	p.cancelAll()
+	p.workers.Wait()
+}
+
+func (p *Pool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	p.cond.Broadcast()
+}
+
+func (p *Pool) cancelAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, task := range p.queue {
+		task.cancel()
+	}
+
+	for task := range p.running {
+		task.cancel()
+	}
+}
+
+func (p *Pool) work() {
+	defer p.workers.Done()
+
+	for {
+		p.mu.Lock()
+		for len(p.queue) == 0 && !p.closed {
+			p.cond.Wait()
+		}
+
+		if len(p.queue) == 0 {
+			p.mu.Unlock()
+			return
+		}
+
+		task := heap.Pop(&p.queue).(*Task)
+		close(task.started)
+		p.running[task] = struct{}{}
+		p.mu.Unlock()
+
+		task.run()
+
+		p.mu.Lock()
+		delete(p.running, task)
+		p.mu.Unlock()
+	}
+}
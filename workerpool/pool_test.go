@@ -0,0 +1,229 @@
+package workerpool
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"devops.io/cloud/config"
+)
+
+func TestPriorityOrder(t *testing.T) {
+	pool := New(Options{Workers: 1})
+	defer pool.Stop()
+
+	// Hold the only worker so the rest queues up.
+	release := make(chan struct{})
+	pool.Go(func(context.Context) error {
+		<-release
+		return nil
+	})
+
+	var mu sync.Mutex
+	var order []string
+
+	submissions := []struct {
+		name     string
+		priority Priority
+	}{
+		{"low", Low},
+		{"normal-1", Normal},
+		{"high", High},
+		{"normal-2", Normal},
+	}
+
+	var tasks []*Task
+	for _, submission := range submissions {
+		name := submission.name
+		task, err := pool.Submit(Spec{Priority: submission.priority, Run: func(context.Context) error {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			return nil
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tasks = append(tasks, task)
+	}
+
+	close(release)
+	for _, task := range tasks {
+		task.Wait()
+	}
+
+	if got := strings.Join(order, ","); got != "high,normal-1,normal-2,low" {
+		t.Errorf("order = %s", got)
+	}
+}
+
+func TestJobResults(t *testing.T) {
+	failure := errors.New("failure")
+
+	tests := []struct {
+		name    string
+		spec    Spec
+		want    error
+		message string
+	}{
+		{
+			name: "success",
+			spec: Spec{Run: func(context.Context) error { return nil }},
+		},
+		{
+			name: "error",
+			spec: Spec{Run: func(context.Context) error { return failure }},
+			want: failure,
+		},
+		{
+			name: "timeout",
+			spec: Spec{Timeout: 10 * time.Millisecond, Run: func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			}},
+			want: context.DeadlineExceeded,
+		},
+		{
+			name:    "panic",
+			spec:    Spec{Run: func(context.Context) error { panic("boom") }},
+			message: "workerpool: job panicked: boom",
+		},
+	}
+
+	pool := New(Options{Workers: 2})
+	defer pool.Stop()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			task, err := pool.Submit(test.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = task.Wait()
+			if len(test.message) > 0 {
+				if err == nil || err.Error() != test.message {
+					t.Errorf("Wait = %v; want %s", err, test.message)
+				}
+			} else if !errors.Is(err, test.want) {
+				t.Errorf("Wait = %v; want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestQueueLimitsAndCancel(t *testing.T) {
+	pool := New(Options{Workers: 1, QueueSize: 1})
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	pool.Go(func(context.Context) error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+
+	queued, err := pool.Go(func(context.Context) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pool.Go(func(context.Context) error { return nil }); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Submit on a full queue = %v", err)
+	}
+	if pool.Queued() != 1 || pool.Running() != 1 {
+		t.Errorf("Queued = %d, Running = %d", pool.Queued(), pool.Running())
+	}
+
+	// Cancelling frees the slot and finishes the task while the worker
+	// is still busy.
+	queued.Cancel()
+	if err := queued.Wait(); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled task = %v", err)
+	}
+	if pool.Queued() != 0 {
+		t.Errorf("Queued = %d after cancelling", pool.Queued())
+	}
+
+	parent, cancel := context.WithCancel(context.Background())
+	next, err := pool.Submit(Spec{Context: parent, Run: func(context.Context) error { return nil }})
+	if err != nil {
+		t.Fatalf("Submit after cancelling = %v", err)
+	}
+	cancel()
+	if err := next.Wait(); !errors.Is(err, context.Canceled) {
+		t.Errorf("task with a cancelled parent = %v", err)
+	}
+
+	close(release)
+
+	if err := pool.Drain(context.Background()); err != nil {
+		t.Errorf("Drain = %v", err)
+	}
+	if _, err := pool.Go(func(context.Context) error { return nil }); !errors.Is(err, ErrClosed) {
+		t.Errorf("Submit after Drain = %v", err)
+	}
+}
+
+func TestDrainDeadline(t *testing.T) {
+	pool := New(Options{Workers: 1})
+
+	task, _ := pool.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := pool.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Drain = %v", err)
+	}
+	if err := task.Wait(); !errors.Is(err, context.Canceled) {
+		t.Errorf("running task = %v", err)
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  map[string]string
+		queue   int
+		invalid bool
+	}{
+		{name: "defaults"},
+		{name: "configured", values: map[string]string{"jobs.workers": "3", "jobs.queue_size": "10"}, queue: 10},
+		{name: "out of range", values: map[string]string{"jobs.workers": "0"}, invalid: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := config.New("app")
+			Configure(cfg, "jobs")
+			for key, value := range test.values {
+				cfg.Set(config.File, key, value)
+			}
+
+			if err := cfg.Validate(); (err != nil) != test.invalid {
+				t.Fatalf("Validate = %v", err)
+			}
+			if test.invalid {
+				return
+			}
+
+			pool, err := FromConfig(cfg, "jobs")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pool.Stop()
+
+			if pool.limit != test.queue {
+				t.Errorf("queue limit = %d", pool.limit)
+			}
+		})
+	}
+}
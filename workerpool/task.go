@@ -0,0 +1,108 @@
+package workerpool
+
+import (
+	"context"
+	"fmt"
+)
+
+// Task tracks a submitted job.
+type Task struct {
+	spec    Spec
+	seq     uint64
+	index   int
+	ctx     context.Context
+	cancel  context.CancelFunc
+	started chan struct{}
+	done    chan struct{}
+	err     error
+}
+
+// Done is closed once the job has finished or was cancelled before it
+// started.
+func (t *Task) Done() <-chan struct{} {
+	return t.done
+}
+
+// Err returns the job's result. It must only be called after Done is
+// closed.
+func (t *Task) Err() error {
+	return t.err
+}
+
+// Wait blocks until the job has finished and returns its result.
+func (t *Task) Wait() error {
+	<-t.done
+	return t.err
+}
+
+// Cancel cancels the job's context. A job still in the queue leaves it
+// right away and finishes with context.Canceled.
+func (t *Task) Cancel() {
+	t.cancel()
+}
+
+// Priority returns the priority the job was submitted with.
+func (t *Task) Priority() Priority {
+	return t.spec.Priority
+}
+
+func (t *Task) run() {
+	defer close(t.done)
+	defer t.cancel()
+
+	if err := t.ctx.Err(); err != nil {
+		t.err = err
+		return
+	}
+
+	ctx := t.ctx
+	if t.spec.Timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, t.spec.Timeout)
+		defer cancel()
+	}
+
+	defer func() {
+		if reason := recover(); reason != nil {
+			t.err = fmt.Errorf("workerpool: job panicked: %v", reason)
+		}
+	}()
+
+	t.err = t.spec.Run(ctx)
+}
+
+// taskQueue is a heap ordered by priority, then by submission order.
+type taskQueue []*Task
+
+func (q taskQueue) Len() int {
+	return len(q)
+}
+
+func (q taskQueue) Less(i, j int) bool {
+	if q[i].spec.Priority != q[j].spec.Priority {
+		return q[i].spec.Priority > q[j].spec.Priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q taskQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *taskQueue) Push(item interface{}) {
+	task := item.(*Task)
+	task.index = len(*q)
+	*q = append(*q, task)
+}
+
+func (q *taskQueue) Pop() interface{} {
+	old := *q
+	task := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	task.index = -1
+	return task
+}